@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/matchmaking"
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrArenaNotFound = errors.New("arena not found")
+	ErrNotArena      = errors.New("not an arena game")
+	ErrBerserkLate   = errors.New("berserk is only allowed before your first move")
+)
+
+// OpenArena starts pairing the players who join an arena tournament, like a
+// queue of its own named by the tournament id. Its lobbies carry the arena so
+// that their games count for it. Opening an arena that is open already does
+// nothing. Arenas only pair on the node running matchmaking, which is the one
+// running tournaments.
+func (gc *Cache) OpenArena(arenaId uuid.UUID, variant game.Variant) error {
+	if _, open := gc.arenas.load(arenaId); open {
+		return nil
+	}
+	matchmaker, err := matchmaking.New(gc.cfg, variant.Players)
+	if err != nil {
+		return err
+	}
+	gc.arenas.loadOrStore(
+		arenaId, &Queue{
+			Name:       arenaId.String(),
+			Variant:    variant,
+			matchmaker: matchmaker,
+			arena:      arenaId,
+		},
+	)
+	return nil
+}
+
+// CloseArena stops pairing the players of an arena. Games started before
+// still count for it.
+func (gc *Cache) CloseArena(arenaId uuid.UUID) {
+	gc.arenas.delete(arenaId)
+}
+
+// JoinArena queues a session of a player for the next game of an arena. The
+// caller checks that the player entered it; the search is dropped if the
+// arena is not open on the node running matchmaking.
+func (gc *Cache) JoinArena(ctx context.Context, c *Client, arenaId uuid.UUID) error {
+	return gc.enqueue(ctx, c, arenaId.String())
+}
+
+// Berserk lets a player of an arena game go berserk before their first move,
+// wagering a point on the game, see the tournament package.
+func (gc *Cache) Berserk(ctx context.Context, lobbyId uuid.UUID, playerId uuid.UUID) error {
+	lobby, exists := gc.lobbies.load(lobbyId)
+	if !exists {
+		return ErrLobbyNotFound
+	}
+	if lobby.Node != gc.node {
+		return gc.forward(ctx, lobby, playerId, message.TypeBerserk, message.BerserkPayload{})
+	}
+
+	_, err := lobby.do(ctx, command{kind: cmdBerserk, playerId: playerId})
+	return err
+}
+
+// berserk records the berserk of a player on the lobby's goroutine. It
+// reports whether the player just went berserk, going berserk twice does
+// nothing.
+func (gc *Cache) berserk(ctx context.Context, lobby *Lobby, playerId uuid.UUID) (game.Color, bool, error) {
+	if lobby.Arena == uuid.Nil || lobby.Game == nil {
+		return game.ColorNone, false, ErrNotArena
+	}
+	if !lobby.started {
+		return game.ColorNone, false, ErrNotStarted
+	}
+	info, isPlayer := lobby.players[playerId]
+	if !isPlayer {
+		return game.ColorNone, false, ErrNotPlayer
+	}
+	if info.Berserk {
+		return info.Color, false, nil
+	}
+	for _, m := range lobby.Game.Moves {
+		if m.Color == info.Color {
+			return game.ColorNone, false, ErrBerserkLate
+		}
+	}
+
+	info.Berserk = true
+	lobby.players[playerId] = info
+	_ = gc.db.SetLobbyPlayerBerserk(ctx, sqlc.SetLobbyPlayerBerserkParams{LobbyID: lobby.Id, PlayerID: playerId})
+	return info.Color, true, nil
+}
+
+func (gc *Cache) announceBerserk(ctx context.Context, lobby *Lobby, color game.Color) {
+	gc.broadcast(
+		ctx, lobby, websockets.WriteRequest{
+			MsgType: message.TypeBerserked,
+			Payload: message.BerserkedPayload{Color: color},
+		},
+	)
+}
+
+// applyBerserk records a berserk announced by the owning node on a replica.
+func (l *Lobby) applyBerserk(color game.Color) {
+	for pId, info := range l.players {
+		if info.Color == color {
+			info.Berserk = true
+			l.players[pId] = info
+		}
+	}
+}
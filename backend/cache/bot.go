@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"backend/cluster"
+	"backend/game"
+	"backend/matchmaking"
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// BotId is the user the built-in bot plays as, created by the bots migration.
+var BotId = uuid.MustParse("00000000-0000-0000-0000-000000000b07")
+
+type BotFallback string
+
+const (
+	// BotFallbackOff keeps players waiting for a human opponent.
+	BotFallbackOff BotFallback = "off"
+	// BotFallbackOffer offers a bot game to players who waited too long,
+	// who accept it with an acceptBot message.
+	BotFallbackOffer BotFallback = "offer"
+	// BotFallbackAssign seats players who waited too long with a bot.
+	BotFallbackAssign BotFallback = "assign"
+)
+
+func ParseBotFallback(s string) (BotFallback, error) {
+	switch f := BotFallback(s); f {
+	case BotFallbackOff, BotFallbackOffer, BotFallbackAssign:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown bot fallback '%s'", s)
+}
+
+// botLevel picks the search depth of a bot that gives a player of the given
+// rating an even game.
+func botLevel(rating int32) int16 {
+	return int16(min(max((rating-900)/150, 1), 10))
+}
+
+// AcceptBot accepts a bot game offered to a player waiting in a queue.
+func (gc *Cache) AcceptBot(ctx context.Context, c *Client) {
+	if gc.bus == nil {
+		gc.readyPlayersQ <- search{playerId: c.Id, bot: true}
+		return
+	}
+	_ = gc.bus.Publish(ctx, cluster.Event{Kind: cluster.KindEnqueue, PlayerId: c.Id, Bot: true})
+}
+
+// botFallback seats players who waited longer than the configured timeout
+// with a bot, or offers them one. Only two-player queues have bots. It runs
+// on the matchmaking goroutine.
+func (gc *Cache) botFallback(ctx context.Context, q *Queue, now time.Time) {
+	fallback := BotFallback(gc.cfg.BotFallback)
+	if fallback == BotFallbackOff || q.Variant.Players != 2 {
+		return
+	}
+	for _, t := range q.matchmaker.Tickets() {
+		if now.Sub(t.QueuedAt) < gc.cfg.BotTimeout {
+			continue
+		}
+		if fallback == BotFallbackAssign {
+			gc.playBot(ctx, q, t)
+			continue
+		}
+		if _, offered := gc.botOffers[t.PlayerId]; offered {
+			continue
+		}
+		gc.botOffers[t.PlayerId] = q.Name
+		gc.SendTo(
+			ctx, t.PlayerId, websockets.WriteRequest{
+				MsgType: message.TypeBotOffer,
+				Payload: message.BotOfferPayload{Queue: q.Name},
+			},
+		)
+	}
+}
+
+func (gc *Cache) acceptBot(ctx context.Context, playerId uuid.UUID) {
+	name, offered := gc.botOffers[playerId]
+	if !offered {
+		return
+	}
+	q, _ := gc.queue(name)
+	for _, t := range q.matchmaker.Tickets() {
+		if t.PlayerId == playerId {
+			gc.playBot(ctx, q, t)
+			return
+		}
+	}
+}
+
+func (gc *Cache) playBot(ctx context.Context, q *Queue, t matchmaking.Ticket) {
+	q.matchmaker.Cancel(t.PlayerId)
+	delete(gc.botOffers, t.PlayerId)
+	options := LobbyOptions{Variant: q.Variant, BotLevel: botLevel(t.Rating)}
+	p := matchmaking.Pairing{Players: []matchmaking.Ticket{{PlayerId: BotId}, t}}
+	if !gc.seatPairing(ctx, options, p) && gc.queued(t.PlayerId) {
+		q.matchmaker.Enqueue(t)
+	}
+}
+
+// botTurn lets the bot of a lobby search for its move when it is to play. The
+// search runs on its own goroutine and posts the move back to the lobby, so
+// the lobby keeps serving its players meanwhile. It runs on the lobby's
+// goroutine.
+func (gc *Cache) botTurn(ctx context.Context, lobby *Lobby) {
+	if lobby.botLevel == 0 || !lobby.started || lobby.over || lobby.Node != gc.node {
+		return
+	}
+	color := lobby.players[BotId].Color
+	if lobby.Game.ToMove() != color {
+		return
+	}
+	board, depth := *lobby.Game.State, int(lobby.botLevel)
+	go func() {
+		if col, ok := game.BestMove(board, color, depth); ok {
+			_ = lobby.post(ctx, command{kind: cmdMove, playerId: BotId, column: col})
+		}
+	}()
+}
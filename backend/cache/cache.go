@@ -1,237 +1,809 @@
 package cache
 
 import (
+	"backend/cluster"
+	"backend/config"
 	"backend/game"
 	"backend/generated/sqlc"
+	"backend/matchmaking"
 	"backend/message"
+	"backend/rating"
 	"backend/websockets"
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/coder/websocket"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Cache keeps the lobbies and connected players of this node. Every lobby is
+// run by its own goroutine, which is the only one touching the lobby's players
+// and game; everything else talks to it through Lobby commands.
+//
+// A player may be connected several times, each connection is a session with
+// its own Client, and may play several games at once. Lobbies send their
+// messages to the sessions that joined them rather than to every session of
+// their players.
 type Cache struct {
-	mutex         sync.RWMutex
-	connections   map[uuid.UUID]*Client
-	readyPlayersQ chan uuid.UUID
-	idleLobbies   map[uuid.UUID]*Lobby
-	inGameLobbies map[uuid.UUID]*Lobby
-	db            *sqlc.Queries
-	conn          *pgxpool.Pool
+	ctx  context.Context
+	stop context.CancelFunc
+	// connections maps players to the clients of their sessions and seats
+	// to the lobbies they play in, both are replaced rather than modified
+	connections   *registry[[]*Client]
+	lobbies       *registry[*Lobby]
+	seats         *registry[[]*Lobby]
+	readyPlayersQ chan search
+	// searching maps players searching for a game to the session they
+	// searched from
+	searching *registry[uuid.UUID]
+	queues    []*Queue
+	// arenas are the queues of the running arena tournaments by tournament
+	// id, opened on the node running matchmaking
+	arenas     *registry[*Queue]
+	statsMutex sync.RWMutex
+	stats      map[string]matchmaking.Stats
+	db         *sqlc.Queries
+	conn       *pgxpool.Pool
+	cfg        config.MatchmakingConfig
+	wsCfg      config.WebsocketsConfig
+
+	// node names this instance when several share the database, bus is nil
+	// when running alone
+	node         string
+	bus          *cluster.Bus
+	leading      atomic.Bool
+	remoteQueued *registry[string]
+
+	logger echo.Logger
+
+	// botOffers maps players offered a bot game to their queue, it is owned
+	// by the matchmaking goroutine
+	botOffers map[uuid.UUID]string
 }
 
+var (
+	ErrLobbyNotFound = errors.New("lobby not found")
+	ErrLobbyFull     = errors.New("lobby is full")
+	ErrNotPlayer     = errors.New("not a player in this lobby")
+	ErrNotStarted    = errors.New("game has not started")
+	ErrLobbyExists   = errors.New("lobby exists already")
+)
+
 type Lobby struct {
 	Id           uuid.UUID
-	players      map[uuid.UUID]PlayerInfo
-	broadcast    chan websockets.WriteRequest
-	Game         *game.Game
-	moves        chan game.Move
-	Messages     []message.ChatMessagePayload
+	Node         string
+	Private      bool
+	Owner        uuid.UUID
+	OwnerColor   game.Color
+	Variant      game.Variant
 	CreatedAtUtc time.Time
+	// Arena is the arena tournament the lobby's game counts for, if any
+	Arena uuid.UUID
+
+	// owned by the lobby's goroutine once it runs
+	Game    *game.Game
+	Multi   *game.MultiGame
+	players map[uuid.UUID]PlayerInfo
+	// clients are the sessions following the game, by session id
+	clients  map[uuid.UUID]*Client
+	messages []message.ChatMessagePayload
+	started  bool
+	over     bool
+	// seq is the sequence number of the last message broadcast, replicas
+	// take it from the owner's messages
+	seq uint64
+	// history keeps the last messages broadcast, numbered without gaps, for
+	// clients resuming after a reconnect
+	history []websockets.WriteRequest
+	// botLevel is the search depth of the bot playing in the lobby, or 0
+	// when only humans play
+	botLevel int16
+
+	commands chan command
+	done     chan struct{}
 }
 
 type PlayerInfo struct {
 	Color game.Color
+	// Berserk is set when the player went berserk in an arena game.
+	Berserk bool
 }
 
-func NewLobby() (*Lobby, error) {
-	lobbyId, err := uuid.NewV7()
-	if err != nil {
-		return nil, err
+type LobbyOptions struct {
+	// Id is the id of the new lobby, one is generated when it is not set.
+	// Callers that record the id before starting the lobby set it.
+	Id          uuid.UUID
+	Variant     game.Variant
+	Private     bool
+	Owner       uuid.UUID
+	OwnerColor  game.Color
+	Start       game.Board
+	StartToMove game.Color
+	BotLevel    int16
+	Arena       uuid.UUID
+}
+
+func NewLobby(options LobbyOptions) (*Lobby, error) {
+	var err error
+	lobbyId := options.Id
+	if lobbyId == uuid.Nil {
+		if lobbyId, err = uuid.NewV7(); err != nil {
+			return nil, err
+		}
+	}
+	variant := options.Variant
+	if variant.Name == "" {
+		variant = game.Variants[game.VariantStandard]
+	}
+	var g *game.Game
+	var multi *game.MultiGame
+	if variant.Players > 2 {
+		multi, err = game.NewMulti(variant)
+	} else {
+		toMove := options.StartToMove
+		if toMove == game.ColorNone {
+			toMove = game.ColorRed
+		}
+		g, err = game.NewFromPosition(options.Start, toMove)
 	}
-	g, err := game.New()
 	if err != nil {
 		return nil, err
 	}
 
 	return &Lobby{
 		Id:           lobbyId,
-		players:      make(map[uuid.UUID]PlayerInfo),
-		broadcast:    make(chan websockets.WriteRequest),
-		Game:         g,
-		moves:        make(chan game.Move),
-		Messages:     make([]message.ChatMessagePayload, 0),
+		Private:      options.Private,
+		Owner:        options.Owner,
+		OwnerColor:   options.OwnerColor,
+		Variant:      variant,
 		CreatedAtUtc: time.Now().UTC(),
+		Arena:        options.Arena,
+		Game:         g,
+		Multi:        multi,
+		botLevel:     options.BotLevel,
+		players:      make(map[uuid.UUID]PlayerInfo),
+		clients:      make(map[uuid.UUID]*Client),
+		messages:     make([]message.ChatMessagePayload, 0),
+		commands:     make(chan command),
+		done:         make(chan struct{}),
 	}, nil
 }
 
+// Seat is handed to a player's client when their game starts or when they
+// reconnect to it.
+type Seat struct {
+	Lobby    *Lobby
+	Color    game.Color
+	Snapshot Snapshot
+	Messages []message.ChatMessagePayload
+	Bot      bool
+	// Seq is the sequence number of the last message the snapshot includes,
+	// zero if a replica has not learnt it yet
+	Seq uint64
+	// Resumed is set when the client resumed the game, it gets the messages
+	// it missed in Replay instead of the snapshot
+	Resumed bool
+	Replay  []websockets.WriteRequest
+}
+
+// writeRequests returns the messages a client gets for its seat: the game's
+// snapshot, or the messages it missed when it resumed.
+func (s Seat) writeRequests() []websockets.WriteRequest {
+	if s.Resumed {
+		return s.Replay
+	}
+	lobby := s.Lobby
+	return []websockets.WriteRequest{
+		{
+			MsgType: message.TypeFoundGame,
+			Payload: message.FoundGamePayload{
+				LobbyId:    lobby.Id.String(),
+				Variant:    lobby.Variant.Name,
+				State:      s.Snapshot.State,
+				LastPlayed: s.Snapshot.LastPlayed,
+				ToMove:     s.Snapshot.ToMove,
+				Color:      s.Color,
+				Messages:   s.Messages,
+				Bot:        s.Bot,
+			},
+			LobbyId: lobby.Id,
+			Seq:     s.Seq,
+		},
+	}
+}
+
+// Resume maps the lobbies a reconnecting client followed to the sequence
+// number of the last message it got from each.
+type Resume map[uuid.UUID]uint64
+
+// Client is one session of a player. Seats of the games it joins arrive on
+// Notify, and the ids of those games once they are over on Released. Lobbies
+// queue their messages in the client's outbox, its own replies go straight
+// to WriteRequests.
 type Client struct {
 	Id            uuid.UUID
+	Session       uuid.UUID
 	Socket        *websocket.Conn
-	Notify        chan *Lobby
+	Notify        chan Seat
 	WriteRequests chan websockets.WriteRequest
-	Unregister    chan struct{}
+	Released      chan uuid.UUID
+	resume        Resume
+	outbox        *outbox
+	done          chan struct{}
+	leave         sync.Once
+	overflow      sync.Once
 }
 
-func NewClient(playerId uuid.UUID, ws *websocket.Conn) *Client {
-	return &Client{
+func NewClient(playerId uuid.UUID, ws *websocket.Conn, cfg config.WebsocketsConfig) *Client {
+	c := &Client{
 		Id:            playerId,
+		Session:       uuid.New(),
 		Socket:        ws,
-		Notify:        make(chan *Lobby, 1),
+		Notify:        make(chan Seat),
 		WriteRequests: make(chan websockets.WriteRequest),
-		Unregister:    make(chan struct{}),
+		Released:      make(chan uuid.UUID),
+		outbox:        newOutbox(cfg.SendQueue, SlowConsumer(cfg.SlowConsumer)),
+		done:          make(chan struct{}),
+	}
+	go c.pump()
+	return c
+}
+
+// Send queues a message for the client without waiting for its writer, behind
+// the lobby messages queued before it.
+func (c *Client) Send(wr websockets.WriteRequest) {
+	c.queue(outboxItem{wr: wr})
+}
+
+// notify queues a seat for the client, its game's snapshot or the messages
+// it missed are written once the client's handler took the seat.
+func (c *Client) notify(seat Seat) {
+	c.queue(outboxItem{seat: &seat})
+}
+
+func (c *Client) queue(item outboxItem) {
+	if c.gone() || c.outbox.push(item) {
+		return
+	}
+	// the client cannot keep up, closing its socket ends its handler
+	c.overflow.Do(
+		func() {
+			if c.Socket != nil {
+				go c.Socket.Close(websocket.StatusTryAgainLater, "slow consumer")
+			}
+		},
+	)
+}
+
+// stop ends the client's pump and anything waiting on it.
+func (c *Client) stop() {
+	c.leave.Do(func() { close(c.done) })
+}
+
+// gone reports whether the client has left.
+func (c *Client) gone() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// connect makes a client reachable for messages sent to its player.
+func (gc *Cache) connect(c *Client) {
+	gc.connections.update(
+		c.Id, func(clients []*Client, _ bool) ([]*Client, bool) {
+			if slices.Contains(clients, c) {
+				return clients, true
+			}
+			return append(slices.Clone(clients), c), true
+		},
+	)
+}
+
+func (gc *Cache) disconnect(c *Client) {
+	gc.connections.update(
+		c.Id, func(clients []*Client, _ bool) ([]*Client, bool) {
+			clients = slices.DeleteFunc(slices.Clone(clients), func(current *Client) bool { return current == c })
+			return clients, len(clients) > 0
+		},
+	)
+}
+
+// clients returns the connected sessions of a player.
+func (gc *Cache) clients(playerId uuid.UUID) []*Client {
+	clients, _ := gc.connections.load(playerId)
+	return clients
+}
+
+// seatClients returns the sessions that should follow a player's new game:
+// the one the player searched from while it is connected, every session
+// otherwise.
+func (gc *Cache) seatClients(playerId uuid.UUID) []*Client {
+	clients := gc.clients(playerId)
+	if session, searching := gc.searching.load(playerId); searching {
+		for _, c := range clients {
+			if c.Session == session {
+				return []*Client{c}
+			}
+		}
 	}
+	return clients
 }
 
-func NewDefaultCache(db *sqlc.Queries, conn *pgxpool.Pool) *Cache {
+func NewDefaultCache(db *sqlc.Queries, conn *pgxpool.Pool, cfg config.MatchmakingConfig, wsCfg config.WebsocketsConfig, queues []*Queue, bus *cluster.Bus, logger echo.Logger) *Cache {
+	node := ""
+	if bus != nil {
+		node = bus.Node
+	}
+	ctx, stop := context.WithCancel(context.Background())
 	return &Cache{
-		mutex:         sync.RWMutex{},
-		connections:   make(map[uuid.UUID]*Client),
-		readyPlayersQ: make(chan uuid.UUID, 100),
-		idleLobbies:   make(map[uuid.UUID]*Lobby),
-		inGameLobbies: make(map[uuid.UUID]*Lobby),
+		ctx:           ctx,
+		stop:          stop,
+		connections:   newRegistry[[]*Client](),
+		lobbies:       newRegistry[*Lobby](),
+		seats:         newRegistry[[]*Lobby](),
+		readyPlayersQ: make(chan search, 100),
+		searching:     newRegistry[uuid.UUID](),
+		queues:        queues,
+		arenas:        newRegistry[*Queue](),
+		stats:         make(map[string]matchmaking.Stats),
 		db:            db,
 		conn:          conn,
+		cfg:           cfg,
+		wsCfg:         wsCfg,
+		node:          node,
+		bus:           bus,
+		remoteQueued:  newRegistry[string](),
+		logger:        logger,
+		botOffers:     make(map[uuid.UUID]string),
 	}
 }
 
+// Close stops every lobby of the cache.
+func (gc *Cache) Close() {
+	gc.stop()
+}
+
+// RunMatchmaking pairs up the players queued on this node, or on every node
+// while it leads the cluster, and seats each pairing in a new lobby. Every
+// queue is paired independently.
 func (gc *Cache) RunMatchmaking(ctx context.Context) {
-	lobby, err := NewLobby()
-	if err != nil {
-		panic(err)
-	}
-	gc.idleLobbies[lobby.Id] = lobby
+	gc.leading.Store(true)
+	defer gc.leading.Store(false)
 
+	ticker := time.NewTicker(gc.cfg.TickInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case rpId := <-gc.readyPlayersQ:
-			gc.mutex.RLock()
-			_, connected := gc.connections[rpId]
-			if !connected {
-				gc.mutex.RUnlock()
-				continue
+		case s := <-gc.readyPlayersQ:
+			gc.handleSearch(ctx, s)
+		case now := <-ticker.C:
+			for _, q := range gc.queues {
+				gc.pair(ctx, q, now)
+				gc.botFallback(ctx, q, now)
 			}
-			lobby.players[rpId] = PlayerInfo{Color: game.Color(len(lobby.players) + 1)}
-			if len(lobby.players) < 2 {
-				gc.mutex.RUnlock()
-				continue
+			for _, q := range gc.arenas.values() {
+				gc.pair(ctx, q, now)
 			}
+		}
+		gc.updateStats(ctx)
+	}
+}
 
-			go gc.startGame(ctx, lobby.Id)
+func (gc *Cache) handleSearch(ctx context.Context, s search) {
+	if s.bot {
+		gc.acceptBot(ctx, s.playerId)
+		return
+	}
+	gc.dequeue(s.playerId)
+	q, exists := gc.queue(s.queue)
+	if s.cancel || !exists || !gc.queued(s.playerId) {
+		return
+	}
+	q.matchmaker.Enqueue(gc.ticket(ctx, s.playerId))
+	gc.pair(ctx, q, time.Now())
+}
+
+func (gc *Cache) dequeue(playerId uuid.UUID) {
+	delete(gc.botOffers, playerId)
+	for _, q := range gc.queues {
+		q.matchmaker.Cancel(playerId)
+	}
+	for _, q := range gc.arenas.values() {
+		q.matchmaker.Cancel(playerId)
+	}
+}
 
-			delete(gc.idleLobbies, lobby.Id)
-			gc.inGameLobbies[lobby.Id] = lobby
-			for pId := range lobby.players {
-				gc.connections[pId].Notify <- lobby
+func (gc *Cache) ticket(ctx context.Context, playerId uuid.UUID) matchmaking.Ticket {
+	t := matchmaking.Ticket{
+		PlayerId: playerId,
+		Rating:   rating.DefaultRating,
+		QueuedAt: time.Now(),
+	}
+	if user, err := gc.db.GetUserById(ctx, playerId); err == nil {
+		t.Rating = user.Rating
+	}
+	return t
+}
+
+// pair seats the players the queue's matchmaker grouped. When a player of a
+// group is gone by now, the others are queued again with their original
+// tickets.
+func (gc *Cache) pair(ctx context.Context, q *Queue, now time.Time) {
+	for _, p := range q.matchmaker.Tick(now) {
+		if gc.seatPairing(ctx, LobbyOptions{Variant: q.Variant, Arena: q.arena}, p) {
+			for _, t := range p.Players {
+				q.recordWait(now.Sub(t.QueuedAt))
+				delete(gc.botOffers, t.PlayerId)
+			}
+			continue
+		}
+		for _, t := range p.Players {
+			if gc.queued(t.PlayerId) {
+				q.matchmaker.Enqueue(t)
 			}
-			gc.mutex.RUnlock()
+		}
+	}
+}
+
+func (gc *Cache) seatPairing(ctx context.Context, options LobbyOptions, p matchmaking.Pairing) bool {
+	for _, t := range p.Players {
+		if !gc.queued(t.PlayerId) {
+			return false
+		}
+	}
+	lobby, err := gc.newLocalLobby(options)
+	if err != nil {
+		return false
+	}
+	gc.spawn(lobby)
+
+	started := false
+	for _, t := range p.Players {
+		playerId := t.PlayerId
+		started, err = lobby.join(ctx, playerId, nil, func() bool { return gc.queued(playerId) })
+		if err != nil {
+			break
+		}
+	}
+	if !started {
+		lobby.disband()
+	}
+	return started
+}
 
-			lobby, err = NewLobby()
-			if err != nil {
-				panic(err)
+// queued reports whether a player is still searching for a game on this node
+// or on another one. The bot is always ready for a game.
+func (gc *Cache) queued(playerId uuid.UUID) bool {
+	if playerId == BotId {
+		return true
+	}
+	if _, searching := gc.searching.load(playerId); searching {
+		return true
+	}
+	_, queuedRemotely := gc.remoteQueued.load(playerId)
+	return queuedRemotely
+}
+
+// Join connects a new session of a player. The session is handed the seats
+// of the games the player is playing right away, and Join reports whether
+// there were any; the session can search for more games either way.
+func (gc *Cache) Join(ctx context.Context, playerId uuid.UUID, ws *websocket.Conn, resume Resume) (*Client, bool) {
+	c := NewClient(playerId, ws, gc.wsCfg)
+	c.resume = resume
+	gc.connect(c)
+
+	seated := false
+	lobbies, _ := gc.seats.load(playerId)
+	for _, lobby := range lobbies {
+		if _, err := lobby.join(ctx, playerId, c, nil); err == nil {
+			seated = true
+		}
+	}
+	if gc.bus != nil {
+		// the player may be in games owned by other nodes
+		replicas, _ := gc.replicate(ctx, playerId, uuid.Nil)
+		for _, lobby := range replicas {
+			if _, err := lobby.join(ctx, playerId, c, nil); err == nil {
+				seated = true
 			}
-			gc.idleLobbies[lobby.Id] = lobby
 		}
 	}
+
+	return c, seated
+}
+
+func (gc *Cache) newLocalLobby(options LobbyOptions) (*Lobby, error) {
+	lobby, err := NewLobby(options)
+	if err != nil {
+		return nil, err
+	}
+	lobby.Node = gc.node
+	return lobby, nil
 }
 
-func (gc *Cache) Join(playerId uuid.UUID, ws *websocket.Conn) *Client {
-	c := NewClient(playerId, ws)
-	gc.mutex.Lock()
-	gc.connections[playerId] = c
-	gc.mutex.Unlock()
+// spawn registers a lobby and starts the goroutine running it.
+func (gc *Cache) spawn(lobby *Lobby) {
+	gc.lobbies.store(lobby.Id, lobby)
+	go gc.runLobby(gc.ctx, lobby)
+}
 
-	for _, lobby := range gc.inGameLobbies {
-		for pId := range lobby.players {
-			if playerId == pId {
-				c.Notify <- lobby
-				return c
+// CreateLobby registers a lobby that players can only enter by its id.
+func (gc *Cache) CreateLobby(options LobbyOptions) (*Lobby, error) {
+	lobby, err := gc.newLocalLobby(options)
+	if err != nil {
+		return nil, err
+	}
+	gc.spawn(lobby)
+
+	return lobby, nil
+}
+
+// JoinLobby connects a player to a lobby created with CreateLobby, starting
+// the game once every seat is taken.
+func (gc *Cache) JoinLobby(ctx context.Context, lobbyId uuid.UUID, playerId uuid.UUID, ws *websocket.Conn, resume Resume) (*Client, error) {
+	lobby, exists := gc.lobbies.load(lobbyId)
+	if !exists && gc.bus == nil {
+		return nil, ErrLobbyNotFound
+	}
+	c := NewClient(playerId, ws, gc.wsCfg)
+	c.resume = resume
+	if !exists {
+		// another node may own the lobby, it will announce the game once
+		// the lobby fills up
+		gc.connect(c)
+		err := gc.bus.Publish(ctx, cluster.Event{Kind: cluster.KindJoin, LobbyId: lobbyId, PlayerId: playerId})
+		if err != nil {
+			gc.Leave(c)
+			return nil, err
+		}
+		return c, nil
+	}
+	if _, err := lobby.join(ctx, playerId, c, nil); err != nil {
+		c.stop()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// startLobby moves a full lobby into play. It runs on the lobby's goroutine.
+func (gc *Cache) startLobby(ctx context.Context, lobby *Lobby) {
+	gc.assignColors(ctx, lobby)
+	gc.save(ctx, lobby)
+	lobby.started = true
+	players := make([]uuid.UUID, 0, len(lobby.players))
+	for pId, info := range lobby.players {
+		players = append(players, pId)
+		// players who joined without a client get their game on the
+		// sessions that asked for it
+		if !lobby.attached(pId) {
+			for _, c := range gc.seatClients(pId) {
+				gc.register(lobby, c)
+			}
+		}
+		gc.searching.delete(pId)
+		gc.remoteQueued.delete(pId)
+		for _, c := range lobby.clients {
+			if c.Id == pId {
+				c.notify(lobby.seat(info.Color))
 			}
 		}
 	}
+	if gc.bus != nil {
+		_ = gc.bus.Publish(ctx, cluster.Event{Kind: cluster.KindStarted, LobbyId: lobby.Id, Players: players})
+	}
+}
 
-	gc.readyPlayersQ <- c.Id
+// Leave disconnects a client. A search started from the client is
+// cancelled. Once the player's last session is gone, the player gives up the
+// seats of games that have not started yet, while running games can be
+// reconnected to later.
+func (gc *Cache) Leave(c *Client) {
+	c.stop()
+	gc.disconnect(c)
 
-	return c
+	if gc.Searching(c) {
+		gc.CancelSearch(context.Background(), c)
+	}
+	if len(gc.clients(c.Id)) > 0 {
+		return
+	}
+	lobbies, _ := gc.seats.load(c.Id)
+	for _, lobby := range lobbies {
+		lobby.leave(c.Id)
+	}
+}
+
+// Searching reports whether a search started from the client is still going,
+// it ends once the client's player is seated in a game.
+func (gc *Cache) Searching(c *Client) bool {
+	session, searching := gc.searching.load(c.Id)
+	return searching && session == c.Session
+}
+
+// SendTo delivers a message to a player outside of any lobby, on every
+// session of the player wherever they are connected. Players who are not
+// connected miss it.
+func (gc *Cache) SendTo(ctx context.Context, playerId uuid.UUID, wr websockets.WriteRequest) {
+	if clients := gc.clients(playerId); len(clients) > 0 {
+		for _, c := range clients {
+			c.Send(wr)
+		}
+		return
+	}
+	if gc.bus != nil {
+		gc.publishBroadcast(ctx, uuid.Nil, playerId, wr)
+	}
 }
 
-func (gc *Cache) PlayerInfo(lobbyId uuid.UUID, playerId uuid.UUID) PlayerInfo {
-	return gc.inGameLobbies[lobbyId].players[playerId]
+type MoveResult struct {
+	Row  int
+	Over bool
+	// Winner is the first player to connect, or ColorNone for a draw.
+	Winner game.Color
+	// Finished is set when a player connected in an elimination game that
+	// goes on without them, along with the place they finished in.
+	Finished game.Color
+	Place    int
+	Ranking  []game.Color
 }
 
-func (gc *Cache) Leave(playerId uuid.UUID) {
-	gc.mutex.Lock()
-	defer gc.mutex.Unlock()
-	if client, exists := gc.connections[playerId]; exists {
-		close(client.Notify)
-		close(client.WriteRequests)
+// Move plays a column for a player and broadcasts the outcome to the lobby.
+// Moves in lobbies owned by another node are forwarded to it, and that node
+// reports any error back to the player.
+func (gc *Cache) Move(ctx context.Context, lobbyId uuid.UUID, playerId uuid.UUID, column uint8) error {
+	lobby, exists := gc.lobbies.load(lobbyId)
+	if !exists {
+		return ErrLobbyNotFound
+	}
+	if lobby.Node != gc.node {
+		return gc.forward(ctx, lobby, playerId, message.TypePlayMove, message.PlayMovePayload{Column: column})
 	}
 
-	delete(gc.connections, playerId)
+	_, err := lobby.do(ctx, command{kind: cmdMove, playerId: playerId, column: column})
+	return err
 }
 
-func (gc *Cache) Send(lobbyId uuid.UUID, wr websockets.WriteRequest) {
-	gc.inGameLobbies[lobbyId].broadcast <- wr
+// move plays a column on the lobby's goroutine. The outcome is broadcast by
+// announce once the player got their answer.
+func (gc *Cache) move(ctx context.Context, lobby *Lobby, playerId uuid.UUID, column uint8) (played, error) {
+	if !lobby.started {
+		return played{}, ErrNotStarted
+	}
+	info, isPlayer := lobby.players[playerId]
+	if !isPlayer {
+		return played{}, ErrNotPlayer
+	}
+	result, err := gc.play(ctx, lobby, info.Color, column)
+	if err != nil {
+		return played{}, err
+	}
+	return played{color: info.Color, column: column, result: result}, nil
 }
 
-func (gc *Cache) Play(lobbyId uuid.UUID, playerId uuid.UUID, column uint8) (int, bool, error) {
-	lobby := gc.inGameLobbies[lobbyId]
+type played struct {
+	color  game.Color
+	column uint8
+	result MoveResult
+}
+
+func (gc *Cache) announce(ctx context.Context, lobby *Lobby, p played) {
+	gc.broadcast(
+		ctx, lobby, websockets.WriteRequest{
+			MsgType: message.TypePlayedMove,
+			Payload: message.PlayedMovePayload{
+				Color:  p.color,
+				Row:    uint8(p.result.Row),
+				Column: p.column,
+			},
+		},
+	)
+	if p.result.Finished != game.ColorNone {
+		gc.broadcast(
+			ctx, lobby, websockets.WriteRequest{
+				MsgType: message.TypePlayerFinished,
+				Payload: message.PlayerFinishedPayload{Color: p.result.Finished, Place: p.result.Place},
+			},
+		)
+	}
+	if p.result.Over {
+		gc.broadcast(
+			ctx, lobby, websockets.WriteRequest{
+				MsgType: message.TypeGameOver,
+				Payload: message.GameOverPayload{Winner: p.result.Winner, Ranking: p.result.Ranking},
+			},
+		)
+	}
+}
+
+func (gc *Cache) play(ctx context.Context, lobby *Lobby, color game.Color, column uint8) (MoveResult, error) {
 	move := game.Move{
 		Column: column,
-		Color:  lobby.players[playerId].Color,
+		Color:  color,
 	}
-	return lobby.Game.Make(move)
+
+	if lobby.Multi == nil {
+		row, isWinningMove, err := lobby.Game.Make(move)
+		if err != nil {
+			return MoveResult{Row: row}, err
+		}
+		gc.checkpoint(ctx, lobby)
+		if !isWinningMove {
+			if lobby.Game.State.Full() {
+				return MoveResult{Row: row, Over: true}, nil
+			}
+			return MoveResult{Row: row}, nil
+		}
+		return MoveResult{Row: row, Over: true, Winner: move.Color, Ranking: []game.Color{move.Color}}, nil
+	}
+
+	r, err := lobby.Multi.Make(move)
+	if err != nil {
+		return MoveResult{}, err
+	}
+	gc.checkpoint(ctx, lobby)
+	result := MoveResult{Row: r.Row, Over: r.Over, Ranking: r.Ranking}
+	if r.Over && len(r.Ranking) > 0 {
+		result.Winner = r.Ranking[0]
+	}
+	if r.Connected && !r.Over {
+		result.Finished = move.Color
+		result.Place = r.Place
+	}
+	return result, nil
 }
 
-func (gc *Cache) startGame(ctx context.Context, lobbyId uuid.UUID) {
-	lobby := gc.inGameLobbies[lobbyId]
-	startedAtUtc := time.Now().UTC()
-	for {
-		select {
-		case <-ctx.Done():
+// broadcast delivers a message to the lobby's players connected to this node.
+// The owning node also publishes it for the other nodes, while replicas apply
+// the moves it announces. It runs on the lobby's goroutine.
+func (gc *Cache) broadcast(ctx context.Context, lobby *Lobby, wr websockets.WriteRequest) {
+	owner := lobby.Node == gc.node
+	if owner {
+		lobby.seq++
+		wr.Seq = lobby.seq
+	} else if wr.Seq != 0 {
+		lobby.seq = wr.Seq
+	}
+	wr.LobbyId = lobby.Id
+	lobby.remember(wr)
+	if owner && gc.bus != nil {
+		gc.publishBroadcast(ctx, lobby.Id, uuid.Nil, wr)
+	}
+	if !owner {
+		switch wr.MsgType {
+		case message.TypePlayedMove:
+			lobby.apply(wr.Payload.(message.PlayedMovePayload))
+		case message.TypeBerserked:
+			lobby.applyBerserk(wr.Payload.(message.BerserkedPayload).Color)
+		}
+	}
+
+	for session, c := range lobby.clients {
+		if c.gone() {
+			delete(lobby.clients, session)
+			continue
+		}
+		c.Send(wr)
+	}
+
+	switch wr.MsgType {
+	case message.TypeGameOver:
+		lobby.over = true
+		if !owner {
 			return
-		case wr := <-lobby.broadcast:
-			for pId := range lobby.players {
-				gc.mutex.RLock()
-				c, connected := gc.connections[pId]
-				gc.mutex.RUnlock()
-				if !connected {
-					continue
-				}
-				c.WriteRequests <- wr
-				time.Sleep(100 * time.Millisecond)
-				if wr.MsgType == message.TypeGameOver {
-					c.Unregister <- struct{}{}
-				}
-			}
-			if wr.MsgType == message.TypeGameOver {
-				delete(gc.inGameLobbies, lobbyId)
-				players := [2]uuid.UUID{}
-				i := 0
-				for pId := range lobby.players {
-					players[i] = pId
-					i++
-				}
-				now := time.Now().UTC()
-				_ = gc.db.CreateLobby(
-					ctx, sqlc.CreateLobbyParams{
-						ID:           lobbyId,
-						Player1ID:    players[0],
-						Player2ID:    players[1],
-						CreatedAtUtc: lobby.CreatedAtUtc,
-					},
-				)
-				_ = gc.db.CreateGame(
-					ctx, sqlc.CreateGameParams{
-						ID:           lobby.Game.Id,
-						LobbyID:      lobbyId,
-						StartedAtUtc: &startedAtUtc,
-						EndedAtUtc:   &now,
-						State:        lobby.Game.State.StrState(),
-					},
-				)
-			}
-			if wr.MsgType == message.TypeChat {
-				var msg message.ChatMessagePayload
-				_ = json.Unmarshal(wr.Payload.(json.RawMessage), &msg)
-				lobby.Messages = append(lobby.Messages, msg)
-			}
 		}
+		gc.finish(ctx, lobby, wr.Payload.(message.GameOverPayload).Winner)
+	case message.TypeChat:
+		lobby.messages = append(lobby.messages, wr.Payload.(message.ChatMessagePayload))
 	}
 }
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+// StartChallenge seats the players of an accepted challenge, of a tournament
+// pairing or of a simul board in a new lobby and starts their game right
+// away, without going through matchmaking.
+// Players connected to a node are handed their seat at once, the others when
+// they connect. A lobby whose id is set in the options is only started if
+// this node has no lobby with that id yet.
+func (gc *Cache) StartChallenge(ctx context.Context, options LobbyOptions, players ...uuid.UUID) (*Lobby, error) {
+	if _, exists := gc.lobbies.load(options.Id); exists && options.Id != uuid.Nil {
+		return nil, ErrLobbyExists
+	}
+	lobby, err := gc.newLocalLobby(options)
+	if err != nil {
+		return nil, err
+	}
+	gc.spawn(lobby)
+
+	started := false
+	for _, pId := range players {
+		if started, err = lobby.join(ctx, pId, nil, nil); err != nil {
+			break
+		}
+	}
+	if !started {
+		lobby.disband()
+		if err == nil {
+			err = ErrNotStarted
+		}
+		return nil, err
+	}
+	return lobby, nil
+}
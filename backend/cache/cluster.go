@@ -0,0 +1,293 @@
+package cache
+
+import (
+	"backend/cluster"
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"encoding/json"
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+)
+
+// RunCluster handles events published by the other nodes sharing the
+// database. Lobbies are owned by the node that created them: moves and chat
+// from players connected elsewhere are forwarded to the owner, and the owner
+// publishes everything it broadcasts so that other nodes can relay it to
+// their sockets and keep replicas of the lobby up to date.
+//
+// Ownership is never handed over. Unlike the matchmaking lock, which another
+// node takes once its holder is gone, a lobby lives only in the memory of its
+// owner: if that node goes away, its unfinished games stay open in the
+// database but nothing accepts their moves, replicas keep showing the last
+// position, and forwarded commands, forfeits included, go unanswered.
+func (gc *Cache) RunCluster(ctx context.Context) {
+	if gc.bus == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-gc.bus.Events():
+			gc.handleEvent(ctx, e)
+		}
+	}
+}
+
+func (gc *Cache) handleEvent(ctx context.Context, e cluster.Event) {
+	switch e.Kind {
+	case cluster.KindEnqueue:
+		if !gc.leading.Load() {
+			return
+		}
+		gc.remoteQueued.store(e.PlayerId, e.Node)
+		gc.readyPlayersQ <- search{playerId: e.PlayerId, queue: e.Queue, bot: e.Bot}
+
+	case cluster.KindDequeue:
+		// the leader queues its own players through the bus too, so their
+		// dequeues have to reach matchmaking the same way
+		gc.remoteQueued.deleteFunc(e.PlayerId, func(node string) bool { return node == e.Node })
+		if gc.leading.Load() {
+			gc.readyPlayersQ <- search{playerId: e.PlayerId, cancel: true}
+		}
+		if e.Node == gc.node {
+			return
+		}
+		lobbies, _ := gc.seats.load(e.PlayerId)
+		for _, lobby := range lobbies {
+			if lobby.Node == gc.node {
+				lobby.leave(e.PlayerId)
+			}
+		}
+
+	case cluster.KindJoin:
+		lobby, exists := gc.lobbies.load(e.LobbyId)
+		if !exists || lobby.Node != gc.node {
+			return
+		}
+		_, _ = lobby.join(ctx, e.PlayerId, nil, nil)
+
+	case cluster.KindStarted:
+		if e.Node == gc.node {
+			return
+		}
+		for _, pId := range e.Players {
+			clients := gc.seatClients(pId)
+			gc.searching.delete(pId)
+			if len(clients) == 0 {
+				continue
+			}
+			lobbies, err := gc.replicate(ctx, pId, e.LobbyId)
+			if err != nil {
+				continue
+			}
+			for _, lobby := range lobbies {
+				for _, c := range clients {
+					_, _ = lobby.join(ctx, pId, c, nil)
+				}
+			}
+		}
+
+	case cluster.KindQueues:
+		if e.Node != gc.node {
+			gc.setStats(e.Stats)
+		}
+
+	case cluster.KindCommand:
+		gc.execute(ctx, e)
+
+	case cluster.KindBroadcast:
+		if e.Node == gc.node {
+			return
+		}
+		gc.relay(e)
+	}
+}
+
+// Owns reports whether the lobby is played on this node, commands for other
+// lobbies are forwarded to their owner.
+func (gc *Cache) Owns(lobby *Lobby) bool {
+	return lobby.Node == gc.node
+}
+
+// forward sends a player's message to the node owning the lobby, along with
+// the id of the request it came with.
+func (gc *Cache) forward(ctx context.Context, lobby *Lobby, playerId uuid.UUID, msgType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return gc.bus.Publish(
+		ctx, cluster.Event{
+			Kind:      cluster.KindCommand,
+			LobbyId:   lobby.Id,
+			PlayerId:  playerId,
+			MsgType:   msgType,
+			Payload:   data,
+			RequestId: message.RequestId(ctx),
+		},
+	)
+}
+
+// execute runs a command forwarded by another node for a lobby owned by this
+// one.
+func (gc *Cache) execute(ctx context.Context, e cluster.Event) {
+	lobby, exists := gc.lobbies.load(e.LobbyId)
+	if !exists || lobby.Node != gc.node {
+		return
+	}
+	ctx = message.WithRequestId(ctx, e.RequestId)
+
+	switch e.MsgType {
+	case message.TypePlayMove:
+		var moveMsg message.PlayMovePayload
+		if err := json.Unmarshal(e.Payload, &moveMsg); err != nil {
+			return
+		}
+		if err := gc.Move(ctx, lobby.Id, e.PlayerId, moveMsg.Column); err != nil {
+			gc.reject(ctx, e, moveMsg, err)
+			return
+		}
+		gc.ack(ctx, e)
+	case message.TypeBerserk:
+		if err := gc.Berserk(ctx, lobby.Id, e.PlayerId); err != nil {
+			gc.reject(ctx, e, message.BerserkPayload{}, err)
+			return
+		}
+		gc.ack(ctx, e)
+	case message.TypeChat:
+		var chatMsg message.ChatMessagePayload
+		if err := json.Unmarshal(e.Payload, &chatMsg); err != nil {
+			return
+		}
+		gc.Chat(ctx, lobby.Id, e.PlayerId, chatMsg)
+	case commandForfeit:
+		_ = gc.Forfeit(ctx, lobby.Id)
+	}
+}
+
+// reject reports the error a forwarded command failed with back to the
+// player who sent it.
+func (gc *Cache) reject(ctx context.Context, e cluster.Event, payload any, err error) {
+	problematicMsg, _ := message.NewV1(e.MsgType, payload)
+	problematicMsg.LobbyId, problematicMsg.Id = e.LobbyId.String(), e.RequestId
+	gc.publishBroadcast(
+		ctx, e.LobbyId, e.PlayerId, websockets.WriteRequest{
+			MsgType: message.TypeError,
+			Payload: message.ErrorPayload{
+				Code:           websocket.StatusUnsupportedData,
+				Err:            err.Error(),
+				ProblematicMsg: problematicMsg,
+			},
+		},
+	)
+}
+
+// ack acknowledges a forwarded command to the player who sent it, if it came
+// with a request id.
+func (gc *Cache) ack(ctx context.Context, e cluster.Event) {
+	if e.RequestId == "" {
+		return
+	}
+	gc.publishBroadcast(
+		ctx, e.LobbyId, e.PlayerId, websockets.WriteRequest{
+			MsgType:   message.TypeAck,
+			Payload:   message.AckPayload{},
+			RequestId: e.RequestId,
+		},
+	)
+}
+
+func (gc *Cache) publishBroadcast(ctx context.Context, lobbyId uuid.UUID, playerId uuid.UUID, wr websockets.WriteRequest) {
+	data, err := json.Marshal(wr.Payload)
+	if err != nil {
+		return
+	}
+	_ = gc.bus.Publish(
+		ctx, cluster.Event{
+			Kind:      cluster.KindBroadcast,
+			LobbyId:   lobbyId,
+			PlayerId:  playerId,
+			MsgType:   wr.MsgType,
+			Payload:   data,
+			RequestId: wr.RequestId,
+			Seq:       wr.Seq,
+		},
+	)
+}
+
+// relay hands a message broadcast by the owning node to the local replica of
+// the lobby, or straight to the player it is addressed to. Replicas run like
+// any other lobby, so the message is applied on the replica's goroutine.
+func (gc *Cache) relay(e cluster.Event) {
+	payload, err := message.DecodePayload(e.MsgType, e.Payload)
+	if err != nil {
+		return
+	}
+	wr := websockets.WriteRequest{
+		MsgType:   e.MsgType,
+		Payload:   payload,
+		LobbyId:   e.LobbyId,
+		RequestId: e.RequestId,
+		Seq:       e.Seq,
+	}
+
+	if e.PlayerId != uuid.Nil {
+		for _, c := range gc.clients(e.PlayerId) {
+			c.Send(wr)
+		}
+		return
+	}
+	lobby, exists := gc.lobbies.load(e.LobbyId)
+	switch {
+	case !exists:
+	case e.MsgType == message.TypeLatency:
+		// latencies come from whichever node the player is connected to
+		_ = lobby.post(gc.ctx, command{kind: cmdTell, wr: wr})
+	case lobby.Node != gc.node:
+		_ = lobby.post(gc.ctx, command{kind: cmdRelay, wr: wr})
+	}
+}
+
+// replicate loads the unfinished games of a player owned by other nodes so
+// that the player can follow them from this node. If lobbyId is set, only
+// that lobby is loaded.
+func (gc *Cache) replicate(ctx context.Context, playerId uuid.UUID, lobbyId uuid.UUID) ([]*Lobby, error) {
+	rows, err := gc.db.GetUnfinishedGamesByPlayer(ctx, playerId)
+	if err != nil {
+		return nil, err
+	}
+
+	var lobbies []*Lobby
+	for _, row := range rows {
+		if row.Node == gc.node || (lobbyId != uuid.Nil && row.LobbyID != lobbyId) {
+			continue
+		}
+		if lobby, exists := gc.lobbies.load(row.LobbyID); exists {
+			lobbies = append(lobbies, lobby)
+			continue
+		}
+		lobby, over, err := gc.restoreLobby(ctx, sqlc.GetUnfinishedGamesRow(row))
+		if err != nil {
+			return lobbies, err
+		}
+		if !over {
+			lobbies = append(lobbies, gc.resume(lobby))
+		}
+	}
+
+	return lobbies, nil
+}
+
+// apply replays a move made on the owning node on a replica.
+func (l *Lobby) apply(played message.PlayedMovePayload) {
+	move := game.Move{Column: played.Column, Color: played.Color}
+	if l.Multi != nil {
+		_, _ = l.Multi.Make(move)
+		return
+	}
+	_, _, _ = l.Game.Make(move)
+}
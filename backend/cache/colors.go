@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"math/rand/v2"
+)
+
+type ColorPolicy string
+
+const (
+	// ColorPolicyRandom gives red to a random player.
+	ColorPolicyRandom ColorPolicy = "random"
+	// ColorPolicyAlternate gives red to the player who played red less often
+	// in their recent games.
+	ColorPolicyAlternate ColorPolicy = "alternate"
+	// ColorPolicyRating gives red, and with it the first move, to the lower
+	// rated player.
+	ColorPolicyRating ColorPolicy = "rating"
+)
+
+func ParseColorPolicy(s string) (ColorPolicy, error) {
+	switch p := ColorPolicy(s); p {
+	case ColorPolicyRandom, ColorPolicyAlternate, ColorPolicyRating:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown color policy '%s'", s)
+}
+
+// assignColors decides who plays red once a lobby is full. A color chosen by
+// the owner of a private lobby takes precedence over the configured policy.
+func (gc *Cache) assignColors(ctx context.Context, lobby *Lobby) {
+	players := make([]uuid.UUID, 0, len(lobby.players))
+	for pId := range lobby.players {
+		players = append(players, pId)
+	}
+	if len(players) != 2 {
+		// policies only balance two-player games; seat everyone else randomly
+		colors := lobby.Variant.Colors()
+		rand.Shuffle(len(players), func(i, j int) { players[i], players[j] = players[j], players[i] })
+		for i, pId := range players {
+			lobby.players[pId] = PlayerInfo{Color: colors[i]}
+		}
+		return
+	}
+
+	var red uuid.UUID
+	if _, ownerPlays := lobby.players[lobby.Owner]; ownerPlays && lobby.OwnerColor != game.ColorNone {
+		red = lobby.Owner
+		if lobby.OwnerColor != game.ColorRed {
+			red = otherPlayer(players, lobby.Owner)
+		}
+	} else {
+		red = gc.pickRed(ctx, players[0], players[1])
+	}
+
+	for _, pId := range players {
+		color := game.ColorYellow
+		if pId == red {
+			color = game.ColorRed
+		}
+		lobby.players[pId] = PlayerInfo{Color: color}
+	}
+}
+
+func (gc *Cache) pickRed(ctx context.Context, a uuid.UUID, b uuid.UUID) uuid.UUID {
+	switch ColorPolicy(gc.cfg.ColorPolicy) {
+	case ColorPolicyAlternate:
+		aBalance, errA := gc.redBalance(ctx, a)
+		bBalance, errB := gc.redBalance(ctx, b)
+		if errA == nil && errB == nil && aBalance != bBalance {
+			if aBalance < bBalance {
+				return a
+			}
+			return b
+		}
+	case ColorPolicyRating:
+		aUser, errA := gc.db.GetUserById(ctx, a)
+		bUser, errB := gc.db.GetUserById(ctx, b)
+		if errA == nil && errB == nil && aUser.Rating != bUser.Rating {
+			if aUser.Rating < bUser.Rating {
+				return a
+			}
+			return b
+		}
+	}
+
+	if rand.IntN(2) == 0 {
+		return a
+	}
+	return b
+}
+
+// redBalance returns how many more of the player's recent games were played
+// as red than as yellow.
+func (gc *Cache) redBalance(ctx context.Context, playerId uuid.UUID) (int32, error) {
+	history, err := gc.db.GetRecentColorHistory(
+		ctx, sqlc.GetRecentColorHistoryParams{
+			PlayerID: playerId,
+			Limit:    gc.cfg.ColorHistory,
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return 2*history.RedGames - history.Games, nil
+}
+
+func otherPlayer(players []uuid.UUID, playerId uuid.UUID) uuid.UUID {
+	for _, pId := range players {
+		if pId != playerId {
+			return pId
+		}
+	}
+	return uuid.Nil
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"backend/cluster"
+	"backend/game"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+)
+
+var ErrNoForfeit = errors.New("only running two-player games can be forfeited")
+
+// commandForfeit is the type of the forfeits forwarded to the node owning a
+// lobby. They come from the server rather than from a player.
+const commandForfeit = "forfeit"
+
+// Forfeit ends a running game against the player to move, who is the one
+// holding it up. It is used for games that outlast their deadline, such as
+// the round of a tournament.
+func (gc *Cache) Forfeit(ctx context.Context, lobbyId uuid.UUID) error {
+	lobby, exists := gc.lobbies.load(lobbyId)
+	if !exists && gc.bus == nil {
+		return ErrLobbyNotFound
+	}
+	if !exists || lobby.Node != gc.node {
+		// the owning node may not be replicated here, the command is
+		// addressed by id
+		return gc.bus.Publish(ctx, cluster.Event{Kind: cluster.KindCommand, LobbyId: lobbyId, MsgType: commandForfeit})
+	}
+
+	_, err := lobby.do(ctx, command{kind: cmdForfeit})
+	return err
+}
+
+// forfeitWinner returns the winner of a lobby's game forfeited by the player to
+// move. It runs on the lobby's goroutine.
+func forfeitWinner(lobby *Lobby) (game.Color, error) {
+	if !lobby.started || lobby.Game == nil {
+		return game.ColorNone, ErrNoForfeit
+	}
+	if lobby.Game.ToMove() == game.ColorRed {
+		return game.ColorYellow, nil
+	}
+	return game.ColorRed, nil
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"backend/game"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestForfeit(t *testing.T) {
+	gc := newTestCache(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	red, yellow := uuid.New(), uuid.New()
+	lobbyId := uuid.New()
+	lobby, err := gc.StartChallenge(ctx, LobbyOptions{Id: lobbyId, Owner: red, OwnerColor: game.ColorRed}, red, yellow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lobby.Id != lobbyId {
+		t.Fatalf("lobby %v started, want %v", lobby.Id, lobbyId)
+	}
+	if _, err = gc.StartChallenge(ctx, LobbyOptions{Id: lobbyId}, red, yellow); !errors.Is(err, ErrLobbyExists) {
+		t.Fatalf("starting the lobby again: %v, want %v", err, ErrLobbyExists)
+	}
+
+	if err = gc.Move(ctx, lobbyId, red, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err = gc.Forfeit(ctx, lobbyId); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lobby.done:
+	case <-ctx.Done():
+		t.Fatal("the forfeited lobby is still running")
+	}
+	// yellow was to move after red's first move
+	if winner, _ := forfeitWinner(lobby); winner != game.ColorRed {
+		t.Fatalf("forfeit won by %v, want red", winner)
+	}
+	if err = gc.Forfeit(ctx, lobbyId); !errors.Is(err, ErrLobbyNotFound) {
+		t.Fatalf("forfeiting a finished game: %v, want %v", err, ErrLobbyNotFound)
+	}
+}
+
+func TestForfeitNotStarted(t *testing.T) {
+	gc := newTestCache(t)
+	lobby, err := gc.CreateLobby(LobbyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gc.Forfeit(context.Background(), lobby.Id); !errors.Is(err, ErrNoForfeit) {
+		t.Fatalf("forfeiting a lobby that did not start: %v, want %v", err, ErrNoForfeit)
+	}
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Latency shares the round trip time measured on a session of a player with
+// everyone following the player's running games, so that opponents can tell
+// a slow connection from a slow player.
+func (gc *Cache) Latency(ctx context.Context, c *Client, rtt time.Duration) {
+	lobbies, _ := gc.seats.load(c.Id)
+	for _, lobby := range lobbies {
+		_ = lobby.post(ctx, command{kind: cmdLatency, playerId: c.Id, rtt: rtt})
+	}
+}
+
+// latency tells the lobby's clients about a player's latency on the lobby's
+// goroutine, and the other nodes following the lobby, whether they own it or
+// not. Latencies are not part of the game, so they are neither numbered nor
+// kept for clients resuming.
+func (gc *Cache) latency(ctx context.Context, lobby *Lobby, playerId uuid.UUID, rtt time.Duration) {
+	info, isPlayer := lobby.players[playerId]
+	if !isPlayer || !lobby.started || lobby.over {
+		return
+	}
+	wr := websockets.WriteRequest{
+		MsgType: message.TypeLatency,
+		Payload: message.LatencyPayload{Color: info.Color, Rtt: int(rtt.Milliseconds())},
+		LobbyId: lobby.Id,
+	}
+	lobby.tell(wr)
+	if gc.bus != nil {
+		gc.publishBroadcast(ctx, lobby.Id, uuid.Nil, wr)
+	}
+}
+
+// tell sends a message to the lobby's clients outside of its broadcasts.
+func (l *Lobby) tell(wr websockets.WriteRequest) {
+	for session, c := range l.clients {
+		if c.gone() {
+			delete(l.clients, session)
+			continue
+		}
+		c.Send(wr)
+	}
+}
@@ -0,0 +1,383 @@
+package cache
+
+import (
+	"backend/game"
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+type commandKind int
+
+const (
+	cmdJoin commandKind = iota
+	cmdLeave
+	cmdMove
+	cmdChat
+	cmdBerserk
+	cmdLatency
+	cmdRelay
+	cmdTell
+	cmdSnapshot
+	cmdDisband
+	cmdForfeit
+)
+
+// command is a request handled by a lobby's goroutine.
+type command struct {
+	kind     commandKind
+	playerId uuid.UUID
+	// client is registered by the lobby and notified of its seat once the
+	// player's game starts, it is nil for players connected to another node
+	// and for queued players whose client is registered already
+	client *Client
+	// present reports whether a player joining the lobby is still around
+	present func() bool
+	column  uint8
+	chat    message.ChatMessagePayload
+	rtt     time.Duration
+	wr      websockets.WriteRequest
+	reply   chan result
+}
+
+type result struct {
+	err      error
+	started  bool
+	snapshot Snapshot
+}
+
+func (cmd command) respond(r result) {
+	if cmd.reply != nil {
+		cmd.reply <- r
+	}
+}
+
+// do hands a command to the lobby's goroutine and waits for its result.
+func (l *Lobby) do(ctx context.Context, cmd command) (result, error) {
+	cmd.reply = make(chan result, 1)
+	if err := l.post(ctx, cmd); err != nil {
+		return result{}, err
+	}
+	select {
+	case r := <-cmd.reply:
+		return r, r.err
+	case <-ctx.Done():
+		return result{}, ctx.Err()
+	}
+}
+
+// post hands a command to the lobby's goroutine without waiting for it to be
+// handled.
+func (l *Lobby) post(ctx context.Context, cmd command) error {
+	select {
+	case l.commands <- cmd:
+		return nil
+	case <-l.done:
+		return ErrLobbyNotFound
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// join seats a player in the lobby, or hands the seat they already have in a
+// running game to their new client. It reports whether the player filled the
+// lobby and started its game.
+func (l *Lobby) join(ctx context.Context, playerId uuid.UUID, c *Client, present func() bool) (bool, error) {
+	r, err := l.do(ctx, command{kind: cmdJoin, playerId: playerId, client: c, present: present})
+	return r.started, err
+}
+
+func (l *Lobby) leave(playerId uuid.UUID) {
+	_ = l.post(context.Background(), command{kind: cmdLeave, playerId: playerId})
+}
+
+// disband closes a lobby whose game has not started, giving up the seats
+// taken in it.
+func (l *Lobby) disband() {
+	_ = l.post(context.Background(), command{kind: cmdDisband})
+}
+
+// Snapshot captures the current board of the lobby's game.
+func (l *Lobby) Snapshot(ctx context.Context) (Snapshot, error) {
+	r, err := l.do(ctx, command{kind: cmdSnapshot})
+	return r.snapshot, err
+}
+
+// runLobby handles the commands of a lobby until its game is over.
+func (gc *Cache) runLobby(ctx context.Context, lobby *Lobby) {
+	defer close(lobby.done)
+	// a restored bot game may be waiting on the bot
+	gc.botTurn(ctx, lobby)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-lobby.commands:
+			gc.handle(ctx, lobby, cmd)
+			if lobby.over {
+				gc.lobbies.deleteFunc(lobby.Id, func(l *Lobby) bool { return l == lobby })
+				for pId := range lobby.players {
+					gc.unseat(lobby, pId)
+				}
+				return
+			}
+		}
+	}
+}
+
+func (gc *Cache) handle(ctx context.Context, lobby *Lobby, cmd command) {
+	switch cmd.kind {
+	case cmdJoin:
+		started, err := gc.join(ctx, lobby, cmd)
+		cmd.respond(result{started: started, err: err})
+
+	case cmdLeave:
+		if !lobby.started {
+			delete(lobby.players, cmd.playerId)
+			gc.unseat(lobby, cmd.playerId)
+		}
+		cmd.respond(result{})
+
+	case cmdMove:
+		// respond before broadcasting, the player's own connection has to
+		// keep reading to take the broadcast
+		p, err := gc.move(ctx, lobby, cmd.playerId, cmd.column)
+		cmd.respond(result{err: err})
+		if err == nil {
+			gc.announce(ctx, lobby, p)
+			gc.botTurn(ctx, lobby)
+		}
+
+	case cmdChat:
+		cmd.respond(result{})
+		if _, isPlayer := lobby.players[cmd.playerId]; isPlayer {
+			gc.chat(ctx, lobby, cmd.playerId, cmd.chat)
+		}
+
+	case cmdBerserk:
+		color, changed, err := gc.berserk(ctx, lobby, cmd.playerId)
+		cmd.respond(result{err: err})
+		if changed {
+			gc.announceBerserk(ctx, lobby, color)
+		}
+
+	case cmdLatency:
+		cmd.respond(result{})
+		gc.latency(ctx, lobby, cmd.playerId, cmd.rtt)
+
+	case cmdRelay:
+		cmd.respond(result{})
+		gc.broadcast(ctx, lobby, cmd.wr)
+
+	case cmdTell:
+		cmd.respond(result{})
+		lobby.tell(cmd.wr)
+
+	case cmdSnapshot:
+		cmd.respond(result{snapshot: lobby.snapshot()})
+
+	case cmdDisband:
+		cmd.respond(result{})
+		if !lobby.started {
+			lobby.over = true
+		}
+
+	case cmdForfeit:
+		winner, err := forfeitWinner(lobby)
+		cmd.respond(result{err: err})
+		if err == nil {
+			gc.broadcast(
+				ctx, lobby, websockets.WriteRequest{
+					MsgType: message.TypeGameOver,
+					Payload: message.GameOverPayload{Winner: winner, Ranking: []game.Color{winner}},
+				},
+			)
+		}
+	}
+}
+
+func (gc *Cache) join(ctx context.Context, lobby *Lobby, cmd command) (bool, error) {
+	if lobby.started {
+		info, isPlayer := lobby.players[cmd.playerId]
+		if !isPlayer {
+			return false, ErrLobbyFull
+		}
+		if cmd.client != nil {
+			gc.register(lobby, cmd.client)
+			seat := lobby.seat(info.Color)
+			if seq, resuming := cmd.client.resume[lobby.Id]; resuming {
+				seat.Replay, seat.Resumed = lobby.missed(seq)
+			}
+			cmd.client.notify(seat)
+		}
+		return false, nil
+	}
+
+	if cmd.client != nil {
+		gc.register(lobby, cmd.client)
+	}
+	if _, isPlayer := lobby.players[cmd.playerId]; !isPlayer {
+		lobby.players[cmd.playerId] = PlayerInfo{}
+		if cmd.playerId != BotId {
+			gc.seat(lobby, cmd.playerId)
+		}
+		// the seat is taken before checking, so that a player leaving in
+		// the meantime either fails the check or finds the seat to give up
+		if cmd.present != nil && !cmd.present() {
+			delete(lobby.players, cmd.playerId)
+			gc.unseat(lobby, cmd.playerId)
+			return false, nil
+		}
+	}
+	if len(lobby.players) < lobby.Capacity() {
+		return false, nil
+	}
+	gc.startLobby(ctx, lobby)
+	gc.botTurn(ctx, lobby)
+	return true, nil
+}
+
+// register makes a joining client reachable for the lobby's broadcasts. It
+// runs on the lobby's goroutine, so the client gets every broadcast made after
+// the snapshot in its seat, and the lobby never waits on a client whose writer
+// is not running yet.
+func (gc *Cache) register(lobby *Lobby, c *Client) {
+	gc.connect(c)
+	lobby.clients[c.Session] = c
+}
+
+// attached reports whether a session of the player follows the lobby.
+func (l *Lobby) attached(playerId uuid.UUID) bool {
+	for _, c := range l.clients {
+		if c.Id == playerId && !c.gone() {
+			return true
+		}
+	}
+	return false
+}
+
+// seat records that a player plays in the lobby, next to their other games.
+func (gc *Cache) seat(lobby *Lobby, playerId uuid.UUID) {
+	gc.seats.update(
+		playerId, func(lobbies []*Lobby, _ bool) ([]*Lobby, bool) {
+			if slices.Contains(lobbies, lobby) {
+				return lobbies, true
+			}
+			return append(slices.Clone(lobbies), lobby), true
+		},
+	)
+}
+
+func (gc *Cache) unseat(lobby *Lobby, playerId uuid.UUID) {
+	gc.seats.update(
+		playerId, func(lobbies []*Lobby, _ bool) ([]*Lobby, bool) {
+			lobbies = slices.DeleteFunc(slices.Clone(lobbies), func(l *Lobby) bool { return l == lobby })
+			return lobbies, len(lobbies) > 0
+		},
+	)
+}
+
+// Capacity returns the number of players needed to start the lobby's game.
+func (l *Lobby) Capacity() int {
+	return l.Variant.Players
+}
+
+type Snapshot struct {
+	State      [][]game.Color
+	LastPlayed game.Color
+	ToMove     game.Color
+}
+
+func (l *Lobby) snapshot() Snapshot {
+	if l.Multi != nil {
+		state, lastPlayed := l.Multi.Snapshot()
+		return Snapshot{State: state, LastPlayed: lastPlayed, ToMove: l.Multi.ToMove()}
+	}
+
+	lastPlayed := game.ColorNone
+	if len(l.Game.Moves) > 0 {
+		lastPlayed = l.Game.Moves[len(l.Game.Moves)-1].Color
+	}
+	return Snapshot{State: l.Game.State.Grid(), LastPlayed: lastPlayed, ToMove: l.Game.ToMove()}
+}
+
+func (l *Lobby) seat(color game.Color) Seat {
+	return Seat{
+		Lobby:    l,
+		Color:    color,
+		Snapshot: l.snapshot(),
+		Messages: slices.Clone(l.messages),
+		Bot:      l.botLevel > 0,
+		Seq:      l.seq,
+	}
+}
+
+// replayBuffer is the number of messages a lobby keeps for clients resuming
+// after a reconnect, clients who missed more get a snapshot.
+const replayBuffer = 64
+
+// remember keeps a broadcast message for clients resuming later. Messages
+// that do not follow the last one without a gap, which replicas may relay
+// after missing some, start the history over.
+func (l *Lobby) remember(wr websockets.WriteRequest) {
+	if wr.Seq == 0 {
+		return
+	}
+	if n := len(l.history); n > 0 && l.history[n-1].Seq+1 != wr.Seq {
+		l.history = l.history[:0]
+	}
+	if len(l.history) == replayBuffer {
+		l.history = slices.Delete(l.history, 0, 1)
+	}
+	l.history = append(l.history, wr)
+}
+
+// missed returns the messages broadcast after seq, and false if the lobby no
+// longer has all of them.
+func (l *Lobby) missed(seq uint64) ([]websockets.WriteRequest, bool) {
+	switch {
+	case seq == 0, seq > l.seq:
+		return nil, false
+	case seq == l.seq:
+		return nil, true
+	case len(l.history) == 0, l.history[0].Seq > seq+1:
+		return nil, false
+	}
+	return slices.Clone(l.history[seq+1-l.history[0].Seq:]), true
+}
+
+// winner returns who won the lobby's finished game, ColorNone for a draw.
+func (l *Lobby) winner() game.Color {
+	if l.Multi != nil {
+		if len(l.Multi.Finished) > 0 {
+			return l.Multi.Finished[0]
+		}
+		return game.ColorNone
+	}
+	return l.Game.Winner()
+}
+
+func (l *Lobby) gameId() uuid.UUID {
+	if l.Multi != nil {
+		return l.Multi.Id
+	}
+	return l.Game.Id
+}
+
+func (l *Lobby) strState() string {
+	if l.Multi != nil {
+		state, _ := l.Multi.Snapshot()
+		return game.StrGrid(state)
+	}
+	return l.Game.State.StrState()
+}
+
+func (l *Lobby) strMoves() string {
+	if l.Multi != nil {
+		return l.Multi.StrMoves()
+	}
+	return l.Game.StrMoves()
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"backend/message"
+	"backend/websockets"
+	"fmt"
+	"sync"
+)
+
+// SlowConsumer is the policy applied when a client's outbox is full.
+type SlowConsumer string
+
+const (
+	// SlowConsumerDrop drops the client's oldest droppable message, and
+	// disconnects it if it has none.
+	SlowConsumerDrop SlowConsumer = "drop"
+	// SlowConsumerDisconnect disconnects the client right away.
+	SlowConsumerDisconnect SlowConsumer = "disconnect"
+)
+
+func ParseSlowConsumer(s string) (SlowConsumer, error) {
+	switch p := SlowConsumer(s); p {
+	case SlowConsumerDrop, SlowConsumerDisconnect:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy '%s'", s)
+}
+
+// outbox queues the messages and seats of a client until its writer takes
+// them, so that lobbies never wait on a slow socket.
+type outbox struct {
+	mu     sync.Mutex
+	items  []outboxItem
+	size   int
+	policy SlowConsumer
+	// full is set once the client overflowed, nothing is queued afterwards
+	full  bool
+	ready chan struct{}
+}
+
+// outboxItem is a message, or a seat whose messages are written once the
+// client's handler knows about the game.
+type outboxItem struct {
+	wr   websockets.WriteRequest
+	seat *Seat
+}
+
+func newOutbox(size int, policy SlowConsumer) *outbox {
+	return &outbox{
+		size:   max(size, 1),
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues an item, making room under the drop policy. It reports false if
+// the client cannot keep up and must be disconnected.
+func (o *outbox) push(item outboxItem) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.full {
+		return false
+	}
+	if len(o.items) >= o.size {
+		if o.policy != SlowConsumerDrop || !o.dropOldest() {
+			o.full = true
+			o.items = nil
+			return false
+		}
+	}
+	o.items = append(o.items, item)
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (o *outbox) dropOldest() bool {
+	for i, item := range o.items {
+		if item.seat == nil && droppable(item.wr) {
+			o.items = append(o.items[:i], o.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pop takes the oldest item, waiting for one until done is closed.
+func (o *outbox) pop(done <-chan struct{}) (outboxItem, bool) {
+	for {
+		o.mu.Lock()
+		if len(o.items) > 0 {
+			item := o.items[0]
+			o.items = o.items[1:]
+			o.mu.Unlock()
+			return item, true
+		}
+		o.mu.Unlock()
+		select {
+		case <-o.ready:
+		case <-done:
+			return outboxItem{}, false
+		}
+	}
+}
+
+// droppable reports whether a message may be dropped for a slow client,
+// which it may if a later message of the same kind replaces it.
+func droppable(wr websockets.WriteRequest) bool {
+	switch wr.MsgType {
+	case message.TypeLatency, message.TypeQueueStatus, message.TypeArenaLeaderboard:
+		return true
+	}
+	return false
+}
+
+// pump hands the client's queued items to its writer in order until the
+// client leaves. A seat reaches the handler before the messages of its game,
+// and a game is released once its gameOver is written, so the handler never
+// closes the connection before it.
+func (c *Client) pump() {
+	for {
+		item, ok := c.outbox.pop(c.done)
+		if !ok {
+			return
+		}
+
+		if item.seat != nil {
+			select {
+			case c.Notify <- *item.seat:
+			case <-c.done:
+				return
+			}
+			for _, wr := range item.seat.writeRequests() {
+				if !c.write(wr) {
+					return
+				}
+			}
+			continue
+		}
+
+		if item.wr.MsgType != message.TypeGameOver {
+			if !c.write(item.wr) {
+				return
+			}
+			continue
+		}
+		written := make(chan struct{})
+		item.wr.Written = written
+		if !c.write(item.wr) {
+			return
+		}
+		select {
+		case <-written:
+		case <-c.done:
+			return
+		}
+		select {
+		case c.Released <- item.wr.LobbyId:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) write(wr websockets.WriteRequest) bool {
+	select {
+	case c.WriteRequests <- wr:
+		return true
+	case <-c.done:
+		return false
+	}
+}
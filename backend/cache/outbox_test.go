@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"backend/config"
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"errors"
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func item(msgType string) outboxItem {
+	return outboxItem{wr: websockets.WriteRequest{MsgType: msgType}}
+}
+
+func seatItem() outboxItem {
+	return outboxItem{seat: &Seat{}}
+}
+
+func queued(o *outbox) []string {
+	types := make([]string, len(o.items))
+	for i, item := range o.items {
+		types[i] = item.wr.MsgType
+		if item.seat != nil {
+			types[i] = "seat"
+		}
+	}
+	return types
+}
+
+func pending(o *outbox) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.items)
+}
+
+func TestOutboxPush(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		policy SlowConsumer
+		push   []outboxItem
+		// accepted is the number of pushes that succeed
+		accepted int
+		want     []string
+	}{
+		{
+			name: "room left", size: 3, policy: SlowConsumerDisconnect,
+			push:     []outboxItem{item(message.TypeChat), item(message.TypePlayedMove)},
+			accepted: 2, want: []string{message.TypeChat, message.TypePlayedMove},
+		},
+		{
+			name: "disconnect when full", size: 2, policy: SlowConsumerDisconnect,
+			push:     []outboxItem{item(message.TypeLatency), item(message.TypeLatency), item(message.TypeLatency)},
+			accepted: 2,
+		},
+		{
+			name: "drop the oldest droppable", size: 3, policy: SlowConsumerDrop,
+			push: []outboxItem{
+				item(message.TypeChat), item(message.TypeLatency), item(message.TypeQueueStatus),
+				item(message.TypePlayedMove), item(message.TypeGameOver),
+			},
+			accepted: 5, want: []string{message.TypeChat, message.TypePlayedMove, message.TypeGameOver},
+		},
+		{
+			name: "leaderboards are droppable", size: 1, policy: SlowConsumerDrop,
+			push:     []outboxItem{item(message.TypeArenaLeaderboard), item(message.TypeArenaLeaderboard)},
+			accepted: 2, want: []string{message.TypeArenaLeaderboard},
+		},
+		{
+			name: "disconnect when nothing is droppable", size: 2, policy: SlowConsumerDrop,
+			push: []outboxItem{
+				item(message.TypeChat), item(message.TypePlayedMove), item(message.TypeLatency),
+				item(message.TypeChat),
+			},
+			accepted: 2,
+		},
+		{
+			name: "seats are never dropped", size: 2, policy: SlowConsumerDrop,
+			push:     []outboxItem{seatItem(), item(message.TypeLatency), item(message.TypeLatency), seatItem()},
+			accepted: 4, want: []string{"seat", "seat"},
+		},
+		{
+			name: "no size holds one message", size: 0, policy: SlowConsumerDisconnect,
+			push:     []outboxItem{item(message.TypeChat), item(message.TypeChat)},
+			accepted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOutbox(tt.size, tt.policy)
+			accepted := 0
+			for _, item := range tt.push {
+				if !o.push(item) {
+					break
+				}
+				accepted++
+			}
+			if accepted != tt.accepted {
+				t.Errorf("%d pushes accepted, want %d", accepted, tt.accepted)
+			}
+			if got := queued(o); !slices.Equal(got, tt.want) {
+				t.Errorf("queued %v, want %v", got, tt.want)
+			}
+			if accepted < len(tt.push) && (!o.full || o.push(item(message.TypeChat))) {
+				t.Error("outbox accepts messages after it overflowed")
+			}
+		})
+	}
+}
+
+func TestOutboxPop(t *testing.T) {
+	o := newOutbox(4, SlowConsumerDrop)
+	done := make(chan struct{})
+	popped := make(chan string)
+	go func() {
+		for {
+			item, ok := o.pop(done)
+			if !ok {
+				close(popped)
+				return
+			}
+			popped <- item.wr.MsgType
+		}
+	}()
+
+	// pop waits for the items and takes them in order
+	types := []string{message.TypeChat, message.TypePlayedMove, message.TypeGameOver}
+	for _, msgType := range types {
+		time.Sleep(time.Millisecond)
+		o.push(item(msgType))
+	}
+	for _, want := range types {
+		select {
+		case got := <-popped:
+			if got != want {
+				t.Errorf("popped %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not popped", want)
+		}
+	}
+
+	close(done)
+	select {
+	case _, ok := <-popped:
+		if ok {
+			t.Error("popped an item after done")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pop still waiting after done")
+	}
+}
+
+func TestParseSlowConsumer(t *testing.T) {
+	for _, s := range []string{"drop", "disconnect"} {
+		if p, err := ParseSlowConsumer(s); err != nil || string(p) != s {
+			t.Errorf("ParseSlowConsumer(%q) = %q, %v", s, p, err)
+		}
+	}
+	for _, s := range []string{"", "block", "Drop"} {
+		if _, err := ParseSlowConsumer(s); err == nil {
+			t.Errorf("ParseSlowConsumer(%q) succeeded", s)
+		}
+	}
+}
+
+// TestClientDropsForSlowWriter fills the outbox of a client whose writer is
+// stuck, and checks what reaches the writer once it moves again.
+func TestClientDropsForSlowWriter(t *testing.T) {
+	c := NewClient(uuid.New(), nil, config.WebsocketsConfig{SendQueue: 2, SlowConsumer: string(SlowConsumerDrop)})
+	defer c.stop()
+
+	// the first message is taken by the pump, which waits for the writer
+	c.Send(websockets.WriteRequest{MsgType: message.TypeChat})
+	for pending(c.outbox) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, msgType := range []string{
+		message.TypeQueueStatus, message.TypeQueueStatus, message.TypePlayedMove, message.TypeLatency,
+	} {
+		c.Send(websockets.WriteRequest{MsgType: msgType})
+	}
+
+	want := []string{message.TypeChat, message.TypePlayedMove, message.TypeLatency}
+	for _, msgType := range want {
+		select {
+		case wr := <-c.WriteRequests:
+			if wr.MsgType != msgType {
+				t.Errorf("wrote %s, want %s", wr.MsgType, msgType)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not written", msgType)
+		}
+	}
+	select {
+	case wr := <-c.WriteRequests:
+		t.Errorf("wrote %s after the last message", wr.MsgType)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// TestClientDisconnectsSlowConsumer checks that a client whose outbox
+// overflows has its socket closed.
+func TestClientDisconnectsSlowConsumer(t *testing.T) {
+	for _, policy := range []SlowConsumer{SlowConsumerDrop, SlowConsumerDisconnect} {
+		t.Run(string(policy), func(t *testing.T) {
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						ws, err := websocket.Accept(w, r, nil)
+						if err != nil {
+							return
+						}
+						c := NewClient(uuid.New(), ws, config.WebsocketsConfig{SendQueue: 2, SlowConsumer: string(policy)})
+						defer c.stop()
+						// nobody writes, chat is never dropped
+						for range 4 {
+							c.Send(websockets.WriteRequest{MsgType: message.TypeChat})
+						}
+						ws.CloseRead(r.Context())
+						<-r.Context().Done()
+					},
+				),
+			)
+			defer server.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			ws, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.CloseNow()
+			_, _, err = ws.Read(ctx)
+			var closeErr websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.StatusTryAgainLater || closeErr.Reason != "slow consumer" {
+				t.Errorf("read error %v, want a close for a slow consumer", err)
+			}
+		})
+	}
+}
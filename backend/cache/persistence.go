@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/message"
+	"backend/results"
+	"backend/websockets"
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// save records a lobby that just filled up along with its unfinished game so
+// that it survives a restart.
+func (gc *Cache) save(ctx context.Context, lobby *Lobby) {
+	startedAtUtc := time.Now().UTC()
+	err := gc.db.CreateLobby(
+		ctx, sqlc.CreateLobbyParams{
+			ID:           lobby.Id,
+			CreatedAtUtc: lobby.CreatedAtUtc,
+			IsPrivate:    lobby.Private,
+			Variant:      lobby.Variant.Name,
+		},
+	)
+	if err != nil {
+		gc.logger.Errorf("saving lobby %v: %v", lobby.Id, err)
+	}
+	players := make([]sqlc.CreateLobbyPlayersParams, 0, len(lobby.players))
+	for pId, info := range lobby.players {
+		players = append(
+			players, sqlc.CreateLobbyPlayersParams{
+				LobbyID:  lobby.Id,
+				PlayerID: pId,
+				Color:    int16(info.Color),
+			},
+		)
+	}
+	if _, err = gc.db.CreateLobbyPlayers(ctx, players); err != nil {
+		gc.logger.Errorf("saving the players of lobby %v: %v", lobby.Id, err)
+	}
+
+	params := sqlc.CreateGameParams{
+		ID:           lobby.gameId(),
+		LobbyID:      lobby.Id,
+		StartedAtUtc: &startedAtUtc,
+		State:        lobby.strState(),
+		Moves:        lobby.strMoves(),
+		StartState:   game.StrGrid(lobby.Variant.NewGrid()),
+		StartToMove:  int16(game.ColorRed),
+		Variant:      lobby.Variant.Name,
+		Node:         lobby.Node,
+		BotLevel:     lobby.botLevel,
+	}
+	if lobby.Arena != uuid.Nil {
+		params.TournamentID = &lobby.Arena
+	}
+	if lobby.Game != nil {
+		params.StartState = lobby.Game.Start.StrState()
+		params.StartToMove = int16(lobby.Game.FirstToMove)
+	}
+	if err = gc.db.CreateGame(ctx, params); err != nil {
+		gc.logger.Errorf("saving the game of lobby %v: %v", lobby.Id, err)
+	}
+}
+
+// checkpoint stores the current board and move list after every move.
+func (gc *Cache) checkpoint(ctx context.Context, lobby *Lobby) {
+	err := gc.db.UpdateGameProgress(
+		ctx, sqlc.UpdateGameProgressParams{
+			ID:    lobby.gameId(),
+			State: lobby.strState(),
+			Moves: lobby.strMoves(),
+		},
+	)
+	if err != nil {
+		gc.logger.Errorf("checkpointing the game of lobby %v: %v", lobby.Id, err)
+	}
+}
+
+// finish marks the game as ended, and stores its position hashes and the
+// players' new ratings.
+func (gc *Cache) finish(ctx context.Context, lobby *Lobby, winner game.Color) {
+	now := time.Now().UTC()
+	err := gc.db.FinishGame(
+		ctx, sqlc.FinishGameParams{
+			ID:         lobby.gameId(),
+			State:      lobby.strState(),
+			Moves:      lobby.strMoves(),
+			Winner:     int16(winner),
+			EndedAtUtc: &now,
+		},
+	)
+	if err != nil {
+		gc.logger.Errorf("finishing the game of lobby %v: %v", lobby.Id, err)
+	}
+	if lobby.Game == nil {
+		return
+	}
+
+	var red, yellow uuid.UUID
+	for pId, info := range lobby.players {
+		if info.Color == game.ColorRed {
+			red = pId
+		} else {
+			yellow = pId
+		}
+	}
+	// games against the bot are not rated
+	if err = results.Record(ctx, gc.db, lobby.Game, red, yellow, winner, lobby.botLevel == 0); err != nil {
+		gc.logger.Errorf("recording the result of lobby %v: %v", lobby.Id, err)
+	}
+}
+
+// Chat stores a chat message sent in a lobby and broadcasts it to the
+// lobby's players.
+func (gc *Cache) Chat(ctx context.Context, lobbyId uuid.UUID, playerId uuid.UUID, chatMsg message.ChatMessagePayload) {
+	lobby, exists := gc.lobbies.load(lobbyId)
+	if !exists {
+		return
+	}
+	if lobby.Node != gc.node {
+		_ = gc.forward(ctx, lobby, playerId, message.TypeChat, chatMsg)
+		return
+	}
+	_ = lobby.post(ctx, command{kind: cmdChat, playerId: playerId, chat: chatMsg})
+}
+
+func (gc *Cache) chat(ctx context.Context, lobby *Lobby, playerId uuid.UUID, chatMsg message.ChatMessagePayload) {
+	id, err := uuid.NewV7()
+	if err == nil {
+		err = gc.db.CreateMessage(
+			ctx, sqlc.CreateMessageParams{
+				ID:        id,
+				LobbyID:   lobby.Id,
+				SenderID:  playerId,
+				Content:   chatMsg.Text,
+				SentAtUtc: time.Now().UTC(),
+			},
+		)
+	}
+	if err != nil {
+		gc.logger.Errorf("saving a chat message of lobby %v: %v", lobby.Id, err)
+	}
+
+	gc.broadcast(ctx, lobby, websockets.WriteRequest{MsgType: message.TypeChat, Payload: chatMsg})
+}
+
+// Restore reloads every unfinished game from the database into the cache so
+// that players can reconnect to it after a restart. A game that cannot be
+// restored is logged and left out, so that it does not keep the others, or
+// the server, from starting.
+func (gc *Cache) Restore(ctx context.Context) error {
+	games, err := gc.db.GetUnfinishedGames(ctx, gc.node)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range games {
+		lobby, over, err := gc.restoreLobby(ctx, row)
+		if err != nil {
+			gc.logger.Errorf("skipping game %v of lobby %v, it cannot be restored: %v", row.ID, row.LobbyID, err)
+			continue
+		}
+		if over {
+			// the server went down between the last move and the game over
+			// broadcast
+			gc.finish(ctx, lobby, lobby.winner())
+			continue
+		}
+		gc.resume(lobby)
+	}
+
+	return nil
+}
+
+// resume runs a lobby loaded from the database and hands its seats to its
+// players. If the lobby is already running, that one is returned instead.
+func (gc *Cache) resume(lobby *Lobby) *Lobby {
+	if running, loaded := gc.lobbies.loadOrStore(lobby.Id, lobby); loaded {
+		return running
+	}
+	for pId := range lobby.players {
+		if pId != BotId {
+			gc.seat(lobby, pId)
+		}
+	}
+	go gc.runLobby(gc.ctx, lobby)
+	return lobby
+}
+
+func (gc *Cache) restoreLobby(ctx context.Context, row sqlc.GetUnfinishedGamesRow) (*Lobby, bool, error) {
+	variant, err := game.ParseVariant(row.Variant)
+	if err != nil {
+		return nil, false, err
+	}
+	options := LobbyOptions{
+		Variant:     variant,
+		Private:     row.IsPrivate,
+		StartToMove: game.Color(row.StartToMove),
+		BotLevel:    row.BotLevel,
+	}
+	if row.TournamentID != nil {
+		options.Arena = *row.TournamentID
+	}
+	if variant.Players == 2 {
+		start, err := game.ParseBoard(row.StartState)
+		if err != nil {
+			return nil, false, err
+		}
+		options.Start = *start
+	}
+	lobby, err := NewLobby(options)
+	if err != nil {
+		return nil, false, err
+	}
+	lobby.Id = row.LobbyID
+	lobby.Node = row.Node
+	lobby.CreatedAtUtc = row.LobbyCreatedAtUtc
+	lobby.started = true
+
+	columns, err := game.ParseMoves(row.Moves)
+	if err != nil {
+		return nil, false, err
+	}
+	over := false
+	if lobby.Multi != nil {
+		lobby.Multi.Id = row.ID
+		for _, col := range columns {
+			r, err := lobby.Multi.Make(game.Move{Column: col, Color: lobby.Multi.ToMove()})
+			if err != nil {
+				return nil, false, err
+			}
+			over = r.Over
+		}
+	} else {
+		lobby.Game.Id = row.ID
+		for _, col := range columns {
+			_, over, err = lobby.Game.Make(game.Move{Column: col, Color: lobby.Game.ToMove()})
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		over = over || lobby.Game.State.Full()
+	}
+
+	players, err := gc.db.GetLobbyPlayers(ctx, lobby.Id)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, p := range players {
+		lobby.players[p.PlayerID] = PlayerInfo{Color: game.Color(p.Color), Berserk: p.Berserk}
+	}
+
+	messages, err := gc.db.GetLobbyMessages(ctx, lobby.Id)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, m := range messages {
+		lobby.messages = append(lobby.messages, message.ChatMessagePayload{From: m.Username, Text: m.Content})
+	}
+
+	return lobby, over, nil
+}
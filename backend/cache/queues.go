@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"backend/cluster"
+	"backend/config"
+	"backend/game"
+	"backend/matchmaking"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"maps"
+	"time"
+)
+
+var ErrQueueNotFound = errors.New("queue not found")
+
+// Queue is a matchmaking queue for one variant. Every queue
+// has its own matchmaker, which only the matchmaking goroutine touches.
+type Queue struct {
+	Name        string
+	Variant     game.Variant
+	matchmaker  matchmaking.Matchmaker
+	averageWait time.Duration
+	// arena is the tournament whose games the queue pairs, if any
+	arena uuid.UUID
+}
+
+// QueueInfo describes a queue along with the number of players waiting in it.
+type QueueInfo struct {
+	Name    string
+	Variant string
+	Waiting int
+}
+
+// search asks the matchmaking goroutine to queue a player, to take them out
+// of the queues if cancel is set, or to seat them with the bot they were
+// offered if bot is set.
+type search struct {
+	playerId uuid.UUID
+	queue    string
+	cancel   bool
+	bot      bool
+}
+
+func NewQueues(cfg config.MatchmakingConfig) ([]*Queue, error) {
+	if len(cfg.Queues) == 0 {
+		return nil, errors.New("no matchmaking queues configured")
+	}
+	queues := make([]*Queue, 0, len(cfg.Queues))
+	names := make(map[string]bool)
+	for _, qc := range cfg.Queues {
+		if names[qc.Name] {
+			return nil, fmt.Errorf("duplicate matchmaking queue '%s'", qc.Name)
+		}
+		names[qc.Name] = true
+		variant, err := game.ParseVariant(qc.Variant)
+		if err != nil {
+			return nil, fmt.Errorf("queue '%s': %w", qc.Name, err)
+		}
+		matchmaker, err := matchmaking.New(cfg, variant.Players)
+		if err != nil {
+			return nil, err
+		}
+		queues = append(
+			queues, &Queue{
+				Name:       qc.Name,
+				Variant:    variant,
+				matchmaker: matchmaker,
+			},
+		)
+	}
+	return queues, nil
+}
+
+// Search queues a connected player in the named queue, or in the first
+// configured queue if no name is given, and returns the name of the queue.
+// Searching again moves the player to the new queue.
+func (gc *Cache) Search(ctx context.Context, c *Client, queue string) (string, error) {
+	if queue == "" {
+		queue = gc.queues[0].Name
+	}
+	if _, exists := gc.queue(queue); !exists {
+		return "", ErrQueueNotFound
+	}
+	if err := gc.enqueue(ctx, c, queue); err != nil {
+		return "", err
+	}
+	return queue, nil
+}
+
+// enqueue hands a search to the node running matchmaking, which drops it if
+// the queue does not exist there.
+func (gc *Cache) enqueue(ctx context.Context, c *Client, queue string) error {
+	gc.searching.store(c.Id, c.Session)
+	if gc.bus == nil {
+		gc.readyPlayersQ <- search{playerId: c.Id, queue: queue}
+		return nil
+	}
+	err := gc.bus.Publish(ctx, cluster.Event{Kind: cluster.KindEnqueue, PlayerId: c.Id, Queue: queue})
+	if err != nil {
+		gc.searching.delete(c.Id)
+	}
+	return err
+}
+
+// CancelSearch takes a player out of matchmaking, whichever session the
+// search was started from. The player stays connected and may search again.
+func (gc *Cache) CancelSearch(ctx context.Context, c *Client) {
+	gc.searching.delete(c.Id)
+	if gc.bus == nil {
+		gc.readyPlayersQ <- search{playerId: c.Id, cancel: true}
+		return
+	}
+	_ = gc.bus.Publish(ctx, cluster.Event{Kind: cluster.KindDequeue, PlayerId: c.Id})
+}
+
+// Queues lists the matchmaking queues. The waiting counts come from the node
+// running matchmaking and may lag behind by a tick.
+func (gc *Cache) Queues() []QueueInfo {
+	gc.statsMutex.RLock()
+	defer gc.statsMutex.RUnlock()
+
+	infos := make([]QueueInfo, len(gc.queues))
+	for i, q := range gc.queues {
+		infos[i] = QueueInfo{
+			Name:    q.Name,
+			Variant: q.Variant.Name,
+			Waiting: gc.stats[q.Name].Waiting,
+		}
+	}
+	return infos
+}
+
+// QueueStats returns the statistics of the named queue, as last reported by
+// the node running matchmaking.
+func (gc *Cache) QueueStats(queue string) matchmaking.Stats {
+	gc.statsMutex.RLock()
+	defer gc.statsMutex.RUnlock()
+	return gc.stats[queue]
+}
+
+func (gc *Cache) queue(name string) (*Queue, bool) {
+	for _, q := range gc.queues {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	if arenaId, err := uuid.Parse(name); err == nil {
+		return gc.arenas.load(arenaId)
+	}
+	return nil, false
+}
+
+// recordWait folds the wait of a player that just got paired into the
+// queue's average wait.
+func (q *Queue) recordWait(wait time.Duration) {
+	if q.averageWait == 0 {
+		q.averageWait = wait
+		return
+	}
+	q.averageWait = (4*q.averageWait + wait) / 5
+}
+
+// updateStats records the statistics of each queue and shares them with the
+// other nodes when they changed.
+func (gc *Cache) updateStats(ctx context.Context) {
+	stats := make(map[string]matchmaking.Stats, len(gc.queues))
+	for _, q := range append(gc.arenas.values(), gc.queues...) {
+		stats[q.Name] = matchmaking.Stats{Waiting: q.matchmaker.Len(), AverageWait: q.averageWait}
+	}
+
+	gc.statsMutex.Lock()
+	changed := !maps.Equal(stats, gc.stats)
+	gc.stats = stats
+	gc.statsMutex.Unlock()
+
+	if changed && gc.bus != nil {
+		_ = gc.bus.Publish(ctx, cluster.Event{Kind: cluster.KindQueues, Stats: stats})
+	}
+}
+
+func (gc *Cache) setStats(stats map[string]matchmaking.Stats) {
+	gc.statsMutex.Lock()
+	defer gc.statsMutex.Unlock()
+	gc.stats = stats
+}
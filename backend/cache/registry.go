@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"github.com/google/uuid"
+	"sync"
+)
+
+const shardCount = 32
+
+// registry maps player or lobby ids to values. It is split into shards with
+// their own locks so that lookups for unrelated ids do not contend.
+type registry[V any] struct {
+	shards [shardCount]shard[V]
+}
+
+type shard[V any] struct {
+	mutex sync.RWMutex
+	items map[uuid.UUID]V
+}
+
+func newRegistry[V any]() *registry[V] {
+	r := &registry[V]{}
+	for i := range r.shards {
+		r.shards[i].items = make(map[uuid.UUID]V)
+	}
+	return r
+}
+
+// shard picks a shard by the last byte of the id, which is random in both
+// version 4 and version 7 uuids.
+func (r *registry[V]) shard(id uuid.UUID) *shard[V] {
+	return &r.shards[int(id[15])%shardCount]
+}
+
+func (r *registry[V]) load(id uuid.UUID) (V, bool) {
+	s := r.shard(id)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	v, ok := s.items[id]
+	return v, ok
+}
+
+func (r *registry[V]) store(id uuid.UUID, v V) {
+	s := r.shard(id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.items[id] = v
+}
+
+// loadOrStore returns the value already stored for the id if there is one,
+// otherwise it stores v. The boolean reports whether the value was loaded.
+func (r *registry[V]) loadOrStore(id uuid.UUID, v V) (V, bool) {
+	s := r.shard(id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.items[id]; ok {
+		return existing, true
+	}
+	s.items[id] = v
+	return v, false
+}
+
+func (r *registry[V]) delete(id uuid.UUID) {
+	s := r.shard(id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.items, id)
+}
+
+// deleteFunc removes the value stored for the id only if match returns true
+// for it, so that a newer value stored in the meantime is kept.
+func (r *registry[V]) deleteFunc(id uuid.UUID, match func(V) bool) {
+	s := r.shard(id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if v, ok := s.items[id]; ok && match(v) {
+		delete(s.items, id)
+	}
+}
+
+// update replaces the value stored for the id with the one f derives from it,
+// under the shard's lock. f gets the zero value and false if nothing is
+// stored, and the value is removed if f returns false.
+func (r *registry[V]) update(id uuid.UUID, f func(V, bool) (V, bool)) {
+	s := r.shard(id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := s.items[id]
+	if v, ok = f(v, ok); ok {
+		s.items[id] = v
+	} else {
+		delete(s.items, id)
+	}
+}
+
+// values returns every value stored, in no particular order.
+func (r *registry[V]) values() []V {
+	var values []V
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mutex.RLock()
+		for _, v := range s.items {
+			values = append(values, v)
+		}
+		s.mutex.RUnlock()
+	}
+	return values
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"backend/config"
+	"backend/internal/testdb"
+	"backend/message"
+	"context"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T) *Cache {
+	t.Helper()
+	cfg := config.MatchmakingConfig{
+		ColorPolicy:  string(ColorPolicyRandom),
+		Strategy:     "fifo",
+		TickInterval: 5 * time.Millisecond,
+		BotFallback:  string(BotFallbackOff),
+		Queues:       []config.QueueConfig{{Name: "standard", Variant: "standard"}},
+	}
+	queues, err := NewQueues(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsCfg := config.WebsocketsConfig{SendQueue: 64, SlowConsumer: string(SlowConsumerDrop)}
+	gc := NewDefaultCache(testdb.New(), nil, cfg, wsCfg, queues, nil, echo.New().Logger)
+	t.Cleanup(gc.Close)
+	return gc
+}
+
+// session stands in for a client's handler, it takes everything the cache
+// hands the client until the client leaves, and passes on the games the
+// client is seated in and the ones that ended.
+func session(c *Client, seats chan<- Seat, released chan<- uuid.UUID) {
+	for {
+		select {
+		case seat := <-c.Notify:
+			select {
+			case seats <- seat:
+			case <-c.done:
+				return
+			}
+		case wr := <-c.WriteRequests:
+			if wr.Written != nil {
+				close(wr.Written)
+			}
+		case lobbyId := <-c.Released:
+			select {
+			case released <- lobbyId:
+			case <-c.done:
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// TestStress runs players searching, playing, reconnecting and leaving next
+// to players cancelling their search, and checks that no game is started
+// for a player after they cancelled. Run it with -race.
+func TestStress(t *testing.T) {
+	var finished atomic.Int64
+	gc := newTestCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gc.RunMatchmaking(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	if testing.Short() {
+		deadline = time.Now().Add(300 * time.Millisecond)
+	}
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			play(t, ctx, gc, uuid.New(), deadline, &finished)
+		}()
+	}
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cancelSearch(t, ctx, gc, uuid.New(), deadline)
+		}()
+	}
+	wg.Wait()
+	if finished.Load() == 0 {
+		t.Error("no game was played to its end")
+	}
+}
+
+// play searches for games and plays random columns in them until they end,
+// leaving and reconnecting now and then.
+func play(t *testing.T, ctx context.Context, gc *Cache, playerId uuid.UUID, deadline time.Time, finished *atomic.Int64) {
+	for time.Now().Before(deadline) {
+		c, _ := gc.Join(ctx, playerId, nil, nil)
+		seats := make(chan Seat)
+		released := make(chan uuid.UUID)
+		go session(c, seats, released)
+		if _, err := gc.Search(ctx, c, ""); err != nil {
+			t.Error(err)
+		}
+
+		games := make(map[uuid.UUID]bool)
+		ticker := time.NewTicker(time.Millisecond)
+		wait := time.After(time.Duration(50+rand.IntN(200)) * time.Millisecond)
+	game:
+		for {
+			select {
+			case seat := <-seats:
+				games[seat.Lobby.Id] = true
+				gc.Chat(ctx, seat.Lobby.Id, playerId, message.ChatMessagePayload{Text: "gl"})
+			case lobbyId := <-released:
+				delete(games, lobbyId)
+				finished.Add(1)
+			case <-ticker.C:
+				for lobbyId := range games {
+					_ = gc.Move(ctx, lobbyId, playerId, uint8(rand.IntN(7)))
+				}
+			case <-wait:
+				break game
+			}
+		}
+		ticker.Stop()
+		if rand.IntN(2) == 0 {
+			gc.CancelSearch(ctx, c)
+		}
+		gc.Leave(c)
+	}
+}
+
+// cancelSearch searches and cancels right away, then stays connected and
+// checks every game it is seated in was created before it cancelled.
+func cancelSearch(t *testing.T, ctx context.Context, gc *Cache, playerId uuid.UUID, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		c, _ := gc.Join(ctx, playerId, nil, nil)
+		seats := make(chan Seat)
+		go session(c, seats, make(chan uuid.UUID))
+		if _, err := gc.Search(ctx, c, ""); err != nil {
+			t.Error(err)
+		}
+		time.Sleep(time.Duration(rand.IntN(10)) * time.Millisecond)
+		gc.CancelSearch(ctx, c)
+		cancelledAt := time.Now().UTC()
+		if gc.Searching(c) {
+			t.Error("player still searching after cancelling")
+		}
+
+		wait := time.After(50 * time.Millisecond)
+	idle:
+		for {
+			select {
+			case seat := <-seats:
+				if seat.Lobby.CreatedAtUtc.After(cancelledAt) {
+					t.Errorf("player %v paired after cancelling", playerId)
+				}
+			case <-wait:
+				break idle
+			}
+		}
+		gc.Leave(c)
+	}
+}
@@ -0,0 +1,244 @@
+package cluster
+
+import (
+	"backend/generated/sqlc"
+	"backend/matchmaking"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"time"
+)
+
+// Channel is the Postgres notification channel shared by all nodes.
+const Channel = "connect4_cluster"
+
+// matchmakingLockKey is the advisory lock held by the node that runs
+// matchmaking.
+const matchmakingLockKey int64 = 0x636f6e6e65637434
+
+type Kind string
+
+const (
+	// KindEnqueue asks the matchmaking node to queue a player connected to
+	// the sending node in Queue, or to accept the bot game offered to them if
+	// Bot is set.
+	KindEnqueue Kind = "enqueue"
+	// KindDequeue removes a player from the matchmaking queue.
+	KindDequeue Kind = "dequeue"
+	// KindJoin asks the node owning an idle lobby to seat a player.
+	KindJoin Kind = "join"
+	// KindStarted announces that a lobby filled up and its game was saved.
+	KindStarted Kind = "started"
+	// KindCommand forwards a player's message to the node owning the lobby.
+	KindCommand Kind = "command"
+	// KindBroadcast carries a message from the owning node to the players of
+	// a lobby, or to a single player if PlayerId is set.
+	KindBroadcast Kind = "broadcast"
+	// KindQueues shares the statistics of each matchmaking queue.
+	KindQueues Kind = "queues"
+)
+
+// maxPayload keeps notifications under the 8000 bytes Postgres allows their
+// payload. Larger events, such as arena leaderboards, queue statistics or
+// long chat, are stored in the database and notified by reference.
+const maxPayload = 7900
+
+// storedEventTTL is how long stored events are kept for the nodes to read.
+const storedEventTTL = time.Minute
+
+// Event is a message exchanged between nodes.
+type Event struct {
+	Kind     Kind            `json:"kind"`
+	Node     string          `json:"node"`
+	LobbyId  uuid.UUID       `json:"lobbyId,omitempty"`
+	PlayerId uuid.UUID       `json:"playerId,omitempty"`
+	Players  []uuid.UUID     `json:"players,omitempty"`
+	MsgType  string          `json:"msgType,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	// RequestId is the id of the json.v2 request a command or reply is about
+	RequestId string `json:"requestId,omitempty"`
+	// Seq is the lobby's sequence number of a broadcast message
+	Seq   uint64                       `json:"seq,omitempty"`
+	Queue string                       `json:"queue,omitempty"`
+	Bot   bool                         `json:"bot,omitempty"`
+	Stats map[string]matchmaking.Stats `json:"stats,omitempty"`
+	// Ref is set on the notification of a stored event, which carries
+	// nothing else
+	Ref uuid.UUID `json:"ref,omitempty"`
+}
+
+type Bus struct {
+	Node   string
+	pool   *pgxpool.Pool
+	db     *sqlc.Queries
+	events chan Event
+	logger echo.Logger
+}
+
+func NewBus(pool *pgxpool.Pool, db *sqlc.Queries, node string, logger echo.Logger) *Bus {
+	return &Bus{
+		Node:   node,
+		pool:   pool,
+		db:     db,
+		events: make(chan Event, 100),
+		logger: logger,
+	}
+}
+
+// Events delivers every event published in the cluster, including the ones
+// published by this node.
+func (b *Bus) Events() <-chan Event {
+	return b.events
+}
+
+// Publish sends an event to every node. Failures are logged as well, since
+// most events are published without waiting for anyone.
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	err := b.publish(ctx, e)
+	if err != nil {
+		b.logger.Errorf("publishing %s event: %v", e.Kind, err)
+	}
+	return err
+}
+
+func (b *Bus) publish(ctx context.Context, e Event) error {
+	e.Node = b.Node
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxPayload {
+		if data, err = b.store(ctx, e, data); err != nil {
+			return err
+		}
+	}
+	return b.db.Notify(ctx, sqlc.NotifyParams{Channel: Channel, Payload: string(data)})
+}
+
+// store saves an event too large for a notification, and returns the
+// notification referring to it. Events older than storedEventTTL are deleted
+// on the way.
+func (b *Bus) store(ctx context.Context, e Event, data []byte) ([]byte, error) {
+	now := time.Now()
+	if err := b.db.DeleteClusterEvents(ctx, now.Add(-storedEventTTL)); err != nil {
+		return nil, err
+	}
+	id := uuid.New()
+	err := b.db.CreateClusterEvent(ctx, sqlc.CreateClusterEventParams{ID: id, Payload: string(data), CreatedAtUtc: now})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Event{Kind: e.Kind, Node: e.Node, Ref: id})
+}
+
+// load reads the event a notification refers to, or decodes the notification
+// itself.
+func (b *Bus) load(ctx context.Context, payload string) (Event, error) {
+	var e Event
+	if err := json.Unmarshal([]byte(payload), &e); err != nil || e.Ref == uuid.Nil {
+		return e, err
+	}
+	data, err := b.db.GetClusterEvent(ctx, e.Ref)
+	if err != nil {
+		return e, err
+	}
+	var stored Event
+	err = json.Unmarshal([]byte(data), &stored)
+	return stored, err
+}
+
+// Listen receives notifications until ctx is done, reconnecting whenever the
+// listening connection is lost.
+func (b *Bus) Listen(ctx context.Context) {
+	for ctx.Err() == nil {
+		_ = b.listen(ctx)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (b *Bus) listen(ctx context.Context) error {
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, "LISTEN "+Channel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		e, err := b.load(ctx, n.Payload)
+		if err != nil {
+			b.logger.Errorf("reading %s event: %v", e.Kind, err)
+			continue
+		}
+		select {
+		case b.events <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Lead waits until this node acquires the cluster-wide matchmaking lock and
+// then calls run. If the connection holding the lock is lost, run's context
+// is cancelled and the node competes for the lock again.
+func (b *Bus) Lead(ctx context.Context, run func(ctx context.Context)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		_ = b.lead(ctx, ticker, run)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bus) lead(ctx context.Context, ticker *time.Ticker, run func(ctx context.Context)) error {
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	acquired, err := sqlc.New(conn).TryAdvisoryLock(ctx, matchmakingLockKey)
+	if err != nil || !acquired {
+		return err
+	}
+	// the lock is released together with the session, so never hand this
+	// connection back to the pool
+	defer conn.Conn().Close(context.Background())
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(runCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err = conn.Ping(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
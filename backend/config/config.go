@@ -10,6 +10,7 @@ import (
 	"gopkg.in/yaml.v3"
 	"os"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -17,10 +18,21 @@ type Config struct {
 }
 
 type AppConfig struct {
-	Port     uint
-	DB       DBConfig
-	Security SecurityConfig
-	Logger   LoggerConfig
+	Port           uint
+	DB             DBConfig
+	Security       SecurityConfig
+	Logger         LoggerConfig
+	Puzzles        PuzzlesConfig
+	Matchmaking    MatchmakingConfig
+	Challenges     ChallengesConfig
+	Correspondence CorrespondenceConfig
+	Tournaments    TournamentsConfig
+	Simuls         SimulsConfig
+	Websockets     WebsocketsConfig
+	Cluster        ClusterConfig
+	// DebugAddr is the address metrics are served on at /debug/vars, apart
+	// from the API so that they stay internal. Empty disables them.
+	DebugAddr string `yaml:"debugAddr"`
 }
 
 type DBConfig struct {
@@ -29,6 +41,122 @@ type DBConfig struct {
 
 type SecurityConfig struct {
 	JwtSecret string `envconfig:"JWT_SECRET" yaml:"jwtSecret"`
+	// QueryToken lets websocket clients pass their token in the ?token=
+	// query parameter, which ends up in access logs. Clients should rather
+	// use a ticket or an auth message, see the handlers package.
+	QueryToken bool `yaml:"queryToken"`
+	// TicketTTL is how long a websocket ticket may be redeemed after it was
+	// issued, and AuthTimeout how long a websocket connected without
+	// credentials has to send its auth message.
+	TicketTTL   time.Duration `yaml:"ticketTtl"`
+	AuthTimeout time.Duration `yaml:"authTimeout"`
+}
+
+type ClusterConfig struct {
+	Enabled bool
+	// Node must stay the same across restarts so the node can resume the
+	// games it owned. Defaults to the hostname.
+	Node string `envconfig:"NODE_NAME"`
+}
+
+type MatchmakingConfig struct {
+	ColorPolicy  string `yaml:"colorPolicy"`
+	ColorHistory int32  `yaml:"colorHistory"`
+	// Strategy selects how queued players are paired, see the matchmaking
+	// package.
+	Strategy           string        `yaml:"strategy"`
+	TickInterval       time.Duration `yaml:"tickInterval"`
+	StatusInterval     time.Duration `yaml:"statusInterval"`
+	RatingWindow       int32         `yaml:"ratingWindow"`
+	RatingWindowGrowth int32         `yaml:"ratingWindowGrowth"`
+	// BotFallback is "off", "offer" or "assign", and applies to players who
+	// waited for BotTimeout in a two-player queue.
+	BotFallback string        `yaml:"botFallback"`
+	BotTimeout  time.Duration `yaml:"botTimeout"`
+	Queues      []QueueConfig `yaml:"queues"`
+}
+
+// QueueConfig describes a matchmaking queue. Players pick a queue by its name
+// and are only paired with players searching in the same queue. Games are
+// untimed, time controls are not supported yet.
+type QueueConfig struct {
+	Name    string `yaml:"name"`
+	Variant string `yaml:"variant"`
+}
+
+type ChallengesConfig struct {
+	// TTL is how long a challenge waits for an answer before it expires.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+type CorrespondenceConfig struct {
+	MaxDaysPerMove int `yaml:"maxDaysPerMove"`
+	// SweepInterval is how often games past their move deadline are ended.
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+	BatchSize     int32         `yaml:"batchSize"`
+}
+
+type TournamentsConfig struct {
+	MaxPlayers int `yaml:"maxPlayers"`
+	MaxRounds  int `yaml:"maxRounds"`
+	// MaxArenaMinutes bounds how long an arena runs.
+	MaxArenaMinutes int `yaml:"maxArenaMinutes"`
+	// TickInterval is how often running tournaments are checked for
+	// finished rounds.
+	TickInterval time.Duration `yaml:"tickInterval"`
+	// RoundTimeout is how long a round of a tournament may last. The games
+	// still running then are lost by the player to move, so that a player
+	// who does not show up does not hold up the tournament. Zero disables
+	// it.
+	RoundTimeout time.Duration `yaml:"roundTimeout"`
+}
+
+type SimulsConfig struct {
+	// MaxBoards bounds the number of opponents a simul host takes on.
+	MaxBoards int `yaml:"maxBoards"`
+}
+
+type WebsocketsConfig struct {
+	// PingInterval is how often clients are pinged to measure their latency
+	// and notice connections that went away, which are closed once a pong
+	// takes longer than PongTimeout.
+	PingInterval time.Duration `yaml:"pingInterval"`
+	PongTimeout  time.Duration `yaml:"pongTimeout"`
+	// IdleTimeout closes connections that neither send anything nor follow
+	// a game or search for one.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// SendQueue bounds the messages waiting for a client's socket, once it
+	// is full SlowConsumer decides between "drop" and "disconnect", see the
+	// cache package.
+	SendQueue    int    `yaml:"sendQueue"`
+	SlowConsumer string `yaml:"slowConsumer"`
+	// MaxMessageSize bounds the messages read from a client in bytes, a
+	// larger one closes the connection.
+	MaxMessageSize int64 `yaml:"maxMessageSize"`
+	// RateLimits bound how often a client may send each message type,
+	// types without a limit of their own share the "default" one.
+	RateLimits map[string]RateLimitConfig `yaml:"rateLimits"`
+	// A client exceeding a rate limit gets an error, its chat is muted for
+	// MuteDuration from MuteAfter violations on, and it is disconnected
+	// after DisconnectAfter. Violations count within ViolationWindow.
+	MuteAfter       int           `yaml:"muteAfter"`
+	MuteDuration    time.Duration `yaml:"muteDuration"`
+	DisconnectAfter int           `yaml:"disconnectAfter"`
+	ViolationWindow time.Duration `yaml:"violationWindow"`
+}
+
+type RateLimitConfig struct {
+	// Rate is the number of messages allowed per second, Burst how many
+	// may be sent at once.
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type PuzzlesConfig struct {
+	MinWinIn     int           `yaml:"minWinIn"`
+	MaxWinIn     int           `yaml:"maxWinIn"`
+	ScanInterval time.Duration `yaml:"scanInterval"`
+	BatchSize    int32         `yaml:"batchSize"`
 }
 
 type LoggerConfig struct {
@@ -48,11 +176,72 @@ var DefaultConfig = &Config{
 			URL: "",
 		},
 		Security: SecurityConfig{
-			JwtSecret: "",
+			JwtSecret:   "",
+			QueryToken:  false,
+			TicketTTL:   30 * time.Second,
+			AuthTimeout: 10 * time.Second,
 		},
 		Logger: LoggerConfig{
 			Level: LogLevel{log.INFO},
 		},
+		Puzzles: PuzzlesConfig{
+			MinWinIn:     2,
+			MaxWinIn:     4,
+			ScanInterval: time.Minute,
+			BatchSize:    50,
+		},
+		Matchmaking: MatchmakingConfig{
+			ColorPolicy:        "random",
+			ColorHistory:       10,
+			Strategy:           "fifo",
+			TickInterval:       time.Second,
+			StatusInterval:     5 * time.Second,
+			RatingWindow:       100,
+			RatingWindowGrowth: 10,
+			BotFallback:        "offer",
+			BotTimeout:         time.Minute,
+			Queues: []QueueConfig{
+				{Name: "standard", Variant: "standard"},
+				{Name: "three-player", Variant: "three-player"},
+				{Name: "four-player", Variant: "four-player"},
+			},
+		},
+		Challenges: ChallengesConfig{
+			TTL: 5 * time.Minute,
+		},
+		Correspondence: CorrespondenceConfig{
+			MaxDaysPerMove: 14,
+			SweepInterval:  time.Minute,
+			BatchSize:      100,
+		},
+		Tournaments: TournamentsConfig{
+			MaxPlayers:      64,
+			MaxRounds:       15,
+			MaxArenaMinutes: 180,
+			TickInterval:    5 * time.Second,
+			RoundTimeout:    time.Hour,
+		},
+		Simuls: SimulsConfig{
+			MaxBoards: 30,
+		},
+		Websockets: WebsocketsConfig{
+			PingInterval:   15 * time.Second,
+			PongTimeout:    10 * time.Second,
+			IdleTimeout:    10 * time.Minute,
+			SendQueue:      64,
+			SlowConsumer:   "drop",
+			MaxMessageSize: 4096,
+			RateLimits: map[string]RateLimitConfig{
+				"default":     {Rate: 5, Burst: 10},
+				"playMove":    {Rate: 2, Burst: 4},
+				"chatMessage": {Rate: 0.5, Burst: 5},
+			},
+			MuteAfter:       5,
+			MuteDuration:    time.Minute,
+			DisconnectAfter: 20,
+			ViolationWindow: time.Minute,
+		},
+		DebugAddr: "localhost:6060",
 	},
 }
 
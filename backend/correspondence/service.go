@@ -0,0 +1,367 @@
+package correspondence
+
+import (
+	"backend/config"
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/message"
+	"backend/results"
+	"backend/websockets"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"time"
+)
+
+var (
+	ErrGameNotFound = errors.New("game not found")
+	ErrGameOver     = errors.New("game is over")
+	ErrNotPlayer    = errors.New("not a player in this game")
+	ErrNotYourTurn  = errors.New("not your turn")
+	ErrIllegalMove  = errors.New("illegal move")
+	// ErrConflict is returned when the game changed while a move was being
+	// played, the move can be retried against the new position.
+	ErrConflict = errors.New("game changed in the meantime")
+)
+
+// Notifier delivers a message to a player if they are connected.
+type Notifier func(ctx context.Context, playerId uuid.UUID, wr websockets.WriteRequest)
+
+// Service runs games played over days rather than minutes. Unlike live games
+// they are not held in the cache: every request loads the game from the
+// database, so players can have any number of them going and do not need to
+// stay connected.
+type Service struct {
+	db     *sqlc.Queries
+	cfg    config.CorrespondenceConfig
+	notify Notifier
+	logger echo.Logger
+}
+
+func NewService(db *sqlc.Queries, cfg config.CorrespondenceConfig, notify Notifier, logger echo.Logger) *Service {
+	return &Service{
+		db:     db,
+		cfg:    cfg,
+		notify: notify,
+		logger: logger,
+	}
+}
+
+// Game is a correspondence game as loaded from the database.
+type Game struct {
+	Id          uuid.UUID
+	LobbyId     uuid.UUID
+	DaysPerMove int
+	// Deadline is when the player to move loses on time, nil once the game
+	// is over.
+	Deadline *time.Time
+	Players  map[game.Color]uuid.UUID
+	Over     bool
+	// Winner is ColorNone for a draw or a game still running.
+	Winner game.Color
+
+	play  *game.Game
+	moves string
+}
+
+func (g *Game) ToMove() game.Color {
+	return g.play.ToMove()
+}
+
+func (g *Game) Board() game.Board {
+	return *g.play.State
+}
+
+func (g *Game) Moves() string {
+	return g.play.StrMoves()
+}
+
+func (g *Game) colorOf(playerId uuid.UUID) (game.Color, bool) {
+	for color, pId := range g.Players {
+		if pId == playerId {
+			return color, true
+		}
+	}
+	return game.ColorNone, false
+}
+
+// Create starts a correspondence game between red and yellow in the standard
+// variant, with red to move.
+func (s *Service) Create(ctx context.Context, red uuid.UUID, yellow uuid.UUID, daysPerMove int) (*Game, error) {
+	play, err := game.New()
+	if err != nil {
+		return nil, err
+	}
+	lobbyId, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	deadline := now.Add(days(daysPerMove))
+
+	err = s.db.CreateLobby(
+		ctx, sqlc.CreateLobbyParams{
+			ID:           lobbyId,
+			CreatedAtUtc: now,
+			IsPrivate:    true,
+			Variant:      game.VariantStandard,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.CreateLobbyPlayers(
+		ctx, []sqlc.CreateLobbyPlayersParams{
+			{LobbyID: lobbyId, PlayerID: red, Color: int16(game.ColorRed)},
+			{LobbyID: lobbyId, PlayerID: yellow, Color: int16(game.ColorYellow)},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.CreateCorrespondenceGame(
+		ctx, sqlc.CreateCorrespondenceGameParams{
+			ID:              play.Id,
+			LobbyID:         lobbyId,
+			StartedAtUtc:    &now,
+			State:           play.State.StrState(),
+			Moves:           play.StrMoves(),
+			StartState:      play.Start.StrState(),
+			StartToMove:     int16(play.FirstToMove),
+			Variant:         game.VariantStandard,
+			DaysPerMove:     int16(daysPerMove),
+			MoveDeadlineUtc: &deadline,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Game{
+		Id:          play.Id,
+		LobbyId:     lobbyId,
+		DaysPerMove: daysPerMove,
+		Deadline:    &deadline,
+		Players:     map[game.Color]uuid.UUID{game.ColorRed: red, game.ColorYellow: yellow},
+		play:        play,
+		moves:       play.StrMoves(),
+	}, nil
+}
+
+// Load reads a correspondence game from the database, ending it first if the
+// player to move ran out of time.
+func (s *Service) Load(ctx context.Context, gameId uuid.UUID) (*Game, error) {
+	g, err := s.load(ctx, gameId)
+	if err != nil {
+		return nil, err
+	}
+	if !g.Over && g.Deadline != nil && time.Now().After(*g.Deadline) {
+		if err = s.timeout(ctx, g); err != nil && !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+		return s.load(ctx, gameId)
+	}
+	return g, nil
+}
+
+func (s *Service) load(ctx context.Context, gameId uuid.UUID) (*Game, error) {
+	row, err := s.db.GetCorrespondenceGame(ctx, gameId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrGameNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	start, err := game.ParseBoard(row.StartState)
+	if err != nil {
+		return nil, err
+	}
+	play, err := game.NewFromPosition(*start, game.Color(row.StartToMove))
+	if err != nil {
+		return nil, err
+	}
+	play.Id = row.ID
+	columns, err := game.ParseMoves(row.Moves)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range columns {
+		if _, _, err = play.Make(game.Move{Column: col, Color: play.ToMove()}); err != nil {
+			return nil, err
+		}
+	}
+	players, err := s.db.GetLobbyPlayers(ctx, row.LobbyID)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Game{
+		Id:          row.ID,
+		LobbyId:     row.LobbyID,
+		DaysPerMove: int(row.DaysPerMove),
+		Deadline:    row.MoveDeadlineUtc,
+		Players:     make(map[game.Color]uuid.UUID, len(players)),
+		Over:        row.EndedAtUtc != nil,
+		Winner:      game.Color(row.Winner),
+		play:        play,
+		moves:       row.Moves,
+	}
+	for _, p := range players {
+		g.Players[game.Color(p.Color)] = p.PlayerID
+	}
+	return g, nil
+}
+
+// Move plays a column for a player and tells their opponent about it.
+func (s *Service) Move(ctx context.Context, gameId uuid.UUID, playerId uuid.UUID, column uint8) (message.CorrespondenceMovePayload, error) {
+	g, err := s.Load(ctx, gameId)
+	if err != nil {
+		return message.CorrespondenceMovePayload{}, err
+	}
+	if g.Over {
+		return message.CorrespondenceMovePayload{}, ErrGameOver
+	}
+	color, isPlayer := g.colorOf(playerId)
+	if !isPlayer {
+		return message.CorrespondenceMovePayload{}, ErrNotPlayer
+	}
+	if g.ToMove() != color {
+		return message.CorrespondenceMovePayload{}, ErrNotYourTurn
+	}
+
+	row, won, err := g.play.Make(game.Move{Column: column, Color: color})
+	if err != nil {
+		return message.CorrespondenceMovePayload{}, fmt.Errorf("%w: %v", ErrIllegalMove, err)
+	}
+	played := message.CorrespondenceMovePayload{
+		GameId: g.Id.String(),
+		Color:  color,
+		Row:    row,
+		Column: column,
+	}
+	switch {
+	case won:
+		err = s.finish(ctx, g, color)
+		played.Over, played.Winner = true, color
+	case g.play.State.Full():
+		err = s.finish(ctx, g, game.ColorNone)
+		played.Over = true
+	default:
+		deadline := time.Now().UTC().Add(days(g.DaysPerMove))
+		var updated int64
+		updated, err = s.db.PlayCorrespondenceMove(
+			ctx, sqlc.PlayCorrespondenceMoveParams{
+				State:           g.play.State.StrState(),
+				Moves:           g.play.StrMoves(),
+				MoveDeadlineUtc: &deadline,
+				ID:              g.Id,
+				PreviousMoves:   g.moves,
+			},
+		)
+		if err == nil && updated == 0 {
+			err = ErrConflict
+		}
+		played.Deadline = &deadline
+	}
+	if err != nil {
+		return message.CorrespondenceMovePayload{}, err
+	}
+
+	s.notify(ctx, g.Players[color.Opponent()], websockets.WriteRequest{MsgType: message.TypeCorrespondenceMove, Payload: played})
+	return played, nil
+}
+
+// timeout ends a game whose player to move missed their deadline.
+func (s *Service) timeout(ctx context.Context, g *Game) error {
+	loser := g.ToMove()
+	if err := s.finish(ctx, g, loser.Opponent()); err != nil {
+		return err
+	}
+	for _, pId := range g.Players {
+		s.notify(
+			ctx, pId, websockets.WriteRequest{
+				MsgType: message.TypeCorrespondenceMove,
+				Payload: message.CorrespondenceMovePayload{
+					GameId:   g.Id.String(),
+					Over:     true,
+					Winner:   loser.Opponent(),
+					TimedOut: loser,
+				},
+			},
+		)
+	}
+	return nil
+}
+
+// finish records the end of a game along with its positions and updates the
+// players' ratings.
+func (s *Service) finish(ctx context.Context, g *Game, winner game.Color) error {
+	now := time.Now().UTC()
+	finished, err := s.db.FinishCorrespondenceGame(
+		ctx, sqlc.FinishCorrespondenceGameParams{
+			State:         g.play.State.StrState(),
+			Moves:         g.play.StrMoves(),
+			Winner:        int16(winner),
+			EndedAtUtc:    &now,
+			ID:            g.Id,
+			PreviousMoves: g.moves,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if finished == 0 {
+		return ErrConflict
+	}
+	g.Over, g.Winner, g.Deadline = true, winner, nil
+
+	err = results.Record(ctx, s.db, g.play, g.Players[game.ColorRed], g.Players[game.ColorYellow], winner, true)
+	if err != nil {
+		s.logger.Errorf("recording the result of game %v: %v", g.Id, err)
+	}
+	return nil
+}
+
+// Run periodically ends the games whose player to move missed their
+// deadline, so that nobody has to open a game for it to time out.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.SweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sweep(ctx); err != nil {
+			s.logger.Errorf("correspondence sweep failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) sweep(ctx context.Context) error {
+	now := time.Now().UTC()
+	expired, err := s.db.GetExpiredCorrespondenceGames(
+		ctx, sqlc.GetExpiredCorrespondenceGamesParams{
+			MoveDeadlineUtc: &now,
+			Limit:           s.cfg.BatchSize,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for _, gameId := range expired {
+		// loading a game past its deadline times it out
+		if _, err = s.Load(ctx, gameId); err != nil {
+			s.logger.Warnf("timing out game %v: %v", gameId, err)
+		}
+	}
+	return nil
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
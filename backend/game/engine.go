@@ -0,0 +1,115 @@
+package game
+
+import "math/rand/v2"
+
+const winScore = 1_000_000
+
+// columnOrder searches the centre columns first, which are usually the
+// strongest and let alpha-beta pruning cut more of the tree.
+var columnOrder = [Cols]int{3, 2, 4, 1, 5, 0, 6}
+
+// BestMove picks a move for color on b with a negamax search depth plies
+// deep. Wins are taken and losses avoided within the search horizon, beyond
+// that positions are scored by the lines each side can still complete. Equally
+// good moves are picked at random so that games against the same depth vary.
+// It returns false if b is full.
+func BestMove(b Board, color Color, depth int) (uint8, bool) {
+	if depth < 1 {
+		depth = 1
+	}
+	var best []uint8
+	bestScore := -2 * winScore
+	for _, col := range columnOrder {
+		next := b
+		row := drop(&next, col, color)
+		if row < 0 {
+			continue
+		}
+		score := winScore + depth
+		if !isWinningMove(&next, row, Move{Column: uint8(col), Color: color}) {
+			score = -negamax(next, color.Opponent(), depth-1, -2*winScore, 2*winScore)
+		}
+		switch {
+		case score > bestScore:
+			bestScore = score
+			best = []uint8{uint8(col)}
+		case score == bestScore:
+			best = append(best, uint8(col))
+		}
+	}
+	if len(best) == 0 {
+		return 0, false
+	}
+	return best[rand.IntN(len(best))], true
+}
+
+// negamax scores b from the point of view of color, who is to move. Wins found
+// sooner score higher so that the search prefers quick wins and slow losses.
+func negamax(b Board, color Color, depth int, alpha int, beta int) int {
+	if depth == 0 {
+		return evaluate(&b, color)
+	}
+	moved := false
+	for _, col := range columnOrder {
+		next := b
+		row := drop(&next, col, color)
+		if row < 0 {
+			continue
+		}
+		moved = true
+		score := winScore + depth
+		if !isWinningMove(&next, row, Move{Column: uint8(col), Color: color}) {
+			score = -negamax(next, color.Opponent(), depth-1, -beta, -alpha)
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	if !moved {
+		return 0
+	}
+	return alpha
+}
+
+// evaluate scores every window of four cells that only one side occupies,
+// weighing windows closer to completion more.
+func evaluate(b *Board, color Color) int {
+	score := 0
+	directions := [4][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for i := 0; i < Rows; i++ {
+		for j := 0; j < Cols; j++ {
+			for _, d := range directions {
+				endI, endJ := i+3*d[0], j+3*d[1]
+				if endI >= Rows || endJ < 0 || endJ >= Cols {
+					continue
+				}
+				own, other := 0, 0
+				for k := 0; k < 4; k++ {
+					switch b[i+k*d[0]][j+k*d[1]] {
+					case color:
+						own++
+					case ColorNone:
+					default:
+						other++
+					}
+				}
+				score += windowScore(own, other)
+			}
+		}
+	}
+	return score
+}
+
+func windowScore(own int, other int) int {
+	weights := [4]int{0, 1, 4, 16}
+	switch {
+	case own > 0 && other == 0:
+		return weights[own]
+	case other > 0 && own == 0:
+		return -weights[other]
+	}
+	return 0
+}
@@ -1,6 +1,7 @@
 package game
 
 import (
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"sync"
@@ -12,6 +13,8 @@ const (
 	ColorNone Color = iota
 	ColorRed
 	ColorYellow
+	ColorGreen
+	ColorBlue
 )
 
 type Move struct {
@@ -30,6 +33,15 @@ type Game struct {
 	Id    uuid.UUID
 	Moves []Move
 	State *Board
+	// Positions are the hashes of the positions the game went through, by
+	// ply, starting with the start position
+	Positions []Hash
+
+	Start       Board
+	FirstToMove Color
+
+	key       Hash
+	mirrorKey Hash
 }
 
 type Board [Rows][Cols]Color
@@ -44,12 +56,69 @@ func (b *Board) StrState() string {
 	return state
 }
 
-func New() (*Game, error) {
-	id, err := uuid.NewV7()
-	if err != nil {
-		return nil, err
+// Full reports whether no column takes another piece, which ends a game
+// nobody won in a draw.
+func (b *Board) Full() bool {
+	for _, c := range b[0] {
+		if c == ColorNone {
+			return false
+		}
 	}
+	return true
+}
 
+// StrMoves encodes the played columns as a string of digits.
+func (g *Game) StrMoves() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	columns := make([]uint8, len(g.Moves))
+	for i, move := range g.Moves {
+		columns[i] = move.Column
+	}
+	return StrLine(columns)
+}
+
+// StrLine encodes a sequence of columns as a string of digits.
+func StrLine(columns []uint8) string {
+	line := make([]byte, len(columns))
+	for i, col := range columns {
+		line[i] = '0' + col
+	}
+	return string(line)
+}
+
+// ParseMoves decodes a string produced by StrLine into columns.
+func ParseMoves(moves string) ([]uint8, error) {
+	columns := make([]uint8, len(moves))
+	for i := 0; i < len(moves); i++ {
+		col := moves[i] - '0'
+		if col >= Cols {
+			return nil, fmt.Errorf("invalid column '%c' at move %d", moves[i], i+1)
+		}
+		columns[i] = col
+	}
+	return columns, nil
+}
+
+func ParseBoard(state string) (*Board, error) {
+	if len(state) != Rows*Cols {
+		return nil, fmt.Errorf("board state must have %d cells, got %d", Rows*Cols, len(state))
+	}
+
+	var b Board
+	for i := 0; i < Rows; i++ {
+		for j := 0; j < Cols; j++ {
+			color := Color(state[i*Cols+j] - '0')
+			if color > ColorYellow {
+				return nil, fmt.Errorf("invalid cell '%c' at row %d, column %d", state[i*Cols+j], i, j)
+			}
+			b[i][j] = color
+		}
+	}
+	return &b, nil
+}
+
+func New() (*Game, error) {
 	var state Board
 	for i := 0; i < Rows; i++ {
 		var row [Cols]Color
@@ -59,45 +128,177 @@ func New() (*Game, error) {
 		state[i] = row
 	}
 
+	return NewFromPosition(state, ColorRed)
+}
+
+// NewFromPosition starts a game from a custom position with toMove to play
+// first. The position does not have to be reachable by alternating moves,
+// which allows handicap games where one side starts with extra pieces.
+func NewFromPosition(start Board, toMove Color) (*Game, error) {
+	if err := ValidatePosition(&start, toMove); err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	state := start
+	key, mirrorKey := boardKeys(&state, toMove)
 	return &Game{
-		mu:    sync.Mutex{},
-		Id:    id,
-		Moves: []Move{},
-		State: &state,
+		mu:          sync.Mutex{},
+		Id:          id,
+		Moves:       []Move{},
+		Positions:   []Hash{Canonical(key, mirrorKey)},
+		State:       &state,
+		Start:       start,
+		FirstToMove: toMove,
+		key:         key,
+		mirrorKey:   mirrorKey,
 	}, nil
 }
 
-func (g *Game) Make(move Move) (int, bool, error) {
+// PositionFromMoves plays a move prefix from the empty board and returns the
+// resulting position and the color to move.
+func PositionFromMoves(columns []uint8) (Board, Color, error) {
+	var b Board
+	color := ColorRed
+	for i, col := range columns {
+		row := drop(&b, int(col), color)
+		if row < 0 {
+			return b, color, fmt.Errorf("move %d: column %d is full", i+1, col)
+		}
+		if isWinningMove(&b, row, Move{Column: col, Color: color}) {
+			return b, color, fmt.Errorf("move %d: prefix must not end the game", i+1)
+		}
+		color = color.Opponent()
+	}
+	return b, color, nil
+}
+
+// ValidatePosition checks that a position can be played from: every piece
+// rests on another piece or the bottom row, nobody has already connected four
+// and there is at least one free cell.
+func ValidatePosition(b *Board, toMove Color) error {
+	if toMove != ColorRed && toMove != ColorYellow {
+		return fmt.Errorf("invalid color to move %d", toMove)
+	}
+
+	free := 0
+	for i := 0; i < Rows; i++ {
+		for j := 0; j < Cols; j++ {
+			color := b[i][j]
+			if color == ColorNone {
+				free++
+				continue
+			}
+			if color > ColorYellow {
+				return fmt.Errorf("invalid color %d at row %d, column %d", color, i, j)
+			}
+			if i < Rows-1 && b[i+1][j] == ColorNone {
+				return fmt.Errorf("floating piece at row %d, column %d", i, j)
+			}
+			if isWinningMove(b, i, Move{Column: uint8(j), Color: color}) {
+				return fmt.Errorf("position already has four connected at row %d, column %d", i, j)
+			}
+		}
+	}
+	if free == 0 {
+		return errors.New("position has no free cells")
+	}
+
+	return nil
+}
+
+// Standard reports whether the game started from the empty board with red
+// to move, rather than from a handicap or a custom position.
+func (g *Game) Standard() bool {
+	return g.Start == Board{} && g.FirstToMove == ColorRed
+}
+
+// ToMove returns the color whose turn it is.
+func (g *Game) ToMove() Color {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	board := g.State
-	lastI := Rows - 1
+	return g.toMove()
+}
+
+func (g *Game) toMove() Color {
 	if len(g.Moves) == 0 {
-		if move.Color == ColorYellow {
-			return lastI, false, fmt.Errorf("not %d turn", move.Color)
-		}
-		board[lastI][move.Column] = move.Color
-		g.Moves = append(g.Moves, move)
-		return lastI, false, nil
+		return g.FirstToMove
 	}
+	return g.Moves[len(g.Moves)-1].Color.Opponent()
+}
 
-	lastMove := g.Moves[len(g.Moves)-1]
-	if move.Color == lastMove.Color {
+func (g *Game) Make(move Move) (int, bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	board := g.State
+	if move.Color != g.toMove() {
 		return 0, false, fmt.Errorf("not %d turn", move.Color)
 	}
+	if move.Column >= Cols {
+		return 0, false, fmt.Errorf("column %d does not exist", move.Column)
+	}
 	if board[0][move.Column] != ColorNone {
 		return 0, false, fmt.Errorf("column %d is full", move.Column)
 	}
 
+	lastI := Rows - 1
 	for board[lastI][move.Column] != ColorNone {
 		lastI--
 	}
-	board[lastI][move.Column] = move.Color
-	g.Moves = append(g.Moves, move)
+	g.place(lastI, move)
 
 	return lastI, isWinningMove(board, lastI, move), nil
 }
 
+// Winner returns the color that connected four with the last move, or
+// ColorNone if the last move did not win.
+func (g *Game) Winner() Color {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.Moves) == 0 {
+		return ColorNone
+	}
+	move := g.Moves[len(g.Moves)-1]
+	row := 0
+	for g.State[row][move.Column] == ColorNone {
+		row++
+	}
+	if isWinningMove(g.State, row, move) {
+		return move.Color
+	}
+	return ColorNone
+}
+
+func (g *Game) place(row int, move Move) {
+	g.State[row][move.Column] = move.Color
+	g.toggle(row, move)
+	g.Moves = append(g.Moves, move)
+	g.Positions = append(g.Positions, Canonical(g.key, g.mirrorKey))
+}
+
+func (g *Game) Undo() (Move, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.Moves) == 0 {
+		return Move{}, errors.New("no moves to undo")
+	}
+
+	move := g.Moves[len(g.Moves)-1]
+	row := 0
+	for g.State[row][move.Column] == ColorNone {
+		row++
+	}
+	g.State[row][move.Column] = ColorNone
+	g.toggle(row, move)
+	g.Moves = g.Moves[:len(g.Moves)-1]
+	g.Positions = g.Positions[:len(g.Positions)-1]
+
+	return move, nil
+}
+
 func isWinningMove(board *Board, lastI int, move Move) bool {
 	color := move.Color
 	col := int(move.Column)
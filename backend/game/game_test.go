@@ -0,0 +1,35 @@
+package game
+
+import "testing"
+
+func TestStandard(t *testing.T) {
+	standard, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = standard.Make(Move{Column: 3, Color: ColorRed}); err != nil {
+		t.Fatal(err)
+	}
+	if !standard.Standard() {
+		t.Error("a game from the empty board is not standard")
+	}
+
+	var empty Board
+	yellowFirst, err := NewFromPosition(empty, ColorYellow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yellowFirst.Standard() {
+		t.Error("a game with yellow to move first is standard")
+	}
+
+	handicap := empty
+	handicap[Rows-1][3] = ColorRed
+	handicapped, err := NewFromPosition(handicap, ColorRed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handicapped.Standard() {
+		t.Error("a game with a handicap is standard")
+	}
+}
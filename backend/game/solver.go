@@ -0,0 +1,265 @@
+package game
+
+// Opponent returns the color that moves after c.
+func (c Color) Opponent() Color {
+	switch c {
+	case ColorRed:
+		return ColorYellow
+	case ColorYellow:
+		return ColorRed
+	}
+	return ColorNone
+}
+
+// drop places a piece of the given color in col and returns the row it landed
+// on, or -1 if the column is full or out of range.
+func drop(b *Board, col int, color Color) int {
+	if col < 0 || col >= Cols || b[0][col] != ColorNone {
+		return -1
+	}
+	row := Rows - 1
+	for b[row][col] != ColorNone {
+		row--
+	}
+	b[row][col] = color
+	return row
+}
+
+// ToMove returns the color to move on a board reached by alternating moves
+// starting with red.
+func (b *Board) ToMove() Color {
+	pieces := 0
+	for i := 0; i < Rows; i++ {
+		for j := 0; j < Cols; j++ {
+			if b[i][j] != ColorNone {
+				pieces++
+			}
+		}
+	}
+	if pieces%2 == 0 {
+		return ColorRed
+	}
+	return ColorYellow
+}
+
+// solver searches for forced wins. The same position is reached by many
+// move orders, so it remembers the positions it solved by their Zobrist hash,
+// which keeps the search to the distinct positions within reach.
+type solver struct {
+	solved map[solvedKey]bool
+}
+
+// solvedKey is a position, with the side to win to move, and the number of
+// moves that side has left.
+type solvedKey struct {
+	hash Hash
+	n    int
+}
+
+func newSolver() *solver {
+	return &solver{solved: make(map[solvedKey]bool)}
+}
+
+// forcedWin reports whether color can force a win in at most n of its own
+// moves regardless of the opponent's replies.
+func (s *solver) forcedWin(b Board, color Color, n int) bool {
+	if n <= 0 {
+		return false
+	}
+	// a position wins exactly when its mirror image does
+	key := solvedKey{hash: Canonical(boardKeys(&b, color)), n: n}
+	if win, found := s.solved[key]; found {
+		return win
+	}
+	win := false
+	for col := 0; col < Cols && !win; col++ {
+		win = s.winsWithin(b, color, col, n)
+	}
+	s.solved[key] = win
+	return win
+}
+
+// winsWithin reports whether playing col forces a win in at most n moves.
+func (s *solver) winsWithin(b Board, color Color, col int, n int) bool {
+	row := drop(&b, col, color)
+	if row < 0 {
+		return false
+	}
+	if isWinningMove(&b, row, Move{Column: uint8(col), Color: color}) {
+		return true
+	}
+	if n == 1 {
+		return false
+	}
+
+	opponent := color.Opponent()
+	replies := 0
+	for reply := 0; reply < Cols; reply++ {
+		next := b
+		replyRow := drop(&next, reply, opponent)
+		if replyRow < 0 {
+			continue
+		}
+		replies++
+		if isWinningMove(&next, replyRow, Move{Column: uint8(reply), Color: opponent}) {
+			return false
+		}
+		if !s.forcedWin(next, color, n-1) {
+			return false
+		}
+	}
+
+	return replies > 0
+}
+
+func (s *solver) winIn(b Board, color Color, maxMoves int) int {
+	for n := 1; n <= maxMoves; n++ {
+		if s.forcedWin(b, color, n) {
+			return n
+		}
+	}
+	return 0
+}
+
+func (s *solver) winningMoves(b Board, color Color, n int) []uint8 {
+	moves := make([]uint8, 0)
+	for col := 0; col < Cols; col++ {
+		if s.winsWithin(b, color, col, n) {
+			moves = append(moves, uint8(col))
+		}
+	}
+	return moves
+}
+
+func (s *solver) bestDefense(b Board, color Color, col uint8, n int) (uint8, bool) {
+	row := drop(&b, int(col), color)
+	if row < 0 || isWinningMove(&b, row, Move{Column: col, Color: color}) {
+		return 0, false
+	}
+	return s.bestReply(b, color, n)
+}
+
+func (s *solver) bestReply(b Board, color Color, n int) (uint8, bool) {
+	opponent := color.Opponent()
+	best, bestDepth, found := uint8(0), -1, false
+	for reply := 0; reply < Cols; reply++ {
+		next := b
+		replyRow := drop(&next, reply, opponent)
+		if replyRow < 0 {
+			continue
+		}
+		depth := s.winIn(next, color, n)
+		if isWinningMove(&next, replyRow, Move{Column: uint8(reply), Color: opponent}) || depth == 0 {
+			depth = n + 1
+		}
+		if depth > bestDepth {
+			best, bestDepth, found = uint8(reply), depth, true
+		}
+	}
+
+	return best, found
+}
+
+// WinIn returns the smallest number of moves, up to maxMoves, in which color
+// can force a win from b, or 0 if there is no such win.
+func WinIn(b Board, color Color, maxMoves int) int {
+	return newSolver().winIn(b, color, maxMoves)
+}
+
+// WinningMoves returns every column that forces a win in at most n moves.
+func WinningMoves(b Board, color Color, n int) []uint8 {
+	return newSolver().winningMoves(b, color, n)
+}
+
+// BestDefense returns the reply that delays a forced win by color the longest
+// after color has played col, searching at most n moves deep. It returns false
+// if the opponent has no legal reply or col already won the game.
+func BestDefense(b Board, color Color, col uint8, n int) (uint8, bool) {
+	return newSolver().bestDefense(b, color, col, n)
+}
+
+// BestReply returns the opponent's move on b that delays a forced win by color
+// the longest, searching at most n moves deep.
+func BestReply(b Board, color Color, n int) (uint8, bool) {
+	return newSolver().bestReply(b, color, n)
+}
+
+// ApplyLine plays line on b, with color making the even-indexed moves, and
+// returns the resulting board. It returns false if any move is illegal.
+func ApplyLine(b Board, color Color, line []uint8) (Board, bool) {
+	for i, col := range line {
+		mover := color
+		if i%2 == 1 {
+			mover = color.Opponent()
+		}
+		if drop(&b, int(col), mover) < 0 {
+			return b, false
+		}
+	}
+	return b, true
+}
+
+// SolutionLine returns a principal variation of a forced win in n moves,
+// alternating color's moves and the opponent's best defense.
+func SolutionLine(b Board, color Color, n int) []uint8 {
+	s := newSolver()
+	line := make([]uint8, 0, 2*n-1)
+	for ; n > 0; n-- {
+		moves := s.winningMoves(b, color, n)
+		if len(moves) == 0 {
+			return line
+		}
+		col := moves[0]
+		line = append(line, col)
+		reply, ok := s.bestDefense(b, color, col, n-1)
+		if !ok {
+			return line
+		}
+		line = append(line, reply)
+		drop(&b, int(col), color)
+		drop(&b, int(reply), color.Opponent())
+	}
+	return line
+}
+
+type LineResult uint8
+
+const (
+	LineIllegal LineResult = iota
+	LineWrong
+	LineCorrect
+	LineSolved
+)
+
+// CheckLine replays line from b, with color making the even-indexed moves and
+// the opponent the odd-indexed ones, and judges it against a forced win in n.
+func CheckLine(b Board, color Color, n int, line []uint8) LineResult {
+	s := newSolver()
+	opponent := color.Opponent()
+	for i, col := range line {
+		mover := color
+		if i%2 == 1 {
+			mover = opponent
+		}
+		// color's moves have to keep the win within the moves left, whatever
+		// the opponent replies
+		if mover == color && col < Cols && b[0][col] == ColorNone && !s.winsWithin(b, color, int(col), n-i/2) {
+			return LineWrong
+		}
+		row := drop(&b, int(col), mover)
+		if row < 0 {
+			return LineIllegal
+		}
+		won := isWinningMove(&b, row, Move{Column: col, Color: mover})
+		if mover == opponent {
+			if won {
+				return LineWrong
+			}
+			continue
+		}
+		if won {
+			return LineSolved
+		}
+	}
+	return LineCorrect
+}
@@ -0,0 +1,146 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func position(t *testing.T, moves string) Board {
+	t.Helper()
+	columns, err := ParseMoves(moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _, err := PositionFromMoves(columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestWinIn(t *testing.T) {
+	tests := []struct {
+		name    string
+		moves   string
+		color   Color
+		max     int
+		want    int
+		winning []uint8
+	}{
+		{"three on the bottom row", "061625", ColorRed, 3, 1, []uint8{3}},
+		{"vertical three", "060606", ColorRed, 3, 1, []uint8{0}},
+		{"open two", "2233", ColorRed, 3, 2, []uint8{1, 4}},
+		{"split two", "3311", ColorRed, 3, 2, []uint8{2}},
+		{"beyond the limit", "2233", ColorRed, 1, 0, nil},
+		{"opponent threatens first", "0616256", ColorYellow, 3, 0, nil},
+		{"empty board", "", ColorRed, 2, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := position(t, tt.moves)
+			if got := WinIn(b, tt.color, tt.max); got != tt.want {
+				t.Errorf("WinIn = %d, want %d", got, tt.want)
+			}
+			if tt.want == 0 {
+				return
+			}
+			if got := WinningMoves(b, tt.color, tt.want); !reflect.DeepEqual(got, tt.winning) {
+				t.Errorf("WinningMoves = %v, want %v", got, tt.winning)
+			}
+		})
+	}
+}
+
+func TestBestDefense(t *testing.T) {
+	// after red's 2 on 3311, yellow can block either end but not both
+	b := position(t, "3311")
+	if reply, ok := BestDefense(b, ColorRed, 2, 1); !ok || (reply != 0 && reply != 4) {
+		t.Errorf("BestDefense = %d, %v, want a block", reply, ok)
+	}
+	// red's 3 wins on the spot, nothing is left to defend
+	if _, ok := BestDefense(position(t, "061625"), ColorRed, 3, 1); ok {
+		t.Error("defense found after a winning move")
+	}
+
+	// yellow has to block red's three on the bottom row, any other reply
+	// loses at once
+	if reply, ok := BestReply(position(t, "06162"), ColorRed, 2); !ok || reply != 3 {
+		t.Errorf("BestReply = %d, %v, want 3", reply, ok)
+	}
+}
+
+func TestSolutionLine(t *testing.T) {
+	tests := []struct {
+		moves string
+		n     int
+		want  []uint8
+	}{
+		{"061625", 1, []uint8{3}},
+		{"3311", 2, []uint8{2, 0, 4}},
+	}
+	for _, tt := range tests {
+		b := position(t, tt.moves)
+		line := SolutionLine(b, b.ToMove(), tt.n)
+		if !reflect.DeepEqual(line, tt.want) {
+			t.Errorf("SolutionLine(%s) = %v, want %v", tt.moves, line, tt.want)
+		}
+		if r := CheckLine(b, b.ToMove(), tt.n, line); r != LineSolved {
+			t.Errorf("solution %v of %s judged %d", line, tt.moves, r)
+		}
+	}
+}
+
+func TestCheckLine(t *testing.T) {
+	// red wins in 2 on 3311 with 2 only
+	b := position(t, "3311")
+	tests := []struct {
+		name string
+		line []uint8
+		want LineResult
+	}{
+		{"nothing played", nil, LineCorrect},
+		{"first move", []uint8{2}, LineCorrect},
+		{"first move and a reply", []uint8{2, 4}, LineCorrect},
+		{"solved", []uint8{2, 4, 0}, LineSolved},
+		{"solved at the other end", []uint8{2, 0, 4}, LineSolved},
+		{"wrong first move", []uint8{4}, LineWrong},
+		{"wrong finish", []uint8{2, 0, 6}, LineWrong},
+		{"column out of range", []uint8{7}, LineIllegal},
+		{"reply out of range", []uint8{2, 9}, LineIllegal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckLine(b, ColorRed, 2, tt.line); got != tt.want {
+				t.Errorf("CheckLine(%v) = %d, want %d", tt.line, got, tt.want)
+			}
+		})
+	}
+
+	// the opponent connecting four along the line refutes it
+	b = position(t, "0616256")
+	if got := CheckLine(b, ColorYellow, 1, []uint8{0, 6}); got != LineWrong {
+		t.Errorf("CheckLine = %d after the opponent won, want %d", got, LineWrong)
+	}
+}
+
+func TestApplyLine(t *testing.T) {
+	b, ok := ApplyLine(Board{}, ColorRed, []uint8{3, 3, 2})
+	if !ok || b != position(t, "332") {
+		t.Errorf("ApplyLine = %s, %v", b.StrState(), ok)
+	}
+	if _, ok = ApplyLine(Board{}, ColorRed, []uint8{0, 0, 0, 0, 0, 0, 0}); ok {
+		t.Error("ApplyLine played in a full column")
+	}
+}
+
+// BenchmarkBestReply searches five moves deep from the opening, where no win
+// cuts the search short.
+func BenchmarkBestReply(b *testing.B) {
+	board, _, err := PositionFromMoves([]uint8{3})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for b.Loop() {
+		BestReply(board, ColorRed, 5)
+	}
+}
@@ -0,0 +1,265 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"sync"
+)
+
+type Rule uint8
+
+const (
+	// RuleFirstToConnect ends the game as soon as any player connects.
+	RuleFirstToConnect Rule = iota
+	// RuleElimination takes a player who connects out of the turn order with
+	// the next finishing place, and the rest play on until one is left.
+	RuleElimination
+)
+
+type Variant struct {
+	Name    string
+	Rows    int
+	Cols    int
+	Players int
+	Connect int
+	Rule    Rule
+}
+
+const VariantStandard = "standard"
+
+var Variants = map[string]Variant{
+	VariantStandard: {
+		Name: VariantStandard, Rows: Rows, Cols: Cols, Players: 2, Connect: 4, Rule: RuleFirstToConnect,
+	},
+	"three-player": {
+		Name: "three-player", Rows: 7, Cols: 9, Players: 3, Connect: 4, Rule: RuleFirstToConnect,
+	},
+	"three-player-elimination": {
+		Name: "three-player-elimination", Rows: 7, Cols: 9, Players: 3, Connect: 4, Rule: RuleElimination,
+	},
+	"four-player": {
+		Name: "four-player", Rows: 8, Cols: 10, Players: 4, Connect: 4, Rule: RuleFirstToConnect,
+	},
+	"four-player-elimination": {
+		Name: "four-player-elimination", Rows: 8, Cols: 10, Players: 4, Connect: 4, Rule: RuleElimination,
+	},
+}
+
+func ParseVariant(name string) (Variant, error) {
+	if name == "" {
+		name = VariantStandard
+	}
+	v, ok := Variants[name]
+	if !ok {
+		return Variant{}, fmt.Errorf("unknown variant '%s'", name)
+	}
+	return v, nil
+}
+
+// Colors returns the colors taking part in the variant in turn order.
+func (v Variant) Colors() []Color {
+	colors := make([]Color, v.Players)
+	for i := range colors {
+		colors[i] = Color(i + 1)
+	}
+	return colors
+}
+
+// NewGrid returns an empty board of the variant's size.
+func (v Variant) NewGrid() [][]Color {
+	grid := make([][]Color, v.Rows)
+	for i := range grid {
+		grid[i] = make([]Color, v.Cols)
+	}
+	return grid
+}
+
+// MultiGame is a game on a variant board with any number of players taking
+// turns in color order.
+type MultiGame struct {
+	mu sync.Mutex
+
+	Id       uuid.UUID
+	Variant  Variant
+	Moves    []Move
+	State    [][]Color
+	Finished []Color
+
+	active []Color
+	turn   int
+	over   bool
+}
+
+type MultiResult struct {
+	Row       int
+	Connected bool
+	// Place is the finishing place of the mover if they connected.
+	Place int
+	Over  bool
+	// Ranking lists the players in finishing order once the game is over.
+	// Players who never connected are left out.
+	Ranking []Color
+}
+
+func NewMulti(v Variant) (*MultiGame, error) {
+	if v.Players < 2 || v.Players > int(ColorBlue) {
+		return nil, fmt.Errorf("variant %s has unsupported player count %d", v.Name, v.Players)
+	}
+	// moves are stored one digit per column
+	if v.Cols > 10 {
+		return nil, fmt.Errorf("variant %s has more than 10 columns", v.Name)
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiGame{
+		mu:       sync.Mutex{},
+		Id:       id,
+		Variant:  v,
+		Moves:    []Move{},
+		State:    v.NewGrid(),
+		Finished: []Color{},
+		active:   v.Colors(),
+	}, nil
+}
+
+func (g *MultiGame) ToMove() Color {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.over {
+		return ColorNone
+	}
+	return g.active[g.turn]
+}
+
+func (g *MultiGame) Make(move Move) (MultiResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.over {
+		return MultiResult{}, errors.New("game is over")
+	}
+	if move.Color != g.active[g.turn] {
+		return MultiResult{}, fmt.Errorf("not %d turn", move.Color)
+	}
+	col := int(move.Column)
+	if col >= g.Variant.Cols {
+		return MultiResult{}, fmt.Errorf("column %d does not exist", move.Column)
+	}
+	if g.State[0][col] != ColorNone {
+		return MultiResult{}, fmt.Errorf("column %d is full", move.Column)
+	}
+
+	row := g.Variant.Rows - 1
+	for g.State[row][col] != ColorNone {
+		row--
+	}
+	g.State[row][col] = move.Color
+	g.Moves = append(g.Moves, move)
+
+	result := MultiResult{Row: row, Connected: connects(g.State, row, col, move.Color, g.Variant.Connect)}
+	if result.Connected {
+		g.Finished = append(g.Finished, move.Color)
+		result.Place = len(g.Finished)
+		g.active = append(g.active[:g.turn], g.active[g.turn+1:]...)
+		if g.turn == len(g.active) {
+			g.turn = 0
+		}
+		if g.Variant.Rule == RuleFirstToConnect || len(g.active) == 1 {
+			g.over = true
+		}
+	} else {
+		g.turn = (g.turn + 1) % len(g.active)
+	}
+	if !g.over && isFull(g.State) {
+		g.over = true
+	}
+
+	if g.over {
+		result.Over = true
+		result.Ranking = append([]Color{}, g.Finished...)
+		if g.Variant.Rule == RuleElimination && len(g.active) == 1 {
+			result.Ranking = append(result.Ranking, g.active[0])
+		}
+	}
+
+	return result, nil
+}
+
+func isFull(state [][]Color) bool {
+	for _, cell := range state[0] {
+		if cell == ColorNone {
+			return false
+		}
+	}
+	return true
+}
+
+// connects reports whether the piece at row, col is part of a line of at
+// least n pieces of its color.
+func connects(state [][]Color, row int, col int, color Color, n int) bool {
+	directions := [4][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for _, d := range directions {
+		count := 1
+		for _, sign := range [2]int{1, -1} {
+			r, c := row+sign*d[0], col+sign*d[1]
+			for r >= 0 && r < len(state) && c >= 0 && c < len(state[r]) && state[r][c] == color {
+				count++
+				r, c = r+sign*d[0], c+sign*d[1]
+			}
+		}
+		if count >= n {
+			return true
+		}
+	}
+	return false
+}
+
+// StrGrid encodes a variant board in the same row-major format as StrState.
+func StrGrid(state [][]Color) string {
+	var sb strings.Builder
+	for _, row := range state {
+		for _, cell := range row {
+			sb.WriteByte('0' + byte(cell))
+		}
+	}
+	return sb.String()
+}
+
+// Snapshot returns a copy of the board and the last color that moved.
+func (g *MultiGame) Snapshot() ([][]Color, Color) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	state := make([][]Color, len(g.State))
+	for i, row := range g.State {
+		state[i] = append([]Color{}, row...)
+	}
+	lastPlayed := ColorNone
+	if len(g.Moves) > 0 {
+		lastPlayed = g.Moves[len(g.Moves)-1].Color
+	}
+	return state, lastPlayed
+}
+
+func (g *MultiGame) StrMoves() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	columns := make([]uint8, len(g.Moves))
+	for i, move := range g.Moves {
+		columns[i] = move.Column
+	}
+	return StrLine(columns)
+}
+
+// Grid returns the board as a slice of rows, matching the layout of variant
+// boards.
+func (b *Board) Grid() [][]Color {
+	grid := make([][]Color, Rows)
+	for i := range grid {
+		grid[i] = append([]Color{}, b[i][:]...)
+	}
+	return grid
+}
@@ -0,0 +1,98 @@
+package game
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Hash is a 64-bit Zobrist key identifying a board position.
+type Hash uint64
+
+// zobristSeed is fixed so that hashes stay stable across restarts and can be
+// persisted and searched in the database.
+const zobristSeed uint64 = 0x9e3779b97f4a7c15
+
+var (
+	zobristKeys [Rows][Cols][ColorYellow + 1]Hash
+	zobristTurn Hash
+)
+
+func init() {
+	state := zobristSeed
+	for i := 0; i < Rows; i++ {
+		for j := 0; j < Cols; j++ {
+			for c := ColorRed; c <= ColorYellow; c++ {
+				zobristKeys[i][j][c] = Hash(splitMix64(&state))
+			}
+		}
+	}
+	zobristTurn = Hash(splitMix64(&state))
+}
+
+func splitMix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+func ParseHash(s string) (Hash, error) {
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid position hash '%s'", s)
+	}
+	return Hash(v), nil
+}
+
+// Canonical returns the smaller of a position hash and the hash of its mirror
+// image, so that a position and its left-right reflection share one key.
+func Canonical(h Hash, mirror Hash) Hash {
+	if mirror < h {
+		return mirror
+	}
+	return h
+}
+
+// HashBoard computes the canonical hash of a board from scratch, with toMove
+// to play. The side to move cannot be told from the pieces alone, games from
+// a handicap or a custom position may start with either color.
+func HashBoard(b *Board, toMove Color) Hash {
+	return Canonical(boardKeys(b, toMove))
+}
+
+func boardKeys(b *Board, toMove Color) (Hash, Hash) {
+	var h, mirror Hash
+	for i := 0; i < Rows; i++ {
+		for j := 0; j < Cols; j++ {
+			color := b[i][j]
+			if color == ColorNone {
+				continue
+			}
+			h ^= zobristKeys[i][j][color]
+			mirror ^= zobristKeys[i][Cols-1-j][color]
+		}
+	}
+	if toMove == ColorYellow {
+		h ^= zobristTurn
+		mirror ^= zobristTurn
+	}
+	return h, mirror
+}
+
+func (g *Game) toggle(row int, move Move) {
+	col := int(move.Column)
+	g.key ^= zobristKeys[row][col][move.Color] ^ zobristTurn
+	g.mirrorKey ^= zobristKeys[row][Cols-1-col][move.Color] ^ zobristTurn
+}
+
+// Hash returns the canonical hash of the current position.
+func (g *Game) Hash() Hash {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return Canonical(g.key, g.mirrorKey)
+}
@@ -0,0 +1,197 @@
+package game
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// mirror reflects a board left to right.
+func mirror(b Board) Board {
+	var m Board
+	for i := 0; i < Rows; i++ {
+		for j := 0; j < Cols; j++ {
+			m[i][Cols-1-j] = b[i][j]
+		}
+	}
+	return m
+}
+
+// randomGame plays random moves until the game ends or n moves were played,
+// and returns the columns played.
+func randomGame(t *testing.T, r *rand.Rand, n int) (*Game, []uint8) {
+	t.Helper()
+	g, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var columns []uint8
+	for len(columns) < n && !g.State.Full() {
+		col := uint8(r.IntN(Cols))
+		if g.State[0][col] != ColorNone {
+			continue
+		}
+		_, won, err := g.Make(Move{Column: col, Color: g.ToMove()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, col)
+		if won {
+			break
+		}
+	}
+	return g, columns
+}
+
+func TestHashMatchesBoard(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 200 {
+		g, _ := randomGame(t, r, Rows*Cols)
+		// every position on the way is hashed incrementally as it is played,
+		// undoing the moves walks back through them
+		for len(g.Moves) > 0 {
+			if got, want := g.Hash(), HashBoard(g.State, g.ToMove()); got != want {
+				t.Fatalf("incremental hash %v, board hash %v after %s", got, want, g.StrMoves())
+			}
+			if got := g.Positions[len(g.Positions)-1]; got != g.Hash() {
+				t.Fatalf("last recorded position %v, hash %v", got, g.Hash())
+			}
+			if _, err := g.Undo(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if g.Hash() != 0 || len(g.Positions) != 1 || g.Positions[0] != 0 {
+			t.Fatalf("hash %v with %d positions after undoing every move", g.Hash(), len(g.Positions))
+		}
+	}
+}
+
+func TestHashMirror(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	for range 200 {
+		g, columns := randomGame(t, r, 1+r.IntN(20))
+		mirrored := make([]uint8, len(columns))
+		for i, col := range columns {
+			mirrored[i] = Cols - 1 - col
+		}
+		m, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, col := range mirrored {
+			if _, _, err = m.Make(Move{Column: col, Color: m.ToMove()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if g.Hash() != m.Hash() {
+			t.Errorf("%s hashes to %v, its mirror %s to %v", StrLine(columns), g.Hash(), StrLine(mirrored), m.Hash())
+		}
+		if b := mirror(*g.State); HashBoard(g.State, g.ToMove()) != HashBoard(&b, g.ToMove()) {
+			t.Errorf("board %s and its mirror hash differently", g.State.StrState())
+		}
+	}
+}
+
+func TestHashTransposition(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"3024", "2034"},
+		{"0615", "1506"},
+		{"334455", "553344"},
+	}
+	for _, tt := range tests {
+		a, _ := ParseMoves(tt.a)
+		b, _ := ParseMoves(tt.b)
+		ab, toMove, err := PositionFromMoves(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bb, _, err := PositionFromMoves(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if HashBoard(&ab, toMove) != HashBoard(&bb, toMove) {
+			t.Errorf("%s and %s reach the same position with different hashes", tt.a, tt.b)
+		}
+	}
+}
+
+// TestHashDistinguishes checks that positions hash alike only if they are
+// the same up to mirroring.
+func TestHashDistinguishes(t *testing.T) {
+	r := rand.New(rand.NewPCG(5, 6))
+	seen := make(map[Hash]string)
+	for range 2000 {
+		g, _ := randomGame(t, r, 1+r.IntN(Rows*Cols))
+		state, mirrored := g.State.StrState(), mirror(*g.State)
+		canonical := min(state, mirrored.StrState())
+		h := g.Hash()
+		if other, found := seen[h]; found && other != canonical {
+			t.Fatalf("%s and %s share hash %v", other, canonical, h)
+		}
+		seen[h] = canonical
+	}
+}
+
+func TestHashSideToMove(t *testing.T) {
+	b, _, err := PositionFromMoves([]uint8{3, 3, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	yellow, err := NewFromPosition(b, ColorYellow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red, err := NewFromPosition(b, ColorRed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yellow.Hash() != HashBoard(&b, ColorYellow) {
+		t.Errorf("hash %v, board hash %v", yellow.Hash(), HashBoard(&b, ColorYellow))
+	}
+	if red.Hash() != HashBoard(&b, ColorRed) {
+		t.Errorf("hash %v, board hash %v", red.Hash(), HashBoard(&b, ColorRed))
+	}
+	if yellow.Positions[0] != yellow.Hash() {
+		t.Errorf("start position %v, hash %v", yellow.Positions[0], yellow.Hash())
+	}
+	if yellow.Hash() == red.Hash() {
+		t.Error("the side to move does not change the hash")
+	}
+	if yellow.Hash()^red.Hash() != zobristTurn {
+		t.Errorf("hashes differ by %v, not the turn key", yellow.Hash()^red.Hash())
+	}
+}
+
+// TestHashStable pins a hash, which must not change since hashes are stored
+// with the games.
+func TestHashStable(t *testing.T) {
+	b, _, err := PositionFromMoves([]uint8{3, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := HashBoard(&b, ColorRed).String(), "527843c3af7807df"; got != want {
+		t.Errorf("hash of 32 is %s, want %s", got, want)
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	for _, h := range []Hash{0, 1, 0xffffffffffffffff, 0x9e3779b97f4a7c15} {
+		parsed, err := ParseHash(h.String())
+		if err != nil || parsed != h {
+			t.Errorf("ParseHash(%s) = %v, %v", h, parsed, err)
+		}
+	}
+	for _, s := range []string{"", "xyz", "1ffffffffffffffff"} {
+		if _, err := ParseHash(s); err == nil {
+			t.Errorf("ParseHash(%q) succeeded", s)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	if Canonical(2, 1) != 1 || Canonical(1, 2) != 1 || Canonical(5, 5) != 5 {
+		t.Error("Canonical does not return the smaller hash")
+	}
+}
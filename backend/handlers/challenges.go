@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"backend/cache"
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	ChallengeStatusPending   = "pending"
+	ChallengeStatusAccepted  = "accepted"
+	ChallengeStatusDeclined  = "declined"
+	ChallengeStatusCancelled = "cancelled"
+)
+
+type CreateChallengeRequest struct {
+	Username string `json:"username" validate:"required"`
+	Variant  string `json:"variant,omitempty"`
+	Color    string `json:"color,omitempty" validate:"omitempty,oneof=red yellow"`
+	// DaysPerMove makes the game a correspondence game, played without
+	// staying connected.
+	DaysPerMove int `json:"daysPerMove,omitempty" validate:"min=0"`
+}
+
+type ChallengeResponse struct {
+	Id         string `json:"id"`
+	Challenger string `json:"challenger"`
+	Challenged string `json:"challenged"`
+	Variant    string `json:"variant"`
+	// Color is the color the challenger plays, ColorNone when it is assigned
+	// at random.
+	Color       game.Color `json:"color"`
+	DaysPerMove int        `json:"daysPerMove,omitempty"`
+	Status      string     `json:"status"`
+	LobbyId     string     `json:"lobbyId,omitempty"`
+	// GameId is set once a correspondence challenge was accepted.
+	GameId    string    `json:"gameId,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func newChallengeResponse(ch sqlc.Challenge, challenger string, challenged string) ChallengeResponse {
+	response := ChallengeResponse{
+		Id:          ch.ID.String(),
+		Challenger:  challenger,
+		Challenged:  challenged,
+		Variant:     ch.Variant,
+		Color:       game.Color(ch.Color),
+		DaysPerMove: int(ch.DaysPerMove),
+		Status:      ch.Status,
+		ExpiresAt:   ch.ExpiresAtUtc,
+	}
+	if ch.LobbyID != nil && ch.DaysPerMove == 0 {
+		response.LobbyId = ch.LobbyID.String()
+	}
+	return response
+}
+
+// CreateChallenge challenges another player to a game, who is notified right
+// away if they are connected.
+func (h *Handler) CreateChallenge(c echo.Context) error {
+	var request CreateChallengeRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+
+	variant, err := game.ParseVariant(request.Variant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if variant.Players > 2 {
+		return echo.NewHTTPError(http.StatusBadRequest, "challenges are only supported in two-player variants")
+	}
+	if request.DaysPerMove > h.Config.App.Correspondence.MaxDaysPerMove {
+		return echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("at most %d days per move are allowed", h.Config.App.Correspondence.MaxDaysPerMove),
+		)
+	}
+
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+
+	target, err := h.DB.GetUserByUsername(ctx, request.Username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return err
+	}
+	if target.ID == claims.UserID {
+		return echo.NewHTTPError(http.StatusBadRequest, "cannot challenge yourself")
+	}
+	if target.IsBot {
+		return echo.NewHTTPError(http.StatusBadRequest, "bots cannot be challenged")
+	}
+
+	challengeId, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	ch := sqlc.Challenge{
+		ID:           challengeId,
+		ChallengerID: claims.UserID,
+		ChallengedID: target.ID,
+		Variant:      variant.Name,
+		DaysPerMove:  int16(request.DaysPerMove),
+		Status:       ChallengeStatusPending,
+		CreatedAtUtc: now,
+		ExpiresAtUtc: now.Add(h.Config.App.Challenges.TTL),
+	}
+	switch request.Color {
+	case "red":
+		ch.Color = int16(game.ColorRed)
+	case "yellow":
+		ch.Color = int16(game.ColorYellow)
+	}
+	err = h.DB.CreateChallenge(
+		ctx, sqlc.CreateChallengeParams{
+			ID:           ch.ID,
+			ChallengerID: ch.ChallengerID,
+			ChallengedID: ch.ChallengedID,
+			Variant:      ch.Variant,
+			Color:        ch.Color,
+			DaysPerMove:  ch.DaysPerMove,
+			CreatedAtUtc: ch.CreatedAtUtc,
+			ExpiresAtUtc: ch.ExpiresAtUtc,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	c.Logger().Infof("%v challenged %v", claims.Username, target.Username)
+
+	h.GameCache.SendTo(
+		ctx, target.ID, websockets.WriteRequest{
+			MsgType: message.TypeChallenge,
+			Payload: message.ChallengePayload{
+				Id:          ch.ID.String(),
+				From:        claims.Username,
+				Variant:     ch.Variant,
+				Color:       game.Color(ch.Color),
+				DaysPerMove: request.DaysPerMove,
+				ExpiresAt:   ch.ExpiresAtUtc,
+			},
+		},
+	)
+
+	return c.JSON(http.StatusCreated, newChallengeResponse(ch, claims.Username, target.Username))
+}
+
+// ListChallenges returns the pending challenges the player sent or received.
+func (h *Handler) ListChallenges(c echo.Context) error {
+	claims := userClaims(c)
+	rows, err := h.DB.GetPendingChallenges(
+		c.Request().Context(), sqlc.GetPendingChallengesParams{
+			PlayerID: claims.UserID,
+			Now:      time.Now().UTC(),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	response := make([]ChallengeResponse, len(rows))
+	for i, row := range rows {
+		ch := sqlc.Challenge{
+			ID:           row.ID,
+			ChallengerID: row.ChallengerID,
+			ChallengedID: row.ChallengedID,
+			Variant:      row.Variant,
+			Color:        row.Color,
+			DaysPerMove:  row.DaysPerMove,
+			Status:       row.Status,
+			LobbyID:      row.LobbyID,
+			CreatedAtUtc: row.CreatedAtUtc,
+			ExpiresAtUtc: row.ExpiresAtUtc,
+		}
+		response[i] = newChallengeResponse(ch, row.ChallengerUsername, row.ChallengedUsername)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// AcceptChallenge starts the game of a challenge the player received. Both
+// players are seated in a new lobby right away and get the game when they
+// connect, or at once if they are already connected.
+func (h *Handler) AcceptChallenge(c echo.Context) error {
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+
+	ch, err := h.challenge(c)
+	if err != nil {
+		return err
+	}
+	if ch.ChallengedID != claims.UserID {
+		return echo.NewHTTPError(http.StatusForbidden, "not challenged by this challenge")
+	}
+
+	var gameId string
+	if ch.DaysPerMove > 0 {
+		gameId, err = h.startCorrespondenceChallenge(ctx, &ch)
+	} else {
+		err = h.startLiveChallenge(ctx, &ch)
+	}
+	if err != nil {
+		return err
+	}
+	if err = h.DB.SetChallengeLobby(ctx, sqlc.SetChallengeLobbyParams{ID: ch.ID, LobbyID: ch.LobbyID}); err != nil {
+		return err
+	}
+
+	c.Logger().Infof("%v accepted challenge %v", claims.Username, ch.ID)
+
+	h.notifyAnswer(ctx, ch.ChallengerID, ch, gameId)
+
+	challenger, err := h.DB.GetUserById(ctx, ch.ChallengerID)
+	if err != nil {
+		return err
+	}
+	response := newChallengeResponse(ch, challenger.Username, claims.Username)
+	response.GameId = gameId
+	return c.JSON(http.StatusOK, response)
+}
+
+// startLiveChallenge seats both players in a new lobby of the cache, next to
+// any other games they are playing. The challenge is accepted first, so that
+// accepting it twice cannot start two games, and reopened if its game does
+// not start.
+func (h *Handler) startLiveChallenge(ctx context.Context, ch *sqlc.Challenge) error {
+	variant, err := game.ParseVariant(ch.Variant)
+	if err != nil {
+		return err
+	}
+	if err = h.answerChallenge(ctx, ch, ChallengeStatusAccepted); err != nil {
+		return err
+	}
+
+	lobby, err := h.GameCache.StartChallenge(
+		ctx, cache.LobbyOptions{
+			Variant:    variant,
+			Private:    true,
+			Owner:      ch.ChallengerID,
+			OwnerColor: game.Color(ch.Color),
+		}, ch.ChallengerID, ch.ChallengedID,
+	)
+	if err != nil {
+		return h.reopenChallenge(ctx, ch, err)
+	}
+	ch.LobbyID = &lobby.Id
+	return nil
+}
+
+// startCorrespondenceChallenge creates the correspondence game of a challenge
+// and returns its id. Like a live challenge, the challenge is reopened if the
+// game cannot be created.
+func (h *Handler) startCorrespondenceChallenge(ctx context.Context, ch *sqlc.Challenge) (string, error) {
+	if err := h.answerChallenge(ctx, ch, ChallengeStatusAccepted); err != nil {
+		return "", err
+	}
+	red, yellow := ch.ChallengerID, ch.ChallengedID
+	switch game.Color(ch.Color) {
+	case game.ColorYellow:
+		red, yellow = yellow, red
+	case game.ColorNone:
+		if rand.IntN(2) == 0 {
+			red, yellow = yellow, red
+		}
+	}
+
+	g, err := h.Correspondence.Create(ctx, red, yellow, int(ch.DaysPerMove))
+	if err != nil {
+		return "", h.reopenChallenge(ctx, ch, err)
+	}
+	ch.LobbyID = &g.LobbyId
+	return g.Id.String(), nil
+}
+
+// DeclineChallenge turns down a challenge the player received.
+func (h *Handler) DeclineChallenge(c echo.Context) error {
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+
+	ch, err := h.challenge(c)
+	if err != nil {
+		return err
+	}
+	if ch.ChallengedID != claims.UserID {
+		return echo.NewHTTPError(http.StatusForbidden, "not challenged by this challenge")
+	}
+	if err = h.answerChallenge(ctx, &ch, ChallengeStatusDeclined); err != nil {
+		return err
+	}
+
+	h.notifyAnswer(ctx, ch.ChallengerID, ch, "")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CancelChallenge withdraws a challenge the player sent.
+func (h *Handler) CancelChallenge(c echo.Context) error {
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+
+	ch, err := h.challenge(c)
+	if err != nil {
+		return err
+	}
+	if ch.ChallengerID != claims.UserID {
+		return echo.NewHTTPError(http.StatusForbidden, "not the challenger of this challenge")
+	}
+	if err = h.answerChallenge(ctx, &ch, ChallengeStatusCancelled); err != nil {
+		return err
+	}
+
+	h.notifyAnswer(ctx, ch.ChallengedID, ch, "")
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) challenge(c echo.Context) (sqlc.Challenge, error) {
+	challengeId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return sqlc.Challenge{}, echo.NewHTTPError(http.StatusBadRequest, "invalid challenge id")
+	}
+	ch, err := h.DB.GetChallengeById(c.Request().Context(), challengeId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.Challenge{}, echo.NewHTTPError(http.StatusNotFound, "challenge not found")
+	}
+	return ch, err
+}
+
+// answerChallenge moves a pending challenge to its final status. It fails if
+// the challenge expired or was answered in the meantime.
+func (h *Handler) answerChallenge(ctx context.Context, ch *sqlc.Challenge, status string) error {
+	answered, err := h.DB.AnswerChallenge(
+		ctx, sqlc.AnswerChallengeParams{
+			Status: status,
+			ID:     ch.ID,
+			Now:    time.Now().UTC(),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if answered == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "challenge expired or was answered already")
+	}
+	ch.Status = status
+	return nil
+}
+
+// reopenChallenge puts an accepted challenge whose game failed to start back
+// to pending, so that it can be accepted again, and returns the error the
+// game failed with.
+func (h *Handler) reopenChallenge(ctx context.Context, ch *sqlc.Challenge, err error) error {
+	// the request may be gone already, the challenge is reopened anyway
+	if reopenErr := h.DB.ReopenChallenge(context.WithoutCancel(ctx), ch.ID); reopenErr != nil {
+		return errors.Join(err, reopenErr)
+	}
+	ch.Status = ChallengeStatusPending
+	return err
+}
+
+func (h *Handler) notifyAnswer(ctx context.Context, playerId uuid.UUID, ch sqlc.Challenge, gameId string) {
+	payload := message.ChallengeAnsweredPayload{Id: ch.ID.String(), Status: ch.Status, GameId: gameId}
+	if ch.LobbyID != nil && ch.DaysPerMove == 0 {
+		payload.LobbyId = ch.LobbyID.String()
+	}
+	h.GameCache.SendTo(ctx, playerId, websockets.WriteRequest{MsgType: message.TypeChallengeAnswered, Payload: payload})
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"backend/correspondence"
+	"backend/game"
+	"backend/message"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type CorrespondenceGameResponse struct {
+	Id          string                `json:"id"`
+	DaysPerMove int                   `json:"daysPerMove"`
+	Players     map[game.Color]string `json:"players"`
+	State       game.Board            `json:"state"`
+	Moves       string                `json:"moves"`
+	ToMove      game.Color            `json:"toMove"`
+	Deadline    *time.Time            `json:"deadline,omitempty"`
+	Over        bool                  `json:"over"`
+	Winner      game.Color            `json:"winner"`
+}
+
+type CorrespondenceSummaryResponse struct {
+	Id          string     `json:"id"`
+	Opponent    string     `json:"opponent"`
+	Color       game.Color `json:"color"`
+	State       game.Board `json:"state"`
+	DaysPerMove int        `json:"daysPerMove"`
+	YourTurn    bool       `json:"yourTurn"`
+	Deadline    *time.Time `json:"deadline,omitempty"`
+}
+
+type CorrespondenceMoveRequest struct {
+	Column uint8 `json:"column" validate:"max=6"`
+}
+
+// ListCorrespondenceGames returns the running correspondence games of the
+// player, the most urgent first.
+func (h *Handler) ListCorrespondenceGames(c echo.Context) error {
+	claims := userClaims(c)
+	rows, err := h.DB.GetCorrespondenceGamesByPlayer(c.Request().Context(), claims.UserID)
+	if err != nil {
+		return err
+	}
+
+	response := make([]CorrespondenceSummaryResponse, 0, len(rows))
+	for _, row := range rows {
+		board, err := game.ParseBoard(row.State)
+		if err != nil {
+			return err
+		}
+		// moves alternate from the starting color in the two-player games
+		// correspondence is played in
+		toMove := game.Color(row.StartToMove)
+		if len(row.Moves)%2 == 1 {
+			toMove = toMove.Opponent()
+		}
+		response = append(
+			response, CorrespondenceSummaryResponse{
+				Id:          row.ID.String(),
+				Opponent:    row.Opponent,
+				Color:       game.Color(row.Color),
+				State:       *board,
+				DaysPerMove: int(row.DaysPerMove),
+				YourTurn:    toMove == game.Color(row.Color),
+				Deadline:    row.MoveDeadlineUtc,
+			},
+		)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) GetCorrespondenceGame(c echo.Context) error {
+	gameId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid game id")
+	}
+	ctx := c.Request().Context()
+
+	g, err := h.Correspondence.Load(ctx, gameId)
+	if err != nil {
+		return correspondenceError(err)
+	}
+	response := CorrespondenceGameResponse{
+		Id:          g.Id.String(),
+		DaysPerMove: g.DaysPerMove,
+		Players:     make(map[game.Color]string, len(g.Players)),
+		State:       g.Board(),
+		Moves:       g.Moves(),
+		ToMove:      g.ToMove(),
+		Deadline:    g.Deadline,
+		Over:        g.Over,
+		Winner:      g.Winner,
+	}
+	for color, pId := range g.Players {
+		user, err := h.DB.GetUserById(ctx, pId)
+		if err != nil {
+			return err
+		}
+		response.Players[color] = user.Username
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) PlayCorrespondenceMove(c echo.Context) error {
+	var request CorrespondenceMoveRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+	gameId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid game id")
+	}
+
+	claims := userClaims(c)
+	played, err := h.Correspondence.Move(c.Request().Context(), gameId, claims.UserID, request.Column)
+	if err != nil {
+		return correspondenceError(err)
+	}
+
+	return c.JSON(http.StatusOK, played)
+}
+
+// correspondenceMove plays a correspondence move sent over a websocket.
+func (h *Handler) correspondenceMove(ctx context.Context, playerId uuid.UUID, msg message.Message) (message.CorrespondenceMovePayload, error) {
+	var moveMsg message.PlayCorrespondenceMovePayload
+	if err := json.Unmarshal(msg.Payload, &moveMsg); err != nil {
+		return message.CorrespondenceMovePayload{}, err
+	}
+	gameId, err := uuid.Parse(moveMsg.GameId)
+	if err != nil {
+		return message.CorrespondenceMovePayload{}, errors.New("invalid game id")
+	}
+	return h.Correspondence.Move(ctx, gameId, playerId, moveMsg.Column)
+}
+
+func correspondenceError(err error) error {
+	switch {
+	case errors.Is(err, correspondence.ErrGameNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, correspondence.ErrNotPlayer):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, correspondence.ErrGameOver),
+		errors.Is(err, correspondence.ErrNotYourTurn),
+		errors.Is(err, correspondence.ErrConflict):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, correspondence.ErrIllegalMove):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"backend/cache"
+	"backend/game"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type CreateLobbyRequest struct {
+	Variant     string     `json:"variant,omitempty"`
+	Private     bool       `json:"private"`
+	StartState  string     `json:"startState,omitempty" validate:"omitempty,len=42,numeric"`
+	StartToMove game.Color `json:"startToMove,omitempty" validate:"omitempty,min=1,max=2"`
+	MovePrefix  string     `json:"movePrefix,omitempty" validate:"omitempty,numeric,excluded_with=StartState"`
+	Color       string     `json:"color,omitempty" validate:"omitempty,oneof=red yellow"`
+}
+
+type CreateLobbyResponse struct {
+	LobbyId     string         `json:"lobbyId"`
+	Variant     string         `json:"variant"`
+	State       [][]game.Color `json:"state"`
+	StartToMove game.Color     `json:"startToMove"`
+}
+
+func (h *Handler) CreateLobby(c echo.Context) error {
+	var request CreateLobbyRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+
+	variant, err := game.ParseVariant(request.Variant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if variant.Players > 2 && (request.StartState != "" || request.MovePrefix != "") {
+		return echo.NewHTTPError(http.StatusBadRequest, "custom starting positions are only supported in the standard variant")
+	}
+
+	claims := userClaims(c)
+	options := cache.LobbyOptions{
+		Variant:     variant,
+		Private:     request.Private,
+		Owner:       claims.UserID,
+		StartToMove: request.StartToMove,
+	}
+	switch request.Color {
+	case "red":
+		options.OwnerColor = game.ColorRed
+	case "yellow":
+		options.OwnerColor = game.ColorYellow
+	}
+	switch {
+	case request.StartState != "":
+		start, err := game.ParseBoard(request.StartState)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		options.Start = *start
+		if options.StartToMove == game.ColorNone {
+			options.StartToMove = start.ToMove()
+		}
+	case request.MovePrefix != "":
+		columns, err := game.ParseMoves(request.MovePrefix)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		start, toMove, err := game.PositionFromMoves(columns)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		options.Start = start
+		options.StartToMove = toMove
+	}
+	if options.StartToMove == game.ColorNone {
+		options.StartToMove = game.ColorRed
+	}
+	if err := game.ValidatePosition(&options.Start, options.StartToMove); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	lobby, err := h.GameCache.CreateLobby(options)
+	if err != nil {
+		return err
+	}
+	snapshot, err := lobby.Snapshot(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	c.Logger().Infof("%v created lobby %v", claims.Username, lobby.Id)
+
+	return c.JSON(
+		http.StatusCreated, CreateLobbyResponse{
+			LobbyId:     lobby.Id.String(),
+			Variant:     lobby.Variant.Name,
+			State:       snapshot.State,
+			StartToMove: snapshot.ToMove,
+		},
+	)
+}
@@ -3,9 +3,11 @@ package handlers
 import (
 	"backend/cache"
 	"backend/config"
+	"backend/correspondence"
 	"backend/generated/sqlc"
+	"backend/simul"
+	"backend/tournament"
 	"context"
-	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	echojwt "github.com/labstack/echo-jwt/v4"
@@ -14,11 +16,14 @@ import (
 )
 
 type Handler struct {
-	DB        *sqlc.Queries
-	Config    config.Config
-	Conn      *pgxpool.Pool
-	GameCache *cache.Cache
-	BaseCtx   context.Context
+	DB             *sqlc.Queries
+	Config         config.Config
+	Conn           *pgxpool.Pool
+	GameCache      *cache.Cache
+	Correspondence *correspondence.Service
+	Tournaments    *tournament.Service
+	Simuls         *simul.Service
+	BaseCtx        context.Context
 }
 
 func ConfigureRoutes(h *Handler, e *echo.Echo) {
@@ -44,16 +49,47 @@ func ConfigureRoutes(h *Handler, e *echo.Echo) {
 	)
 
 	games := apiV1.Group("/games")
-	games.GET(
-		"/play",
-		h.PlayGame,
-		func(next echo.HandlerFunc) echo.HandlerFunc {
-			return func(c echo.Context) error {
-				token := c.QueryParam("token")
-				c.Request().Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-				return next(c)
-			}
-		},
-		jwtMiddleware,
-	)
+	games.GET("/play", h.PlayGame, h.websocketAuth(jwtMiddleware))
+	games.POST("/tickets", h.CreateTicket, jwtMiddleware)
+	games.GET("/positions", h.SearchPosition, jwtMiddleware)
+
+	lobbies := apiV1.Group("/lobbies", jwtMiddleware)
+	lobbies.POST("", h.CreateLobby)
+
+	challenges := apiV1.Group("/challenges", jwtMiddleware)
+	challenges.POST("", h.CreateChallenge)
+	challenges.GET("", h.ListChallenges)
+	challenges.POST("/:id/accept", h.AcceptChallenge)
+	challenges.POST("/:id/decline", h.DeclineChallenge)
+	challenges.DELETE("/:id", h.CancelChallenge)
+
+	correspondenceGames := apiV1.Group("/correspondence", jwtMiddleware)
+	correspondenceGames.GET("", h.ListCorrespondenceGames)
+	correspondenceGames.GET("/:id", h.GetCorrespondenceGame)
+	correspondenceGames.POST("/:id/moves", h.PlayCorrespondenceMove)
+
+	tournaments := apiV1.Group("/tournaments", jwtMiddleware)
+	tournaments.POST("", h.CreateTournament)
+	tournaments.GET("", h.ListTournaments)
+	tournaments.GET("/:id", h.GetTournament)
+	tournaments.GET("/:id/standings", h.GetTournamentStandings)
+	tournaments.POST("/:id/join", h.JoinTournament)
+	tournaments.POST("/:id/leave", h.LeaveTournament)
+	tournaments.POST("/:id/start", h.StartTournament)
+
+	simuls := apiV1.Group("/simuls", jwtMiddleware)
+	simuls.POST("", h.CreateSimul)
+	simuls.GET("", h.ListSimuls)
+	simuls.GET("/:id", h.GetSimul)
+	simuls.POST("/:id/join", h.JoinSimul)
+	simuls.POST("/:id/leave", h.LeaveSimul)
+	simuls.POST("/:id/start", h.StartSimul)
+
+	queues := apiV1.Group("/queues", jwtMiddleware)
+	queues.GET("", h.ListQueues)
+
+	puzzles := apiV1.Group("/puzzles", jwtMiddleware)
+	puzzles.GET("/next", h.GetNextPuzzle)
+	puzzles.GET("/daily", h.GetDailyPuzzle)
+	puzzles.POST("/:id/solution", h.SubmitPuzzle)
 }
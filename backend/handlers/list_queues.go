@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type QueueResponse struct {
+	Name    string `json:"name"`
+	Variant string `json:"variant"`
+	Waiting int    `json:"waiting"`
+}
+
+func (h *Handler) ListQueues(c echo.Context) error {
+	queues := h.GameCache.Queues()
+	response := make([]QueueResponse, len(queues))
+	for i, q := range queues {
+		response[i] = QueueResponse{
+			Name:    q.Name,
+			Variant: q.Variant,
+			Waiting: q.Waiting,
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -25,6 +25,10 @@ type UserClaims struct {
 	jwt.RegisteredClaims
 }
 
+func userClaims(c echo.Context) *UserClaims {
+	return c.Get("user").(*jwt.Token).Claims.(*UserClaims)
+}
+
 func (h *Handler) LoginUser(c echo.Context) error {
 	var request LoginUserRequest
 	if err := c.Bind(&request); err != nil {
@@ -2,21 +2,31 @@ package handlers
 
 import (
 	"backend/cache"
-	"backend/game"
 	"backend/message"
 	"backend/websockets"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/coder/websocket"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (h *Handler) PlayGame(c echo.Context) error {
+	subprotocols := message.Subprotocols
+	if ticket := websocketTicket(c); ticket != "" {
+		// browsers fail a handshake that selects none of the subprotocols
+		// they offered, a client offering nothing but its ticket gets it back
+		// and speaks json.v1. The ticket was redeemed already.
+		subprotocols = append(slices.Clone(subprotocols), ticketProtocol+ticket)
+	}
 	ws, err := websocket.Accept(
 		c.Response(), c.Request(), &websocket.AcceptOptions{
-			Subprotocols:    message.Subprotocols,
+			Subprotocols:    subprotocols,
 			CompressionMode: websocket.CompressionDisabled,
 			OriginPatterns:  []string{"*"},
 		},
@@ -25,161 +35,343 @@ func (h *Handler) PlayGame(c echo.Context) error {
 		return err
 	}
 	defer ws.Close(websocket.StatusNormalClosure, "")
+	ws.SetReadLimit(h.Config.App.Websockets.MaxMessageSize)
+	protocol := message.Negotiate(ws.Subprotocol())
 
-	token := c.Get("user").(*jwt.Token)
-	claims := token.Claims.(*UserClaims)
+	claims, err := h.websocketClaims(c, ws, protocol.Codec)
+	if err != nil {
+		c.Logger().Infof("websocket authentication failed: %v", err)
+		return ws.Close(websocket.StatusPolicyViolation, "authentication failed")
+	}
 
 	c.Logger().Infof("%v waiting for game", claims.Username)
 
 	ctx := c.Request().Context()
 
-	client := h.GameCache.Join(claims.UserID, ws)
-	defer h.GameCache.Leave(claims.UserID)
+	resume, err := parseResume(c.QueryParam("resume"))
+	if err != nil {
+		return ws.Close(websocket.StatusPolicyViolation, err.Error())
+	}
+
+	var client *cache.Client
+	seated := false
+	if lobbyParam := c.QueryParam("lobby"); lobbyParam != "" {
+		lobbyId, err := uuid.Parse(lobbyParam)
+		if err != nil {
+			return ws.Close(websocket.StatusPolicyViolation, "invalid lobby id")
+		}
+		client, err = h.GameCache.JoinLobby(h.BaseCtx, lobbyId, claims.UserID, ws, resume)
+		if err != nil {
+			return ws.Close(websocket.StatusPolicyViolation, err.Error())
+		}
+		// the player waits in the lobby rather than for matchmaking, and is
+		// told about the game once it fills up
+		seated = true
+	} else {
+		client, seated = h.GameCache.Join(h.BaseCtx, claims.UserID, ws, resume)
+	}
+	defer h.GameCache.Leave(client)
 
 	readResults := make(chan websockets.ReadResult, 1)
-	go websockets.StartReader(c, ws, readResults)
+	go websockets.StartReader(c, ws, protocol.Codec, readResults)
 	writeResults := make(chan error, 1)
 	writeRequests := client.WriteRequests
-	go websockets.StartWriter(c, ws, writeResults, writeRequests)
+	go websockets.StartWriter(c, ws, protocol, writeResults, writeRequests)
+	wsCfg := h.Config.App.Websockets
+	rtts := make(chan time.Duration, 1)
+	go websockets.StartHeartbeat(c, ws, wsCfg.PingInterval, wsCfg.PongTimeout, rtts)
+	idle := time.NewTimer(wsCfg.IdleTimeout)
+	defer idle.Stop()
+	limiter := websockets.NewLimiter(wsCfg)
 
-	writeRequests <- websockets.WriteRequest{
-		MsgType: message.TypeWaitingForGame,
-		Payload: message.WaitingForGamePayload{},
-	}
-
-	var lobby *cache.Lobby
-findLobby:
-	for {
-		select {
-		case rr := <-readResults:
-			if rr.Err != nil {
-				return rr.Err
-			}
-			c.Logger().Infof("Read message %v", rr.Msg)
-		case wrErr := <-writeResults:
-			if wrErr != nil {
-				return wrErr
-			}
-			c.Logger().Info("Written message")
-		case lobby = <-client.Notify:
-			break findLobby
-		case <-ctx.Done():
-			return ctx.Err()
+	if !seated {
+		writeRequests <- websockets.WriteRequest{
+			MsgType: message.TypeWaitingForGame,
+			Payload: message.WaitingForGamePayload{},
 		}
 	}
 
-	playerInfo := h.GameCache.PlayerInfo(lobby.Id, claims.UserID)
-	lastPlayed := game.ColorNone
-	if len(lobby.Game.Moves) > 0 {
-		lp := lobby.Game.Moves[len(lobby.Game.Moves)-1]
-		lastPlayed = lp.Color
-	}
-	writeRequests <- websockets.WriteRequest{
-		MsgType: message.TypeFoundGame,
-		Payload: message.FoundGamePayload{
-			LobbyId:    lobby.Id.String(),
-			State:      *lobby.Game.State,
-			LastPlayed: lastPlayed,
-			Color:      playerInfo.Color,
-			Messages:   lobby.Messages,
-		},
-	}
+	statusTicker := time.NewTicker(h.Config.App.Matchmaking.StatusInterval)
+	defer statusTicker.Stop()
+	var queue string
+	var searchStarted time.Time
+	// arena is the arena the connection plays in, it is queued again after
+	// each of its games
+	var arena uuid.UUID
 
+	// games are the lobbies this connection follows, a player may play
+	// several games at once and search for more meanwhile
+	games := make(map[uuid.UUID]*cache.Lobby)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 
 		case rr := <-readResults:
+			idle.Reset(wsCfg.IdleTimeout)
 			if rr.Err != nil {
 				var msgErr message.Error
 				isMsgErr := errors.As(rr.Err, &msgErr)
 				if !isMsgErr {
 					return rr.Err
 				}
-				writeRequests <- websockets.WriteRequest{
-					MsgType: message.TypeError, Payload: message.ErrorPayload{
-						Code:           websocket.StatusUnsupportedData,
-						Err:            msgErr.Error(),
-						ProblematicMsg: rr.Msg,
-					},
-				}
+				writeRequests <- messageError(msgErr, rr.Msg)
 				break
 			}
+			// payloads stay out of the logs, they carry chat and credentials
+			c.Logger().Debugf("Read %v message %q", rr.Msg.Type, rr.Msg.Id)
+			switch limiter.Check(rr.Msg.Type, time.Now()) {
+			case websockets.Allowed:
+			case websockets.Limited:
+				writeRequests <- limitError(errors.New("rate limit exceeded"), rr.Msg)
+				continue
+			case websockets.Muted:
+				if rr.Msg.Type == message.TypeChat {
+					err := fmt.Errorf("chat muted until %s", limiter.MutedUntil().UTC().Format(time.RFC3339))
+					writeRequests <- limitError(err, rr.Msg)
+				} else {
+					writeRequests <- limitError(errors.New("rate limit exceeded, chat muted"), rr.Msg)
+				}
+				continue
+			case websockets.Disconnect:
+				c.Logger().Infof("%v exceeded rate limits, closing connection", claims.Username)
+				return ws.Close(websocket.StatusPolicyViolation, "rate limit exceeded")
+			}
+			reqCtx := message.WithRequestId(ctx, rr.Msg.Id)
+			reply := ack(rr.Msg)
 
 			switch rr.Msg.Type {
+			case message.TypeSearchGame:
+				var searchMsg message.SearchGamePayload
+				if err = json.Unmarshal(rr.Msg.Payload, &searchMsg); err != nil {
+					return err
+				}
+				queue, err = h.GameCache.Search(ctx, client, searchMsg.Queue)
+				if err != nil {
+					reply = messageError(err, rr.Msg)
+					break
+				}
+				searchStarted = time.Now()
+				client.Send(h.queueStatus(queue, searchStarted))
+
+			case message.TypeCancelSearch:
+				h.GameCache.CancelSearch(ctx, client)
+				queue = ""
+				arena = uuid.Nil
+
+			case message.TypeJoinArena:
+				var joinMsg message.JoinArenaPayload
+				if err = json.Unmarshal(rr.Msg.Payload, &joinMsg); err != nil {
+					return err
+				}
+				tournamentId, err := uuid.Parse(joinMsg.TournamentId)
+				if err != nil {
+					reply = messageError(errors.New("invalid tournament id"), rr.Msg)
+					break
+				}
+				if err = h.Tournaments.Enter(ctx, tournamentId, client); err != nil {
+					reply = messageError(err, rr.Msg)
+					break
+				}
+				arena, queue = tournamentId, tournamentId.String()
+				searchStarted = time.Now()
+				client.Send(h.queueStatus(queue, searchStarted))
+
+			case message.TypeBerserk:
+				lobby, err := lobbyOf(games, rr.Msg)
+				if err != nil {
+					reply = messageError(err, rr.Msg)
+					break
+				}
+				if err = h.GameCache.Berserk(reqCtx, lobby.Id, claims.UserID); err != nil {
+					reply = messageError(err, rr.Msg)
+				} else if !h.GameCache.Owns(lobby) {
+					// acknowledged by the node owning the lobby
+					reply = websockets.WriteRequest{}
+				}
+
+			case message.TypeAcceptBot:
+				h.GameCache.AcceptBot(ctx, client)
+
 			case message.TypeChat:
+				lobby, err := lobbyOf(games, rr.Msg)
+				if err != nil {
+					reply = messageError(err, rr.Msg)
+					break
+				}
 				var chatMsg message.ChatMessagePayload
 				err = json.Unmarshal(rr.Msg.Payload, &chatMsg)
 				if err != nil {
 					return err
 				}
-				h.GameCache.Send(
-					lobby.Id, websockets.WriteRequest{
-						MsgType: rr.Msg.Type,
-						Payload: rr.Msg.Payload,
-					},
-				)
+				h.GameCache.Chat(ctx, lobby.Id, claims.UserID, chatMsg)
 
 			case message.TypePlayMove:
+				lobby, err := lobbyOf(games, rr.Msg)
+				if err != nil {
+					reply = messageError(err, rr.Msg)
+					break
+				}
 				var moveMsg message.PlayMovePayload
 				err = json.Unmarshal(rr.Msg.Payload, &moveMsg)
 				if err != nil {
 					return err
 				}
-				row, isWinningMove, err := h.GameCache.Play(lobby.Id, claims.UserID, moveMsg.Column)
+				err = h.GameCache.Move(reqCtx, lobby.Id, claims.UserID, moveMsg.Column)
 				if err != nil {
-					writeRequests <- websockets.WriteRequest{
-						MsgType: message.TypeError, Payload: message.ErrorPayload{
-							Code:           websocket.StatusUnsupportedData,
-							Err:            err.Error(),
-							ProblematicMsg: rr.Msg,
-						},
-					}
-					break
+					reply = messageError(err, rr.Msg)
+				} else if !h.GameCache.Owns(lobby) {
+					reply = websockets.WriteRequest{}
 				}
 
-				h.GameCache.Send(
-					lobby.Id,
-					websockets.WriteRequest{
-						MsgType: message.TypePlayedMove,
-						Payload: message.PlayedMovePayload{
-							Color:  playerInfo.Color,
-							Row:    uint8(row),
-							Column: moveMsg.Column,
-						},
-					},
-				)
-
-				if isWinningMove {
-					h.GameCache.Send(
-						lobby.Id,
-						websockets.WriteRequest{
-							MsgType: message.TypeGameOver,
-							Payload: message.GameOverPayload{Winner: playerInfo.Color},
-						},
-					)
+			case message.TypePlayCorrespondenceMove:
+				played, err := h.correspondenceMove(ctx, claims.UserID, rr.Msg)
+				if err != nil {
+					reply = messageError(err, rr.Msg)
+					break
 				}
+				writeRequests <- websockets.WriteRequest{MsgType: message.TypeCorrespondenceMove, Payload: played}
 
 			default:
 				errStr := fmt.Sprintf("Unknown message type '%s'", rr.Msg.Type)
 				c.Logger().Info(errStr)
-				writeRequests <- websockets.WriteRequest{
-					MsgType: message.TypeError, Payload: message.ErrorPayload{
-						Code:           websocket.StatusUnsupportedData,
-						Err:            errStr,
-						ProblematicMsg: rr.Msg,
-					},
-				}
+				reply = messageError(errors.New(errStr), rr.Msg)
+			}
+			if reply.MsgType != "" {
+				writeRequests <- reply
+			}
+
+		case rtt := <-rtts:
+			h.GameCache.Latency(ctx, client, rtt)
+
+		case <-idle.C:
+			if len(games) == 0 && queue == "" && arena == uuid.Nil {
+				c.Logger().Infof("%v idle, closing connection", claims.Username)
+				return ws.Close(websocket.StatusPolicyViolation, "idle timeout")
+			}
+			idle.Reset(wsCfg.IdleTimeout)
+
+		case <-statusTicker.C:
+			if queue != "" {
+				client.Send(h.queueStatus(queue, searchStarted))
 			}
 
 		case wrErr := <-writeResults:
-			if wrErr != nil {
-				return wrErr
+			return wrErr
+
+		case seat := <-client.Notify:
+			// a game started from the search ends it, while the seats of
+			// games the player was already in leave it going. The game's
+			// messages follow on the client's outbox.
+			if !h.GameCache.Searching(client) {
+				queue = ""
+			}
+			games[seat.Lobby.Id] = seat.Lobby
+
+		case lobbyId := <-client.Released:
+			lobby := games[lobbyId]
+			delete(games, lobbyId)
+			if lobby != nil && arena != uuid.Nil && lobby.Arena == arena {
+				if err = h.Tournaments.Enter(ctx, arena, client); err != nil {
+					// the arena is over
+					arena = uuid.Nil
+				} else {
+					queue = arena.String()
+					searchStarted = time.Now()
+				}
+			}
+			if len(games) == 0 && queue == "" && arena == uuid.Nil {
+				return nil
 			}
+		}
+	}
+}
 
-		case <-client.Unregister:
-			return nil
+// parseResume reads the games a reconnecting client resumes, given as
+// comma-separated lobbyId:seq pairs with the sequence number of the last
+// message it got from each game.
+func parseResume(param string) (cache.Resume, error) {
+	if param == "" {
+		return nil, nil
+	}
+	resume := make(cache.Resume)
+	for _, pair := range strings.Split(param, ",") {
+		lobbyParam, seqParam, found := strings.Cut(pair, ":")
+		if !found {
+			return nil, errors.New("invalid resume")
 		}
+		lobbyId, err := uuid.Parse(lobbyParam)
+		if err != nil {
+			return nil, errors.New("invalid lobby id")
+		}
+		seq, err := strconv.ParseUint(seqParam, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid sequence number")
+		}
+		resume[lobbyId] = seq
+	}
+	return resume, nil
+}
+
+// lobbyOf picks the game a message is about: the one named by its lobby id,
+// or the only game of the connection if it names none.
+func lobbyOf(games map[uuid.UUID]*cache.Lobby, msg message.Message) (*cache.Lobby, error) {
+	if msg.LobbyId == "" {
+		if len(games) != 1 {
+			return nil, errors.New("message 'lobbyId' missing")
+		}
+		for _, lobby := range games {
+			return lobby, nil
+		}
+	}
+	lobbyId, err := uuid.Parse(msg.LobbyId)
+	if err != nil {
+		return nil, errors.New("invalid lobby id")
+	}
+	lobby, playing := games[lobbyId]
+	if !playing {
+		return nil, cache.ErrNotPlayer
+	}
+	return lobby, nil
+}
+
+// ack acknowledges a request of a json.v2 client, messages without a request
+// id get no reply.
+func ack(msg message.Message) websockets.WriteRequest {
+	if msg.Id == "" {
+		return websockets.WriteRequest{}
+	}
+	return websockets.WriteRequest{MsgType: message.TypeAck, Payload: message.AckPayload{}, RequestId: msg.Id}
+}
+
+func messageError(err error, msg message.Message) websockets.WriteRequest {
+	return websockets.WriteRequest{
+		MsgType: message.TypeError, Payload: message.ErrorPayload{
+			Code:           websocket.StatusUnsupportedData,
+			Err:            err.Error(),
+			ProblematicMsg: msg,
+		},
+	}
+}
+
+// limitError rejects a message the client sent too fast.
+func limitError(err error, msg message.Message) websockets.WriteRequest {
+	wr := messageError(err, msg)
+	payload := wr.Payload.(message.ErrorPayload)
+	payload.Code = websocket.StatusPolicyViolation
+	wr.Payload = payload
+	return wr
+}
+
+func (h *Handler) queueStatus(queue string, searchStarted time.Time) websockets.WriteRequest {
+	stats := h.GameCache.QueueStats(queue)
+	return websockets.WriteRequest{
+		MsgType: message.TypeQueueStatus,
+		Payload: message.QueueStatusPayload{
+			Queue:         queue,
+			Waiting:       stats.Waiting,
+			Elapsed:       int(time.Since(searchStarted).Seconds()),
+			EstimatedWait: int(stats.AverageWait.Seconds()),
+		},
 	}
 }
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"backend/cache"
+	"backend/config"
+	"backend/internal/testdb"
+	"backend/message"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/coder/websocket"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// playServer serves PlayGame to an authenticated player, closing connections
+// idle for idleTimeout.
+func playServer(t *testing.T, idleTimeout time.Duration) string {
+	t.Helper()
+	_, url := newPlayServer(t, idleTimeout)
+	return url
+}
+
+// newPlayServer is playServer, along with the cache the games are played in.
+func newPlayServer(t *testing.T, idleTimeout time.Duration) (*cache.Cache, string) {
+	t.Helper()
+	cfg := *config.DefaultConfig
+	cfg.App.Websockets.IdleTimeout = idleTimeout
+	cfg.App.Matchmaking.BotFallback = string(cache.BotFallbackOff)
+	queues, err := cache.NewQueues(cfg.App.Matchmaking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testdb.New()
+	gc := cache.NewDefaultCache(db, nil, cfg.App.Matchmaking, cfg.App.Websockets, queues, nil, echo.New().Logger)
+	t.Cleanup(gc.Close)
+	h := &Handler{DB: db, Config: cfg, GameCache: gc, BaseCtx: context.Background()}
+
+	e := echo.New()
+	e.GET(
+		"/play", h.PlayGame, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				claims := &UserClaims{UserID: uuid.New(), Username: "alice"}
+				c.Set("user", &jwt.Token{Claims: claims, Valid: true})
+				return next(c)
+			}
+		},
+	)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return gc, "ws" + strings.TrimPrefix(server.URL, "http") + "/play"
+}
+
+func dialPlay(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ws, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{Subprotocols: []string{"json.v2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.CloseNow() })
+	return ws
+}
+
+// readUntilClosed reads messages until the server closes the connection or
+// the wait is over, and returns the close error.
+func readUntilClosed(t *testing.T, ws *websocket.Conn, wait time.Duration) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	for {
+		if _, _, err := ws.Read(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func TestPlayGameClosesIdleConnections(t *testing.T) {
+	ws := dialPlay(t, playServer(t, 50*time.Millisecond))
+
+	err := readUntilClosed(t, ws, time.Second)
+	var closeErr websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.StatusPolicyViolation || closeErr.Reason != "idle timeout" {
+		t.Errorf("read error %v, want a close for the idle timeout", err)
+	}
+}
+
+func TestPlayGameKeepsSearchingConnections(t *testing.T) {
+	ws := dialPlay(t, playServer(t, 50*time.Millisecond))
+	search, err := message.NewV2(message.TypeSearchGame, message.SearchGamePayload{Queue: "standard"}, "1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ws.Write(context.Background(), websocket.MessageText, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody else searches, the search goes on for several idle timeouts
+	err = readUntilClosed(t, ws, 300*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("read error %v, want the connection kept open", err)
+	}
+}
+
+func TestPlayGameActivityResetsIdleTimeout(t *testing.T) {
+	ws := dialPlay(t, playServer(t, 100*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			if _, _, err := ws.Read(ctx); err != nil {
+				return
+			}
+		}
+	}()
+
+	// a message every half timeout keeps the connection open
+	unknown, err := message.NewV2("ping", struct{}{}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 6 {
+		if err = ws.Write(ctx, websocket.MessageText, data); err != nil {
+			t.Fatalf("connection closed while active: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPlayGameJoiningLobbyDoesNotWaitForMatchmaking(t *testing.T) {
+	gc, url := newPlayServer(t, time.Minute)
+	lobby, err := gc.CreateLobby(cache.LobbyOptions{Private: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := dialPlay(t, url+"?lobby="+lobby.Id.String())
+
+	// the lobby waits for its second player, the connection is told nothing
+	// until then, and in particular not to search for a game
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, data, err := ws.Read(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("read %s, %v, want nothing", data, err)
+	}
+}
+
+func TestPlayGameTicketSubprotocol(t *testing.T) {
+	url := playServer(t, time.Minute)
+	tests := []struct {
+		name        string
+		offered     []string
+		subprotocol string
+		version     string
+	}{
+		{"ticket alone", []string{"ticket.abc"}, "ticket.abc", message.V1},
+		{"ticket and json.v2", []string{"ticket.abc", "json.v2"}, "json.v2", message.V2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			ws, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{Subprotocols: tt.offered})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.CloseNow()
+			if ws.Subprotocol() != tt.subprotocol {
+				t.Errorf("negotiated %q, want %q", ws.Subprotocol(), tt.subprotocol)
+			}
+
+			_, data, err := ws.Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msg message.Message
+			if err = json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != message.TypeWaitingForGame || msg.Version != tt.version {
+				t.Errorf("got %s, want %s in %s", data, message.TypeWaitingForGame, tt.version)
+			}
+		})
+	}
+}
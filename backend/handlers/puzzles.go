@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/rating"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type PuzzleResponse struct {
+	Id     string     `json:"id"`
+	State  game.Board `json:"state"`
+	ToMove game.Color `json:"toMove"`
+	WinIn  int32      `json:"winIn"`
+	Rating int32      `json:"rating"`
+}
+
+type SubmitPuzzleRequest struct {
+	// columns off the board are judged by game.CheckLine, as illegal moves
+	Moves []uint8 `json:"moves" validate:"required,min=1"`
+}
+
+const (
+	PuzzleStatusContinue = "continue"
+	PuzzleStatusSolved   = "solved"
+	PuzzleStatusFailed   = "failed"
+)
+
+type SubmitPuzzleResponse struct {
+	Status      string  `json:"status"`
+	Reply       *uint8  `json:"reply,omitempty"`
+	Rating      int32   `json:"rating,omitempty"`
+	RatingDelta int32   `json:"ratingDelta,omitempty"`
+	Solution    []uint8 `json:"solution,omitempty"`
+}
+
+func newPuzzleResponse(p sqlc.Puzzle) (PuzzleResponse, error) {
+	board, err := game.ParseBoard(p.State)
+	if err != nil {
+		return PuzzleResponse{}, err
+	}
+	return PuzzleResponse{
+		Id:     p.ID.String(),
+		State:  *board,
+		ToMove: game.Color(p.ToMove),
+		WinIn:  p.WinIn,
+		Rating: p.Rating,
+	}, nil
+}
+
+func (h *Handler) GetNextPuzzle(c echo.Context) error {
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+
+	user, err := h.DB.GetUserById(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	p, err := h.DB.GetNextPuzzle(
+		ctx, sqlc.GetNextPuzzleParams{
+			UserID: claims.UserID,
+			Rating: user.PuzzleRating,
+		},
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "no puzzles left")
+	}
+	if err != nil {
+		return err
+	}
+
+	response, err := newPuzzleResponse(p)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) GetDailyPuzzle(c echo.Context) error {
+	ctx := c.Request().Context()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	p, err := h.DB.GetDailyPuzzle(ctx, today)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if err = h.DB.CreateDailyPuzzle(ctx, today); err != nil {
+			return err
+		}
+		p, err = h.DB.GetDailyPuzzle(ctx, today)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "no daily puzzle available")
+	}
+	if err != nil {
+		return err
+	}
+
+	response, err := newPuzzleResponse(p)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) SubmitPuzzle(c echo.Context) error {
+	var request SubmitPuzzleRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+	puzzleId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid puzzle id")
+	}
+
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+
+	p, err := h.DB.GetPuzzleById(ctx, puzzleId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "puzzle not found")
+	}
+	if err != nil {
+		return err
+	}
+	board, err := game.ParseBoard(p.State)
+	if err != nil {
+		return err
+	}
+	color := game.Color(p.ToMove)
+	winIn := int(p.WinIn)
+
+	var response SubmitPuzzleResponse
+	switch game.CheckLine(*board, color, winIn, request.Moves) {
+	case game.LineIllegal:
+		return echo.NewHTTPError(http.StatusBadRequest, "line contains an illegal move")
+	case game.LineCorrect:
+		response.Status = PuzzleStatusContinue
+		if len(request.Moves)%2 == 1 {
+			after, _ := game.ApplyLine(*board, color, request.Moves)
+			remaining := winIn - len(request.Moves)/2 - 1
+			if reply, ok := game.BestReply(after, color, remaining); ok {
+				response.Reply = &reply
+			}
+		}
+		return c.JSON(http.StatusOK, response)
+	case game.LineSolved:
+		response.Status = PuzzleStatusSolved
+	case game.LineWrong:
+		response.Status = PuzzleStatusFailed
+	}
+
+	solution, err := game.ParseMoves(p.Solution)
+	if err != nil {
+		return err
+	}
+	response.Solution = solution
+
+	solved := response.Status == PuzzleStatusSolved
+	attempted, err := h.DB.CreatePuzzleAttempt(
+		ctx, sqlc.CreatePuzzleAttemptParams{
+			UserID:         claims.UserID,
+			PuzzleID:       p.ID,
+			Solved:         solved,
+			AttemptedAtUtc: time.Now().UTC(),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	user, err := h.DB.GetUserById(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	response.Rating = user.PuzzleRating
+	if attempted == 0 {
+		// only the first attempt at a puzzle is rated
+		return c.JSON(http.StatusOK, response)
+	}
+
+	score := 0.0
+	if solved {
+		score = 1
+	}
+	userRating, puzzleRating := rating.Elo(user.PuzzleRating, p.Rating, score, rating.DefaultK)
+	err = h.DB.UpdateUserPuzzleRating(
+		ctx, sqlc.UpdateUserPuzzleRatingParams{
+			ID:           user.ID,
+			PuzzleRating: userRating,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	err = h.DB.UpdatePuzzleRating(
+		ctx, sqlc.UpdatePuzzleRatingParams{
+			ID:     p.ID,
+			Rating: puzzleRating,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	response.Rating = userRating
+	response.RatingDelta = userRating - user.PuzzleRating
+
+	return c.JSON(http.StatusOK, response)
+}
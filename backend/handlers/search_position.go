@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type SearchPositionRequest struct {
+	Hash  string `query:"hash"`
+	State string `query:"state"`
+	Limit int32  `query:"limit" validate:"omitempty,min=1,max=100"`
+	// ToMove is the side to move in State, by default the one that would be
+	// if red started
+	ToMove game.Color `query:"toMove" validate:"omitempty,min=1,max=2"`
+}
+
+type SearchPositionResponse struct {
+	Hash  string                       `json:"hash"`
+	Games []sqlc.GetGamesByPositionRow `json:"games"`
+}
+
+func (h *Handler) SearchPosition(c echo.Context) error {
+	var request SearchPositionRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+	if request.Limit == 0 {
+		request.Limit = 20
+	}
+
+	var hash game.Hash
+	switch {
+	case request.State != "":
+		board, err := game.ParseBoard(request.State)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if request.ToMove == game.ColorNone {
+			request.ToMove = board.ToMove()
+		}
+		hash = game.HashBoard(board, request.ToMove)
+	case request.Hash != "":
+		var err error
+		hash, err = game.ParseHash(request.Hash)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "either 'hash' or 'state' is required")
+	}
+
+	games, err := h.DB.GetGamesByPosition(
+		c.Request().Context(), sqlc.GetGamesByPositionParams{
+			Hash:  int64(hash),
+			Limit: request.Limit,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, SearchPositionResponse{Hash: hash.String(), Games: games})
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/simul"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const simulListLimit = 50
+
+type CreateSimulRequest struct {
+	Name    string `json:"name" validate:"required,max=100"`
+	Variant string `json:"variant,omitempty"`
+	Boards  int    `json:"boards" validate:"required,min=1"`
+	// Color is the color the host plays on every board, ColorNone alternates
+	// between the boards.
+	Color game.Color `json:"color,omitempty"`
+}
+
+type SimulResponse struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Host      string     `json:"host"`
+	Variant   string     `json:"variant"`
+	Boards    int        `json:"boards"`
+	Color     game.Color `json:"color"`
+	Status    string     `json:"status"`
+	Players   int        `json:"players"`
+	CreatedAt time.Time  `json:"createdAt"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+}
+
+type SimulDetailsResponse struct {
+	SimulResponse
+	Participants []SimulBoardResponse `json:"participants"`
+	YouJoined    bool                 `json:"youJoined"`
+	YouHost      bool                 `json:"youHost"`
+}
+
+type SimulBoardResponse struct {
+	Username string `json:"username"`
+	Rating   int32  `json:"rating"`
+	// LobbyId and HostColor are set once the simul started.
+	LobbyId   string     `json:"lobbyId,omitempty"`
+	HostColor game.Color `json:"hostColor,omitempty"`
+	Over      bool       `json:"over"`
+	Winner    game.Color `json:"winner"`
+}
+
+func newSimulResponse(s sqlc.Simul, host string, players int, status string) SimulResponse {
+	return SimulResponse{
+		Id:        s.ID.String(),
+		Name:      s.Name,
+		Host:      host,
+		Variant:   s.Variant,
+		Boards:    int(s.Boards),
+		Color:     game.Color(s.HostColor),
+		Status:    status,
+		Players:   players,
+		CreatedAt: s.CreatedAtUtc,
+		StartedAt: s.StartedAtUtc,
+	}
+}
+
+// CreateSimul opens a simul for participants. The player creating it hosts it
+// and plays every board once they start it.
+func (h *Handler) CreateSimul(c echo.Context) error {
+	var request CreateSimulRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+
+	variant, err := game.ParseVariant(request.Variant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if variant.Players > 2 {
+		return echo.NewHTTPError(http.StatusBadRequest, "simuls are only supported in two-player variants")
+	}
+	if request.Boards > h.Config.App.Simuls.MaxBoards {
+		return echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("at most %d boards are allowed", h.Config.App.Simuls.MaxBoards),
+		)
+	}
+	switch request.Color {
+	case game.ColorNone, game.ColorRed, game.ColorYellow:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "color must be red, yellow or none")
+	}
+
+	claims := userClaims(c)
+	simulId, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	s := sqlc.Simul{
+		ID:           simulId,
+		Name:         request.Name,
+		HostID:       claims.UserID,
+		Variant:      variant.Name,
+		Boards:       int32(request.Boards),
+		HostColor:    int16(request.Color),
+		CreatedAtUtc: time.Now().UTC(),
+	}
+	err = h.DB.CreateSimul(
+		c.Request().Context(), sqlc.CreateSimulParams{
+			ID:           s.ID,
+			Name:         s.Name,
+			HostID:       s.HostID,
+			Variant:      s.Variant,
+			Boards:       s.Boards,
+			HostColor:    s.HostColor,
+			CreatedAtUtc: s.CreatedAtUtc,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	c.Logger().Infof("%v created simul %v", claims.Username, s.ID)
+	return c.JSON(http.StatusCreated, newSimulResponse(s, claims.Username, 0, simul.StatusPending))
+}
+
+// ListSimuls returns the most recently created simuls.
+func (h *Handler) ListSimuls(c echo.Context) error {
+	rows, err := h.DB.GetSimuls(c.Request().Context(), simulListLimit)
+	if err != nil {
+		return err
+	}
+
+	response := make([]SimulResponse, len(rows))
+	for i, row := range rows {
+		s := sqlc.Simul{
+			ID:           row.ID,
+			Name:         row.Name,
+			HostID:       row.HostID,
+			Variant:      row.Variant,
+			Boards:       row.Boards,
+			HostColor:    row.HostColor,
+			CreatedAtUtc: row.CreatedAtUtc,
+			StartedAtUtc: row.StartedAtUtc,
+		}
+		status := simul.StatusPending
+		switch {
+		case row.StartedAtUtc == nil:
+		case row.Playing:
+			status = simul.StatusRunning
+		default:
+			status = simul.StatusFinished
+		}
+		response[i] = newSimulResponse(s, row.Host, int(row.Players), status)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetSimul returns a simul with its participants and, once it started, the
+// lobby of every board.
+func (h *Handler) GetSimul(c echo.Context) error {
+	s, err := h.simul(c)
+	if err != nil {
+		return err
+	}
+	claims := userClaims(c)
+	host, err := h.DB.GetUserById(c.Request().Context(), s.HostID)
+	if err != nil {
+		return err
+	}
+
+	response := SimulDetailsResponse{
+		SimulResponse: newSimulResponse(s.Simul, host.Username, len(s.Participants), s.Status()),
+		Participants:  make([]SimulBoardResponse, len(s.Participants)),
+		YouHost:       s.HostID == claims.UserID,
+	}
+	for i, b := range s.Participants {
+		response.Participants[i] = SimulBoardResponse{
+			Username:  b.Username,
+			Rating:    b.Rating,
+			HostColor: b.HostColor,
+			Over:      b.Over,
+			Winner:    b.Winner,
+		}
+		if b.Started {
+			response.Participants[i].LobbyId = b.LobbyId.String()
+		}
+		response.YouJoined = response.YouJoined || b.PlayerId == claims.UserID
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// JoinSimul takes a free board of a simul that has not started yet.
+func (h *Handler) JoinSimul(c echo.Context) error {
+	s, err := h.simul(c)
+	if err != nil {
+		return err
+	}
+	claims := userClaims(c)
+	if s.HostID == claims.UserID {
+		return echo.NewHTTPError(http.StatusBadRequest, "the host cannot join their own simul")
+	}
+	if s.StartedAtUtc != nil {
+		return simulError(simul.ErrStarted)
+	}
+	joined := slices.ContainsFunc(s.Participants, func(b simul.Board) bool { return b.PlayerId == claims.UserID })
+	if joined {
+		return c.NoContent(http.StatusNoContent)
+	}
+	if len(s.Participants) >= int(s.Simul.Boards) {
+		return echo.NewHTTPError(http.StatusConflict, "simul is full")
+	}
+
+	added, err := h.DB.JoinSimul(
+		c.Request().Context(), sqlc.JoinSimulParams{
+			PlayerID:    claims.UserID,
+			JoinedAtUtc: time.Now().UTC(),
+			SimulID:     s.ID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "simul is full or has started already")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LeaveSimul gives up the player's board in a simul that has not started
+// yet.
+func (h *Handler) LeaveSimul(c echo.Context) error {
+	simulId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid simul id")
+	}
+	claims := userClaims(c)
+
+	left, err := h.DB.LeaveSimul(
+		c.Request().Context(), sqlc.LeaveSimulParams{
+			SimulID:  simulId,
+			PlayerID: claims.UserID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if left == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "not joined or simul has started already")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// StartSimul starts the games of a simul the player hosts. The host gets
+// every board on their connected sessions, the participants theirs.
+func (h *Handler) StartSimul(c echo.Context) error {
+	simulId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid simul id")
+	}
+	claims := userClaims(c)
+
+	if err = h.Simuls.Start(c.Request().Context(), simulId, claims.UserID); err != nil {
+		return simulError(err)
+	}
+
+	c.Logger().Infof("%v started simul %v", claims.Username, simulId)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) simul(c echo.Context) (*simul.Simul, error) {
+	simulId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid simul id")
+	}
+	s, err := h.Simuls.Load(c.Request().Context(), simulId)
+	if err != nil {
+		return nil, simulError(err)
+	}
+	return s, nil
+}
+
+func simulError(err error) error {
+	switch {
+	case errors.Is(err, simul.ErrSimulNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, simul.ErrNotHost):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, simul.ErrStarted),
+		errors.Is(err, simul.ErrNoParticipants):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/tournament"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const tournamentListLimit = 50
+
+type CreateTournamentRequest struct {
+	Name    string `json:"name" validate:"required,max=100"`
+	Format  string `json:"format" validate:"required"`
+	Variant string `json:"variant,omitempty"`
+	// Rounds only applies to Swiss tournaments, zero plays as many rounds as
+	// it takes to find a winner.
+	Rounds int `json:"rounds,omitempty" validate:"min=0"`
+	// Minutes is how long an arena runs, it is required for arenas only.
+	Minutes int `json:"minutes,omitempty" validate:"min=0"`
+}
+
+type TournamentResponse struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Format    string     `json:"format"`
+	Variant   string     `json:"variant"`
+	Rounds    int        `json:"rounds,omitempty"`
+	Round     int        `json:"round"`
+	Minutes   int        `json:"minutes,omitempty"`
+	Status    string     `json:"status"`
+	Players   int        `json:"players"`
+	CreatedAt time.Time  `json:"createdAt"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+	// EndsAt is when a running arena stops pairing players.
+	EndsAt *time.Time `json:"endsAt,omitempty"`
+}
+
+type TournamentDetailsResponse struct {
+	TournamentResponse
+	Organizer   string                     `json:"organizer"`
+	Entrants    []TournamentPlayerResponse `json:"entrants"`
+	Games       []TournamentGameResponse   `json:"games"`
+	YouEntered  bool                       `json:"youEntered"`
+	YouOrganize bool                       `json:"youOrganize"`
+}
+
+type TournamentPlayerResponse struct {
+	Username string `json:"username"`
+	Rating   int32  `json:"rating"`
+}
+
+type TournamentGameResponse struct {
+	Round int    `json:"round"`
+	Red   string `json:"red"`
+	// Yellow is empty for a bye.
+	Yellow  string     `json:"yellow,omitempty"`
+	LobbyId string     `json:"lobbyId,omitempty"`
+	Over    bool       `json:"over"`
+	Winner  game.Color `json:"winner"`
+}
+
+type StandingResponse struct {
+	Rank            int     `json:"rank"`
+	Username        string  `json:"username"`
+	Score           float64 `json:"score"`
+	Played          int     `json:"played"`
+	Wins            int     `json:"wins"`
+	Draws           int     `json:"draws"`
+	Losses          int     `json:"losses"`
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+	Streak          int     `json:"streak,omitempty"`
+	OnFire          bool    `json:"onFire,omitempty"`
+}
+
+func newTournamentResponse(t sqlc.Tournament, players int) TournamentResponse {
+	response := TournamentResponse{
+		Id:        t.ID.String(),
+		Name:      t.Name,
+		Format:    t.Format,
+		Variant:   t.Variant,
+		Rounds:    int(t.Rounds),
+		Round:     int(t.Round),
+		Minutes:   int(t.Minutes),
+		Status:    t.Status,
+		Players:   players,
+		CreatedAt: t.CreatedAtUtc,
+		StartedAt: t.StartedAtUtc,
+		EndedAt:   t.EndedAtUtc,
+	}
+	if endsAt := tournament.EndsAt(t); !endsAt.IsZero() {
+		response.EndsAt = &endsAt
+	}
+	return response
+}
+
+// CreateTournament opens a tournament for registration. The player creating
+// it organizes it and decides when it starts.
+func (h *Handler) CreateTournament(c echo.Context) error {
+	var request CreateTournamentRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+
+	format, err := tournament.ParseFormat(request.Format)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	variant, err := game.ParseVariant(request.Variant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if variant.Players > 2 {
+		return echo.NewHTTPError(http.StatusBadRequest, "tournaments are only supported in two-player variants")
+	}
+	if format != tournament.FormatSwiss {
+		request.Rounds = 0
+	}
+	if format != tournament.FormatArena {
+		request.Minutes = 0
+	} else if request.Minutes < 1 || request.Minutes > h.Config.App.Tournaments.MaxArenaMinutes {
+		return echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("an arena runs for 1 to %d minutes", h.Config.App.Tournaments.MaxArenaMinutes),
+		)
+	}
+	if request.Rounds > h.Config.App.Tournaments.MaxRounds {
+		return echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("at most %d rounds are allowed", h.Config.App.Tournaments.MaxRounds),
+		)
+	}
+
+	claims := userClaims(c)
+	tournamentId, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	t := sqlc.Tournament{
+		ID:           tournamentId,
+		Name:         request.Name,
+		Format:       string(format),
+		Variant:      variant.Name,
+		Rounds:       int32(request.Rounds),
+		Minutes:      int32(request.Minutes),
+		Status:       tournament.StatusPending,
+		CreatedBy:    claims.UserID,
+		CreatedAtUtc: time.Now().UTC(),
+	}
+	err = h.DB.CreateTournament(
+		c.Request().Context(), sqlc.CreateTournamentParams{
+			ID:           t.ID,
+			Name:         t.Name,
+			Format:       t.Format,
+			Variant:      t.Variant,
+			Rounds:       t.Rounds,
+			Minutes:      t.Minutes,
+			CreatedBy:    t.CreatedBy,
+			CreatedAtUtc: t.CreatedAtUtc,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	c.Logger().Infof("%v created tournament %v", claims.Username, t.ID)
+	return c.JSON(http.StatusCreated, newTournamentResponse(t, 0))
+}
+
+// ListTournaments returns the most recently created tournaments.
+func (h *Handler) ListTournaments(c echo.Context) error {
+	rows, err := h.DB.GetTournaments(c.Request().Context(), tournamentListLimit)
+	if err != nil {
+		return err
+	}
+
+	response := make([]TournamentResponse, len(rows))
+	for i, row := range rows {
+		t := sqlc.Tournament{
+			ID:           row.ID,
+			Name:         row.Name,
+			Format:       row.Format,
+			Variant:      row.Variant,
+			Rounds:       row.Rounds,
+			Round:        row.Round,
+			Minutes:      row.Minutes,
+			Status:       row.Status,
+			CreatedBy:    row.CreatedBy,
+			CreatedAtUtc: row.CreatedAtUtc,
+			StartedAtUtc: row.StartedAtUtc,
+			EndedAtUtc:   row.EndedAtUtc,
+		}
+		response[i] = newTournamentResponse(t, int(row.Players))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetTournament returns a tournament with its entrants, in seed order, and
+// the games of every round so far.
+func (h *Handler) GetTournament(c echo.Context) error {
+	t, err := h.tournament(c)
+	if err != nil {
+		return err
+	}
+	claims := userClaims(c)
+	organizer, err := h.DB.GetUserById(c.Request().Context(), t.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	response := TournamentDetailsResponse{
+		TournamentResponse: newTournamentResponse(t.Tournament, len(t.Players)),
+		Organizer:          organizer.Username,
+		Entrants:           make([]TournamentPlayerResponse, len(t.Players)),
+		Games:              make([]TournamentGameResponse, len(t.Games)),
+		YouOrganize:        t.CreatedBy == claims.UserID,
+	}
+	for i, p := range t.Players {
+		response.Entrants[i] = TournamentPlayerResponse{Username: t.Usernames[p.Id], Rating: p.Rating}
+		response.YouEntered = response.YouEntered || p.Id == claims.UserID
+	}
+	for i, g := range t.Games {
+		response.Games[i] = TournamentGameResponse{
+			Round:  g.Round,
+			Red:    t.Usernames[g.Red],
+			Yellow: t.Usernames[g.Yellow],
+			Over:   g.Over || g.Bye(),
+			Winner: g.Winner,
+		}
+		if g.Started {
+			response.Games[i].LobbyId = g.LobbyId.String()
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetTournamentStandings ranks the entrants of a tournament by their score so
+// far.
+func (h *Handler) GetTournamentStandings(c echo.Context) error {
+	t, err := h.tournament(c)
+	if err != nil {
+		return err
+	}
+
+	standings := t.Standings()
+	response := make([]StandingResponse, len(standings))
+	for i, s := range standings {
+		response[i] = StandingResponse{
+			Rank:            s.Rank,
+			Username:        t.Usernames[s.PlayerId],
+			Score:           s.Score,
+			Played:          s.Played,
+			Wins:            s.Wins,
+			Draws:           s.Draws,
+			Losses:          s.Losses,
+			Buchholz:        s.Buchholz,
+			SonnebornBerger: s.SonnebornBerger,
+			Streak:          s.Streak,
+			OnFire:          s.OnFire,
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// JoinTournament enters the player into a tournament that has not started
+// yet, or into a running arena, seeded by their current rating.
+func (h *Handler) JoinTournament(c echo.Context) error {
+	t, err := h.tournament(c)
+	if err != nil {
+		return err
+	}
+	arena := tournament.Format(t.Format) == tournament.FormatArena
+	if t.Status != tournament.StatusPending && !(arena && t.Status == tournament.StatusRunning) {
+		return tournamentError(tournament.ErrStarted)
+	}
+	if len(t.Players) >= h.Config.App.Tournaments.MaxPlayers {
+		return echo.NewHTTPError(http.StatusConflict, "tournament is full")
+	}
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+
+	user, err := h.DB.GetUserById(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	if user.IsBot {
+		return echo.NewHTTPError(http.StatusBadRequest, "bots cannot enter tournaments")
+	}
+	joined, err := h.DB.JoinTournament(
+		ctx, sqlc.JoinTournamentParams{
+			PlayerID:     claims.UserID,
+			Rating:       user.Rating,
+			JoinedAtUtc:  time.Now().UTC(),
+			TournamentID: t.ID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	entered := slices.ContainsFunc(t.Players, func(p tournament.Player) bool { return p.Id == claims.UserID })
+	if joined == 0 && !entered {
+		return tournamentError(tournament.ErrStarted)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LeaveTournament withdraws the player from a tournament that has not
+// started yet.
+func (h *Handler) LeaveTournament(c echo.Context) error {
+	tournamentId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tournament id")
+	}
+	claims := userClaims(c)
+
+	left, err := h.DB.LeaveTournament(
+		c.Request().Context(), sqlc.LeaveTournamentParams{
+			TournamentID: tournamentId,
+			PlayerID:     claims.UserID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if left == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "not entered or tournament has started already")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// StartTournament closes the registration of a tournament the player
+// organizes. The first round is paired within a few seconds.
+func (h *Handler) StartTournament(c echo.Context) error {
+	tournamentId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tournament id")
+	}
+	claims := userClaims(c)
+
+	if err = h.Tournaments.Start(c.Request().Context(), tournamentId, claims.UserID); err != nil {
+		return tournamentError(err)
+	}
+
+	c.Logger().Infof("%v started tournament %v", claims.Username, tournamentId)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) tournament(c echo.Context) (*tournament.Tournament, error) {
+	tournamentId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid tournament id")
+	}
+	t, err := h.Tournaments.Load(c.Request().Context(), tournamentId)
+	if err != nil {
+		return nil, tournamentError(err)
+	}
+	return t, nil
+}
+
+func tournamentError(err error) error {
+	switch {
+	case errors.Is(err, tournament.ErrTournamentNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, tournament.ErrNotOrganizer),
+		errors.Is(err, tournament.ErrNotEntered):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, tournament.ErrNotArena):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, tournament.ErrStarted),
+		errors.Is(err, tournament.ErrTooFewPlayers),
+		errors.Is(err, tournament.ErrNotRunning):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"backend/generated/sqlc"
+	"backend/message"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coder/websocket"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ticketProtocol prefixes a ticket passed among the websocket subprotocols,
+// which browsers can set unlike headers, and which stay out of access logs.
+// Clients offer it next to the protocol they speak, such as json.v2, a client
+// offering the ticket alone speaks json.v1.
+const ticketProtocol = "ticket."
+
+var (
+	errInvalidTicket = errors.New("invalid or expired ticket")
+	errAuthRequired  = errors.New("authentication required")
+)
+
+type TicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CreateTicket issues a short-lived ticket the player redeems once to open a
+// websocket, so that their token never has to be put in a URL.
+func (h *Handler) CreateTicket(c echo.Context) error {
+	claims := userClaims(c)
+	ctx := c.Request().Context()
+	now := time.Now()
+	if err := h.DB.DeleteExpiredTickets(ctx, now); err != nil {
+		return err
+	}
+
+	ticket := rand.Text()
+	expiresAt := now.Add(h.Config.App.Security.TicketTTL)
+	err := h.DB.CreateTicket(
+		ctx, sqlc.CreateTicketParams{
+			Ticket:       ticket,
+			UserID:       claims.UserID,
+			ExpiresAtUtc: expiresAt,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, TicketResponse{Ticket: ticket, ExpiresAt: expiresAt})
+}
+
+// websocketAuth authenticates a websocket upgrade with a ticket passed as a
+// subprotocol, or with a token in the Authorization header, or in ?token= if
+// the configuration allows it. Upgrades without credentials go through, their
+// client authenticates with its first message.
+func (h *Handler) websocketAuth(jwtMiddleware echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withToken := jwtMiddleware(next)
+		return func(c echo.Context) error {
+			req := c.Request()
+			if ticket := websocketTicket(c); ticket != "" {
+				claims, err := h.redeemTicket(req.Context(), ticket)
+				if errors.Is(err, errInvalidTicket) {
+					return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+				}
+				if err != nil {
+					return err
+				}
+				c.Set("user", &jwt.Token{Claims: claims, Valid: true})
+				return next(c)
+			}
+
+			if token := c.QueryParam("token"); token != "" {
+				if !h.Config.App.Security.QueryToken {
+					return echo.NewHTTPError(http.StatusUnauthorized, "query tokens are disabled, use a ticket")
+				}
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			}
+			if req.Header.Get("Authorization") != "" {
+				return withToken(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// websocketTicket returns the ticket among the subprotocols of an upgrade.
+// Tickets are never taken from the URL, which ends up in access logs.
+func websocketTicket(c echo.Context) string {
+	for _, header := range c.Request().Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if ticket, found := strings.CutPrefix(strings.TrimSpace(protocol), ticketProtocol); found {
+				return ticket
+			}
+		}
+	}
+	return ""
+}
+
+// websocketClaims returns the claims of the player behind a websocket, reading
+// its auth message if the upgrade came without credentials.
+func (h *Handler) websocketClaims(c echo.Context, ws *websocket.Conn, codec message.Codec) (*UserClaims, error) {
+	if token, authenticated := c.Get("user").(*jwt.Token); authenticated {
+		return token.Claims.(*UserClaims), nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), h.Config.App.Security.AuthTimeout)
+	defer cancel()
+	_, r, err := ws.Reader(ctx)
+	if err != nil {
+		return nil, errAuthRequired
+	}
+	msg, err := codec.Decode(r)
+	if err != nil || msg.Type != message.TypeAuth {
+		return nil, errAuthRequired
+	}
+	var payload message.AuthPayload
+	if err = json.Unmarshal(msg.Payload, &payload); err != nil {
+		return nil, errAuthRequired
+	}
+	switch {
+	case payload.Ticket != "":
+		return h.redeemTicket(ctx, payload.Ticket)
+	case payload.Token != "":
+		return h.parseToken(payload.Token)
+	}
+	return nil, errAuthRequired
+}
+
+// redeemTicket spends a ticket, it cannot be used again afterwards.
+func (h *Handler) redeemTicket(ctx context.Context, ticket string) (*UserClaims, error) {
+	user, err := h.DB.RedeemTicket(ctx, sqlc.RedeemTicketParams{Ticket: ticket, Now: time.Now()})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errInvalidTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &UserClaims{UserID: user.ID, Username: user.Username}, nil
+}
+
+// parseToken validates a token the way the JWT middleware does.
+func (h *Handler) parseToken(signed string) (*UserClaims, error) {
+	claims := new(UserClaims)
+	_, err := jwt.ParseWithClaims(
+		signed, claims, func(*jwt.Token) (any, error) {
+			return []byte(h.Config.App.Security.JwtSecret), nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
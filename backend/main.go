@@ -2,11 +2,17 @@ package main
 
 import (
 	"backend/cache"
+	"backend/cluster"
 	"backend/config"
+	"backend/correspondence"
 	"backend/generated/sqlc"
 	"backend/handlers"
+	"backend/puzzle"
+	"backend/simul"
+	"backend/tournament"
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -48,17 +54,65 @@ func run(e *echo.Echo) error {
 	defer dbpool.Close()
 
 	queries := sqlc.New(dbpool)
-	gameCache := cache.NewDefaultCache(queries, dbpool)
+	if _, err = cache.ParseColorPolicy(cfg.App.Matchmaking.ColorPolicy); err != nil {
+		return err
+	}
+	if _, err = cache.ParseBotFallback(cfg.App.Matchmaking.BotFallback); err != nil {
+		return err
+	}
+	if _, err = cache.ParseSlowConsumer(cfg.App.Websockets.SlowConsumer); err != nil {
+		return err
+	}
+	queues, err := cache.NewQueues(cfg.App.Matchmaking)
+	if err != nil {
+		return err
+	}
+	var bus *cluster.Bus
+	if cfg.App.Cluster.Enabled {
+		node := cfg.App.Cluster.Node
+		if node == "" {
+			if node, err = os.Hostname(); err != nil {
+				return err
+			}
+		}
+		bus = cluster.NewBus(dbpool, queries, node, e.Logger)
+		go bus.Listen(ctx)
+	}
+	gameCache := cache.NewDefaultCache(queries, dbpool, cfg.App.Matchmaking, cfg.App.Websockets, queues, bus, e.Logger)
+	defer gameCache.Close()
+	if err = gameCache.Restore(ctx); err != nil {
+		return err
+	}
+	correspondenceGames := correspondence.NewService(queries, cfg.App.Correspondence, gameCache.SendTo, e.Logger)
+	tournaments := tournament.NewService(queries, cfg.App.Tournaments, gameCache, e.Logger)
 	h := &handlers.Handler{
-		DB:        queries,
-		Config:    *cfg,
-		Conn:      dbpool,
-		GameCache: gameCache,
-		BaseCtx:   ctx,
+		DB:             queries,
+		Config:         *cfg,
+		Conn:           dbpool,
+		GameCache:      gameCache,
+		Correspondence: correspondenceGames,
+		Tournaments:    tournaments,
+		Simuls:         simul.NewService(queries, gameCache, e.Logger),
+		BaseCtx:        ctx,
 	}
 
 	handlers.ConfigureRoutes(h, e)
-	go gameCache.RunMatchmaking(ctx)
+	if bus != nil {
+		go gameCache.RunCluster(ctx)
+		// rounds are paired by the node running matchmaking, so that they
+		// are paired once
+		go bus.Lead(
+			ctx, func(ctx context.Context) {
+				go tournaments.Run(ctx)
+				gameCache.RunMatchmaking(ctx)
+			},
+		)
+	} else {
+		go gameCache.RunMatchmaking(ctx)
+		go tournaments.Run(ctx)
+	}
+	go puzzle.NewGenerator(queries, cfg.App.Puzzles, e.Logger).Run(ctx)
+	go correspondenceGames.Run(ctx)
 
 	go func() {
 		port := fmt.Sprintf(":%d", cfg.App.Port)
@@ -67,12 +121,27 @@ func run(e *echo.Echo) error {
 		}
 	}()
 
+	// metrics are kept off the public listener
+	debugMux := http.NewServeMux()
+	debugMux.Handle("/debug/vars", expvar.Handler())
+	debug := &http.Server{Addr: cfg.App.DebugAddr, Handler: debugMux}
+	if debug.Addr != "" {
+		go func() {
+			if err := debug.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				e.Logger.Error(err)
+			}
+		}()
+	}
+
 	<-ctx.Done()
 	sdCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	if err = e.Shutdown(sdCtx); err != nil {
 		return err
 	}
+	if err = debug.Shutdown(sdCtx); err != nil {
+		return err
+	}
 
 	return nil
 }
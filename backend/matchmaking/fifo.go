@@ -0,0 +1,23 @@
+package matchmaking
+
+import "time"
+
+// FIFO groups players in the order they joined the queue.
+type FIFO struct {
+	queue
+}
+
+func NewFIFO(size int) *FIFO {
+	return &FIFO{queue: queue{size: size}}
+}
+
+func (f *FIFO) Tick(time.Time) []Pairing {
+	var pairings []Pairing
+	for len(f.tickets) >= f.size {
+		players := make([]Ticket, f.size)
+		copy(players, f.tickets)
+		pairings = append(pairings, Pairing{Players: players})
+		f.tickets = f.tickets[f.size:]
+	}
+	return pairings
+}
@@ -0,0 +1,103 @@
+package matchmaking
+
+import (
+	"backend/config"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+// Ticket is a player's place in the matchmaking queue.
+type Ticket struct {
+	PlayerId uuid.UUID
+	Rating   int32
+	QueuedAt time.Time
+}
+
+// Pairing is a group of players that should be seated in the same lobby.
+type Pairing struct {
+	Players []Ticket
+}
+
+// Stats summarizes a queue for players waiting in it.
+type Stats struct {
+	Waiting int `json:"waiting"`
+	// AverageWait is a moving average of how long recently paired players
+	// waited, zero until the queue paired anyone.
+	AverageWait time.Duration `json:"averageWait"`
+}
+
+// Matchmaker decides which queued players play each other. It is only used
+// from the matchmaking goroutine and need not be safe for concurrent use.
+type Matchmaker interface {
+	// Enqueue adds a player to the queue, replacing a ticket they already
+	// hold.
+	Enqueue(t Ticket)
+	// Cancel removes a player from the queue and reports whether they were
+	// in it.
+	Cancel(playerId uuid.UUID) bool
+	// Tick removes and returns the players that can be paired up now.
+	Tick(now time.Time) []Pairing
+	// Len returns the number of players waiting in the queue.
+	Len() int
+	// Tickets returns the tickets waiting in the queue, oldest first.
+	Tickets() []Ticket
+}
+
+type Strategy string
+
+const (
+	// StrategyFIFO pairs players in the order they joined the queue.
+	StrategyFIFO Strategy = "fifo"
+	// StrategyRating pairs players of similar rating, accepting a larger
+	// difference the longer they wait.
+	StrategyRating Strategy = "rating"
+)
+
+// New creates the matchmaker selected by the configuration, pairing groups
+// of size players.
+func New(cfg config.MatchmakingConfig, size int) (Matchmaker, error) {
+	switch Strategy(cfg.Strategy) {
+	case StrategyFIFO:
+		return NewFIFO(size), nil
+	case StrategyRating:
+		return NewRating(size, cfg.RatingWindow, cfg.RatingWindowGrowth), nil
+	}
+	return nil, fmt.Errorf("unknown matchmaking strategy '%s'", cfg.Strategy)
+}
+
+// queue keeps tickets in the order they were queued.
+type queue struct {
+	size    int
+	tickets []Ticket
+}
+
+func (q *queue) Len() int {
+	return len(q.tickets)
+}
+
+func (q *queue) Tickets() []Ticket {
+	return slices.Clone(q.tickets)
+}
+
+func (q *queue) Enqueue(t Ticket) {
+	q.Cancel(t.PlayerId)
+	i := len(q.tickets)
+	for i > 0 && q.tickets[i-1].QueuedAt.After(t.QueuedAt) {
+		i--
+	}
+	q.tickets = append(q.tickets, Ticket{})
+	copy(q.tickets[i+1:], q.tickets[i:])
+	q.tickets[i] = t
+}
+
+func (q *queue) Cancel(playerId uuid.UUID) bool {
+	for i, t := range q.tickets {
+		if t.PlayerId == playerId {
+			q.tickets = append(q.tickets[:i], q.tickets[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package matchmaking
+
+import (
+	"slices"
+	"time"
+)
+
+// Rating groups players whose ratings differ by at most a window that starts
+// at Window points and widens by Growth points for every second the player
+// who has waited longest has been in the queue. That player is grouped with
+// the closest rated players inside their window.
+type Rating struct {
+	queue
+	Window int32
+	Growth int32
+}
+
+func NewRating(size int, window int32, growth int32) *Rating {
+	return &Rating{queue: queue{size: size}, Window: window, Growth: growth}
+}
+
+func (r *Rating) Tick(now time.Time) []Pairing {
+	var pairings []Pairing
+	grouped := make([]bool, len(r.tickets))
+	for i, t := range r.tickets {
+		if grouped[i] {
+			continue
+		}
+		window := r.Window + r.Growth*int32(now.Sub(t.QueuedAt)/time.Second)
+		var candidates []int
+		for j := i + 1; j < len(r.tickets); j++ {
+			if !grouped[j] && abs(t.Rating-r.tickets[j].Rating) <= window {
+				candidates = append(candidates, j)
+			}
+		}
+		if len(candidates) < r.size-1 {
+			continue
+		}
+		slices.SortStableFunc(
+			candidates, func(a, b int) int {
+				return int(abs(t.Rating-r.tickets[a].Rating) - abs(t.Rating-r.tickets[b].Rating))
+			},
+		)
+
+		grouped[i] = true
+		players := []Ticket{t}
+		for _, j := range candidates[:r.size-1] {
+			grouped[j] = true
+			players = append(players, r.tickets[j])
+		}
+		pairings = append(pairings, Pairing{Players: players})
+	}
+
+	waiting := r.tickets[:0]
+	for i, t := range r.tickets {
+		if !grouped[i] {
+			waiting = append(waiting, t)
+		}
+	}
+	r.tickets = waiting
+	return pairings
+}
+
+func abs(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,60 @@
+package message
+
+import (
+	"encoding/json"
+	"github.com/coder/websocket"
+	"io"
+)
+
+// Codec encodes messages into websocket frames and decodes them back.
+// Payloads stay JSON inside Message whatever the codec, so that readers and
+// writers need not know the encoding a connection uses.
+type Codec interface {
+	Encode(msg Message) ([]byte, error)
+	Decode(r io.Reader) (Message, error)
+	// FrameType is the type of the websocket frames carrying the messages.
+	FrameType() websocket.MessageType
+}
+
+var (
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = msgpackCodec{}
+)
+
+// Protocol is the message version and encoding a connection negotiated.
+type Protocol struct {
+	Version string
+	Codec   Codec
+}
+
+var protocols = map[string]Protocol{
+	"msgpack.v2": {Version: V2, Codec: MessagePack},
+	"json.v2":    {Version: V2, Codec: JSON},
+	"json.v1":    {Version: V1, Codec: JSON},
+}
+
+// Subprotocols are offered in order of preference, a client gets the first
+// one it asked for. Clients asking for none speak json.v1.
+var Subprotocols = []string{"msgpack.v2", "json.v2", "json.v1"}
+
+// Negotiate returns the protocol of a negotiated subprotocol.
+func Negotiate(subprotocol string) Protocol {
+	if p, known := protocols[subprotocol]; known {
+		return p
+	}
+	return protocols["json.v1"]
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(msg Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(r io.Reader) (Message, error) {
+	return JsonDecodeBaseMsg(json.NewDecoder(r))
+}
+
+func (jsonCodec) FrameType() websocket.MessageType {
+	return websocket.MessageText
+}
@@ -0,0 +1,469 @@
+package message
+
+import (
+	"backend/game"
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/coder/websocket"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	endsAt   = time.Date(2025, 8, 17, 12, 30, 0, 0, time.UTC)
+	deadline = time.Date(2025, 8, 20, 8, 0, 0, 0, time.UTC)
+)
+
+// payloads has a payload of every message type, with values picked to cover
+// the encodings of numbers and strings.
+var payloads = []struct {
+	typ     string
+	payload any
+}{
+	{TypeWaitingForGame, WaitingForGamePayload{}},
+	{TypeSearchGame, SearchGamePayload{Queue: "three-player"}},
+	{TypeCancelSearch, CancelSearchPayload{}},
+	{TypeQueueStatus, QueueStatusPayload{Queue: "standard", Waiting: 127, Elapsed: 128, EstimatedWait: 65536}},
+	{TypeBotOffer, BotOfferPayload{Queue: "standard"}},
+	{TypeAcceptBot, AcceptBotPayload{}},
+	{
+		TypeChallenge, ChallengePayload{
+			Id: "8c9a1f3e-6f61-4b0e-9a51-1d4a5c2e9b7f", From: "alice", Variant: "standard",
+			Color: game.ColorYellow, ExpiresAt: endsAt,
+		},
+	},
+	{TypeChallengeAnswered, ChallengeAnsweredPayload{Id: "c1", Status: "accepted", LobbyId: "l1"}},
+	{TypePlayCorrespondenceMove, PlayCorrespondenceMovePayload{GameId: "g1", Column: 6}},
+	{
+		TypeCorrespondenceMove, CorrespondenceMovePayload{
+			GameId: "g1", Color: game.ColorRed, Row: 5, Column: 3, Deadline: &deadline,
+		},
+	},
+	{TypeCorrespondenceMove, CorrespondenceMovePayload{GameId: "g1", Over: true, TimedOut: game.ColorYellow}},
+	{
+		TypeFoundGame, FoundGamePayload{
+			LobbyId: "l1",
+			Variant: "standard",
+			State: [][]game.Color{
+				{0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 2, 0, 0, 0},
+				{0, 0, 1, 1, 2, 0, 0},
+			},
+			LastPlayed: game.ColorYellow,
+			ToMove:     game.ColorRed,
+			Messages:   []ChatMessagePayload{{From: "bob", Text: "gl"}},
+			Color:      game.ColorRed,
+			Bot:        true,
+		},
+	},
+	{TypeChat, ChatMessagePayload{From: "bob", Text: "good game 👍, ça va?"}},
+	{TypeChat, ChatMessagePayload{From: "bob", Text: strings.Repeat("x", 300)}},
+	{TypePlayMove, PlayMovePayload{Column: 0}},
+	{TypePlayedMove, PlayedMovePayload{Color: game.ColorRed, Row: 5, Column: 3}},
+	{TypeGameOver, GameOverPayload{Winner: game.ColorNone}},
+	{TypeGameOver, GameOverPayload{Winner: 3, Ranking: []game.Color{3, 1, 4, 2}}},
+	{TypePlayerFinished, PlayerFinishedPayload{Color: 2, Place: 4}},
+	{TypeJoinArena, JoinArenaPayload{TournamentId: "t1"}},
+	{TypeBerserk, BerserkPayload{}},
+	{TypeBerserked, BerserkedPayload{Color: game.ColorYellow}},
+	{
+		TypeArenaLeaderboard, ArenaLeaderboardPayload{
+			TournamentId: "t1",
+			EndsAt:       endsAt,
+			Over:         true,
+			Standings: []ArenaStandingPayload{
+				{Rank: 1, Username: "alice", Score: 17, Played: 9, Streak: 3, OnFire: true},
+				{Rank: 2, Username: "bob", Score: -1, Played: 1},
+			},
+		},
+	},
+	{TypeLatency, LatencyPayload{Color: game.ColorRed, Rtt: 0}},
+	{TypeLatency, LatencyPayload{Color: game.ColorRed, Rtt: -33}},
+	{TypeLatency, LatencyPayload{Color: game.ColorRed, Rtt: math.MaxInt32 + 1}},
+	{TypeLatency, LatencyPayload{Color: game.ColorRed, Rtt: math.MinInt32 - 1}},
+	{TypeAuth, AuthPayload{Ticket: "JBSWY3DPEHPK3PXPJBSWY3DPEH"}},
+	{TypeAck, AckPayload{}},
+	{TypeNack, NackPayload{Code: websocket.StatusPolicyViolation, Err: "rate limit exceeded"}},
+	{
+		TypeError, ErrorPayload{
+			Code: websocket.StatusInvalidFramePayloadData,
+			Err:  "invalid lobby id",
+			ProblematicMsg: Message{
+				Version: V2, Type: TypePlayMove, LobbyId: "nope", Id: "7",
+				Payload: []byte(`{"column":3}`),
+			},
+		},
+	},
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	envelopes := []struct {
+		name string
+		id   string
+		seq  uint64
+	}{
+		{"bare", "", 0},
+		{"small seq", "1", 1},
+		{"uint32 seq", "request-42", math.MaxUint32},
+		{"uint64 seq", strings.Repeat("i", 40), math.MaxUint64},
+	}
+	for _, subprotocol := range []string{"json.v2", "msgpack.v2"} {
+		codec := Negotiate(subprotocol).Codec
+		for _, p := range payloads {
+			for _, e := range envelopes {
+				t.Run(subprotocol+"/"+p.typ+"/"+e.name, func(t *testing.T) {
+					msg, err := NewV2(p.typ, p.payload, e.id, e.seq)
+					if err != nil {
+						t.Fatal(err)
+					}
+					msg.LobbyId = "6b0f1c52-0d0a-4f51-8f0e-2b9d1d1a7c44"
+
+					data, err := codec.Encode(msg)
+					if err != nil {
+						t.Fatal(err)
+					}
+					decoded, err := codec.Decode(bytes.NewReader(data))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if decoded.Version != msg.Version || decoded.Type != msg.Type ||
+						decoded.LobbyId != msg.LobbyId || decoded.Id != msg.Id || decoded.Seq != msg.Seq {
+						t.Errorf("envelope = %+v, want %+v", decoded, msg)
+					}
+					got := reflect.New(reflect.TypeOf(p.payload))
+					if err = json.Unmarshal(decoded.Payload, got.Interface()); err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(got.Elem().Interface(), p.payload) {
+						t.Errorf("payload = %+v, want %+v", got.Elem().Interface(), p.payload)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCodecsRejectInvalidEnvelopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   Codec
+		data    string
+		wantErr string
+	}{
+		{"json without version", JSON, `{"type":"playMove","payload":{}}`, "'version'"},
+		{"json without type", JSON, `{"version":"v2","payload":{}}`, "'type'"},
+		// {"type": "playMove", "payload": {}}
+		{"msgpack without version", MessagePack, "82a474797065a8706c61794d6f7665a77061796c6f616480", "'version'"},
+		// {"version": "v2", "payload": {}}
+		{"msgpack without type", MessagePack, "82a776657273696f6ea27632a77061796c6f616480", "'type'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(tt.data)
+			if tt.codec == MessagePack {
+				data = unhex(t, tt.data)
+			}
+			_, err := tt.codec.Decode(bytes.NewReader(data))
+			var msgErr Error
+			if !errors.As(err, &msgErr) || !strings.Contains(msgErr.Err, tt.wantErr) {
+				t.Errorf("err = %v, want a message error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodecsRejectTruncatedInput(t *testing.T) {
+	msg, err := NewV2(TypeFoundGame, payloads[11].payload, "request-42", math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.LobbyId = "l1"
+	for _, codec := range []Codec{JSON, MessagePack} {
+		data, err := codec.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := range len(data) {
+			if _, err = codec.Decode(bytes.NewReader(data[:n])); err == nil {
+				t.Errorf("%T decoded %d of %d bytes without error", codec, n, len(data))
+			}
+		}
+	}
+}
+
+func TestMessagePackRejectsMalformedInput(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"not a map", "01"},
+		{"array", "9101"},
+		{"never used code", "c1"},
+		{"fixext", "d40100"},
+		{"ext8", "c7010100"},
+		{"integer key", "810101"},
+		{"nil key", "81c001"},
+		{"map key missing its value", "81a161"},
+		{"str8 longer than the frame", "d9ff61"},
+		{"str32 longer than the frame", "dbffffffff61"},
+		{"array32 longer than the frame", "81a161ddffffffff"},
+		{"map32 longer than the frame", "dfffffffff"},
+		{"short uint64", "81a161cf0001"},
+		{"short float64", "81a161cb3ff8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := MessagePack.Decode(bytes.NewReader(unhex(t, tt.data))); err == nil {
+				t.Error("decoded malformed input without error")
+			}
+		})
+	}
+}
+
+// TestMessagePackEncoding checks values against their encoding in the
+// MessagePack spec, so that other implementations read what we write.
+func TestMessagePackEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"nil", nil, "c0"},
+		{"false", false, "c2"},
+		{"true", true, "c3"},
+		{"positive fixint", int64(127), "7f"},
+		{"uint8", int64(128), "cc80"},
+		{"uint16", int64(256), "cd0100"},
+		{"uint32", int64(65536), "ce00010000"},
+		{"uint64", uint64(math.MaxUint64), "cfffffffffffffffff"},
+		{"negative fixint", int64(-32), "e0"},
+		{"int8", int64(-33), "d0df"},
+		{"int16", int64(-129), "d1ff7f"},
+		{"int32", int64(-32769), "d2ffff7fff"},
+		{"int64", int64(math.MinInt64), "d38000000000000000"},
+		{"json integer", json.Number("300"), "cd012c"},
+		{"json uint64", json.Number("18446744073709551615"), "cfffffffffffffffff"},
+		{"json float", json.Number("1.5"), "cb3ff8000000000000"},
+		{"float64", 0.1, "cb3fb999999999999a"},
+		{"fixstr", "a", "a161"},
+		{"str8", strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{"str16", strings.Repeat("a", 256), "da0100" + strings.Repeat("61", 256)},
+		{"fixarray", []any{int64(1), "a"}, "9201a161"},
+		{"array16", make([]any, 16), "dc0010" + strings.Repeat("c0", 16)},
+		{"fixmap", map[string]any{"b": int64(2), "a": int64(1)}, "82a16101a16202"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := packValue(&buf, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(buf.Bytes()); got != tt.want {
+				t.Errorf("packValue(%v) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMessagePackValues round-trips values through every size class of
+// their encoding.
+func TestMessagePackValues(t *testing.T) {
+	bigMap := make(map[string]any, 1<<16)
+	for i := range 1 << 16 {
+		bigMap[strings.Repeat("k", i%7)+string(rune('a'+i%26))+hex.EncodeToString([]byte{byte(i >> 8), byte(i)})] = int64(i)
+	}
+	tests := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{"zero", int64(0), int64(0)},
+		{"max int64", int64(math.MaxInt64), int64(math.MaxInt64)},
+		{"min int64", int64(math.MinInt64), int64(math.MinInt64)},
+		{"max uint64", uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{"uint64 in int64 range", uint64(42), int64(42)},
+		{"negative float", -2.25, -2.25},
+		{"infinity", math.Inf(1), math.Inf(1)},
+		{"empty string", "", ""},
+		{"str8", strings.Repeat("é", 100), strings.Repeat("é", 100)},
+		{"str16", strings.Repeat("b", math.MaxUint16), strings.Repeat("b", math.MaxUint16)},
+		{"str32", strings.Repeat("c", math.MaxUint16+1), strings.Repeat("c", math.MaxUint16+1)},
+		{"array16", make([]any, math.MaxUint16), make([]any, math.MaxUint16)},
+		{"array32", make([]any, math.MaxUint16+1), make([]any, math.MaxUint16+1)},
+		{"map16", map[string]any{
+			"a": int64(1), "b": int64(2), "c": int64(3), "d": int64(4), "e": int64(5), "f": int64(6),
+			"g": int64(7), "h": int64(8), "i": int64(9), "j": int64(10), "k": int64(11), "l": int64(12),
+			"m": int64(13), "n": int64(14), "o": int64(15), "p": int64(16),
+		}, nil},
+		{"map32", bigMap, nil},
+		{"nested", map[string]any{"a": []any{map[string]any{"b": nil}, true}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == nil {
+				want = tt.value
+			}
+			var buf bytes.Buffer
+			if err := packValue(&buf, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			got, err := unpackValue(bufio.NewReader(&buf), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip of %.40v = %.40v", tt.value, got)
+			}
+		})
+	}
+}
+
+func TestMessagePackBinaryAndFloat32(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want any
+	}{
+		// other implementations may send strings as bin and floats as float32
+		{"bin8", "c403616263", "abc"},
+		{"float32", "ca3fc00000", 1.5},
+		{"negative int64 as uint64", "cf8000000000000000", uint64(1 << 63)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unpackValue(bufio.NewReader(bytes.NewReader(unhex(t, tt.data))), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("unpackValue(%s) = %#v, want %#v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagePackSeq(t *testing.T) {
+	tests := []struct {
+		name string
+		// {"version": "v2", "type": "ack", "seq": ...}
+		seq  string
+		want uint64
+	}{
+		{"fixint", "05", 5},
+		{"uint64", "cfffffffffffffffff", math.MaxUint64},
+		{"negative", "ff", 0},
+		{"string", "a135", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := unhex(t, "83a776657273696f6ea27632a474797065a361636ba3736571"+tt.seq)
+			msg, err := MessagePack.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Seq != tt.want {
+				t.Errorf("seq = %d, want %d", msg.Seq, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagePackDepth(t *testing.T) {
+	// arrays of one array each, down to a nil
+	nested := func(depth int) *bufio.Reader {
+		data := append(bytes.Repeat([]byte{0x91}, depth), 0xc0)
+		return bufio.NewReader(bytes.NewReader(data))
+	}
+	if _, err := unpackValue(nested(maxDepth), 0); err != nil {
+		t.Errorf("%d nested arrays: %v", maxDepth, err)
+	}
+	if _, err := unpackValue(nested(maxDepth+1), 0); !errors.Is(err, errTooDeep) {
+		t.Errorf("%d nested arrays: %v, want %v", maxDepth+1, err, errTooDeep)
+	}
+	// without the bound, a frame of nothing but maps would recurse once per map
+	deep := append([]byte{0x81, 0xa1, 'p'}, bytes.Repeat([]byte{0x81, 0xa1, 'p'}, 1<<16)...)
+	if _, err := MessagePack.Decode(bytes.NewReader(deep)); !errors.Is(err, errTooDeep) {
+		t.Errorf("deeply nested maps: %v, want %v", err, errTooDeep)
+	}
+}
+
+// FuzzMessagePackDecode feeds arbitrary frames to the decoder, which must
+// fail cleanly on what it cannot read, and read back what it writes for what
+// it accepted.
+func FuzzMessagePackDecode(f *testing.F) {
+	for _, p := range payloads {
+		msg, err := NewV2(p.typ, p.payload, "request-42", math.MaxUint32)
+		if err != nil {
+			f.Fatal(err)
+		}
+		data, err := MessagePack.Encode(msg)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{0x91, 0x91, 0x91, 0xc0})
+	f.Add([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := MessagePack.Decode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		encoded, err := MessagePack.Encode(msg)
+		if err != nil {
+			t.Fatalf("encoding %+v: %v", msg, err)
+		}
+		again, err := MessagePack.Decode(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("decoding %x, encoded from %+v: %v", encoded, msg, err)
+		}
+		if !reflect.DeepEqual(again, msg) {
+			t.Errorf("decoded %+v, then %+v", msg, again)
+		}
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		version     string
+		codec       Codec
+	}{
+		{"msgpack.v2", V2, MessagePack},
+		{"json.v2", V2, JSON},
+		{"json.v1", V1, JSON},
+		{"", V1, JSON},
+		{"ticket.ABC", V1, JSON},
+	}
+	for _, tt := range tests {
+		p := Negotiate(tt.subprotocol)
+		if p.Version != tt.version || p.Codec != tt.codec {
+			t.Errorf("Negotiate(%q) = %+v, want %s %T", tt.subprotocol, p, tt.version, tt.codec)
+		}
+	}
+	if MessagePack.FrameType() != websocket.MessageBinary || JSON.FrameType() != websocket.MessageText {
+		t.Error("unexpected frame types")
+	}
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
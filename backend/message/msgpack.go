@@ -0,0 +1,375 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coder/websocket"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// maxDepth bounds how deeply the arrays and maps of a message may nest. No
+// message comes close, the bound keeps a crafted frame from decoding into an
+// arbitrarily deep value.
+const maxDepth = 32
+
+var errTooDeep = fmt.Errorf("msgpack: values nest deeper than %d levels", maxDepth)
+
+// msgpackCodec encodes messages in MessagePack: the envelope is a map keyed
+// like its JSON fields, and the payload the MessagePack form of its JSON.
+type msgpackCodec struct{}
+
+func (msgpackCodec) FrameType() websocket.MessageType {
+	return websocket.MessageBinary
+}
+
+func (msgpackCodec) Encode(msg Message) ([]byte, error) {
+	envelope := map[string]any{
+		"version": msg.Version,
+		"type":    msg.Type,
+	}
+	if msg.LobbyId != "" {
+		envelope["lobbyId"] = msg.LobbyId
+	}
+	if msg.Id != "" {
+		envelope["id"] = msg.Id
+	}
+	if msg.Seq != 0 {
+		envelope["seq"] = msg.Seq
+	}
+	if msg.Payload != nil {
+		decoder := json.NewDecoder(bytes.NewReader(msg.Payload))
+		decoder.UseNumber()
+		var payload any
+		if err := decoder.Decode(&payload); err != nil {
+			return nil, err
+		}
+		envelope["payload"] = payload
+	}
+
+	var buf bytes.Buffer
+	if err := packValue(&buf, envelope); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(r io.Reader) (Message, error) {
+	value, err := unpackValue(bufio.NewReader(r), 0)
+	if err != nil {
+		return Nil, err
+	}
+	envelope, isMap := value.(map[string]any)
+	if !isMap {
+		return Nil, errors.New("msgpack: message is not a map")
+	}
+
+	var msg Message
+	msg.Version, _ = envelope["version"].(string)
+	msg.Type, _ = envelope["type"].(string)
+	msg.LobbyId, _ = envelope["lobbyId"].(string)
+	msg.Id, _ = envelope["id"].(string)
+	switch seq := envelope["seq"].(type) {
+	case uint64:
+		msg.Seq = seq
+	case int64:
+		if seq >= 0 {
+			msg.Seq = uint64(seq)
+		}
+	}
+	if payload, set := envelope["payload"]; set {
+		if msg.Payload, err = json.Marshal(payload); err != nil {
+			return Nil, err
+		}
+	}
+	return validate(msg)
+}
+
+func packValue(w *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case nil:
+		w.WriteByte(0xc0)
+	case bool:
+		if v {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case string:
+		packString(w, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			packInt(w, i)
+		} else if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			packUint(w, u)
+		} else if f, err := v.Float64(); err == nil {
+			packFloat(w, f)
+		} else {
+			return err
+		}
+	case uint64:
+		packUint(w, v)
+	case int64:
+		packInt(w, v)
+	case float64:
+		packFloat(w, v)
+	case []any:
+		packHeader(w, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := packValue(w, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		packHeader(w, len(v), 0x80, 0xde, 0xdf)
+		// sorted keys keep the encoding of a message stable
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			packString(w, k)
+			if err := packValue(w, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot encode %T", value)
+	}
+	return nil
+}
+
+// packHeader writes the length of an array or map, in its fixed form when it
+// is short enough.
+func packHeader(w *bytes.Buffer, n int, fixed byte, code16 byte, code32 byte) {
+	switch {
+	case n < 16:
+		w.WriteByte(fixed | byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(code16)
+		w.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		w.WriteByte(code32)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func packString(w *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		w.WriteByte(0xd9)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(0xda)
+		w.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		w.WriteByte(0xdb)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	w.WriteString(s)
+}
+
+func packInt(w *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		packUint(w, uint64(i))
+	case i >= -32:
+		w.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		w.WriteByte(0xd0)
+		w.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		w.WriteByte(0xd1)
+		w.Write(binary.BigEndian.AppendUint16(nil, uint16(i)))
+	case i >= math.MinInt32:
+		w.WriteByte(0xd2)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	default:
+		w.WriteByte(0xd3)
+		w.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+}
+
+func packUint(w *bytes.Buffer, u uint64) {
+	switch {
+	case u < 128:
+		w.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		w.WriteByte(0xcc)
+		w.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		w.WriteByte(0xcd)
+		w.Write(binary.BigEndian.AppendUint16(nil, uint16(u)))
+	case u <= math.MaxUint32:
+		w.WriteByte(0xce)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(u)))
+	default:
+		w.WriteByte(0xcf)
+		w.Write(binary.BigEndian.AppendUint64(nil, u))
+	}
+}
+
+func packFloat(w *bytes.Buffer, f float64) {
+	w.WriteByte(0xcb)
+	w.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+// unpackValue reads a value into the types encoding/json decodes into, with
+// integers as int64, or uint64 when they do not fit. Binary strings are read
+// as strings and extension types are rejected. depth is the number of arrays
+// and maps the value is in.
+func unpackValue(r *bufio.Reader, depth int) (any, error) {
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case code < 0x80:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return unpackMap(r, int(code&0x0f), depth)
+	case code&0xf0 == 0x90:
+		return unpackArray(r, int(code&0x0f), depth)
+	case code&0xe0 == 0xa0:
+		return unpackString(r, int(code&0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		n, err := unpackUint(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		return unpackString(r, int(n))
+	case 0xc5, 0xda:
+		n, err := unpackUint(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		return unpackString(r, int(n))
+	case 0xc6, 0xdb:
+		n, err := unpackUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return unpackString(r, int(n))
+	case 0xca:
+		bits, err := unpackUint(r, 4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := unpackUint(r, 8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := unpackUint(r, 1<<(code-0xcc))
+		if err != nil || u > math.MaxInt64 {
+			return u, err
+		}
+		return int64(u), nil
+	case 0xd0:
+		u, err := unpackUint(r, 1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := unpackUint(r, 2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := unpackUint(r, 4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := unpackUint(r, 8)
+		return int64(u), err
+	case 0xdc, 0xde:
+		n, err := unpackUint(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		if code == 0xdc {
+			return unpackArray(r, int(n), depth)
+		}
+		return unpackMap(r, int(n), depth)
+	case 0xdd, 0xdf:
+		n, err := unpackUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		if code == 0xdd {
+			return unpackArray(r, int(n), depth)
+		}
+		return unpackMap(r, int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", code)
+}
+
+func unpackUint(r *bufio.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func unpackString(r *bufio.Reader, n int) (string, error) {
+	// the length is checked against what is left to read, so that a
+	// corrupt length cannot make us allocate more than the frame holds
+	data, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < n {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(data), nil
+}
+
+func unpackArray(r *bufio.Reader, n int, depth int) ([]any, error) {
+	if depth == maxDepth {
+		return nil, errTooDeep
+	}
+	array := make([]any, 0, min(n, 64))
+	for range n {
+		item, err := unpackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, item)
+	}
+	return array, nil
+}
+
+func unpackMap(r *bufio.Reader, n int, depth int) (map[string]any, error) {
+	if depth == maxDepth {
+		return nil, errTooDeep
+	}
+	m := make(map[string]any, min(n, 64))
+	for range n {
+		key, err := unpackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		k, isString := key.(string)
+		if !isString {
+			return nil, errors.New("msgpack: map keys must be strings")
+		}
+		if m[k], err = unpackValue(r, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
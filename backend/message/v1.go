@@ -5,13 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/coder/websocket"
+	"time"
 )
 
 const v1 = "v1"
 
 var (
-	Subprotocols = []string{"json.v1"}
-	Nil          = Message{
+	Nil = Message{
 		Version: v1,
 		Type:    "nil",
 		Payload: nil,
@@ -19,8 +19,16 @@ var (
 )
 
 type Message struct {
-	Version string          `json:"version"`
-	Type    string          `json:"type"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	// LobbyId names the game a message is about, for players in several
+	// games at once. Replies about a single game may leave it out.
+	LobbyId string `json:"lobbyId,omitempty"`
+	// Id is the request id a json.v2 client picked for a message it sends,
+	// replies to it carry the same id.
+	Id string `json:"id,omitempty"`
+	// Seq numbers the messages about a lobby sent to json.v2 clients.
+	Seq     uint64          `json:"seq,omitempty"`
 	Payload json.RawMessage `json:"payload"`
 }
 
@@ -47,7 +55,12 @@ func JsonDecodeBaseMsg(decoder *json.Decoder) (Message, error) {
 	if err := decoder.Decode(&msg); err != nil {
 		return msg, err
 	}
+	return validate(msg)
+}
 
+// validate checks the envelope of a message read from a client, whatever its
+// encoding.
+func validate(msg Message) (Message, error) {
 	if msg.Version == "" {
 		return Nil, Error{
 			Code: websocket.StatusInvalidFramePayloadData,
@@ -75,14 +88,111 @@ const TypeWaitingForGame = "waitingForGame"
 
 type WaitingForGamePayload struct{}
 
+// TypeSearchGame is sent by the client after waitingForGame to pick the
+// matchmaking queue it wants a game in.
+const TypeSearchGame = "searchGame"
+
+type SearchGamePayload struct {
+	Queue string `json:"queue,omitempty"`
+}
+
+// TypeCancelSearch takes the client out of matchmaking, it may search again
+// afterwards.
+const TypeCancelSearch = "cancelSearch"
+
+type CancelSearchPayload struct{}
+
+// TypeQueueStatus is sent periodically while the client searches for a game.
+// Times are in seconds, EstimatedWait is zero until the queue paired anyone.
+const TypeQueueStatus = "queueStatus"
+
+type QueueStatusPayload struct {
+	Queue         string `json:"queue"`
+	Waiting       int    `json:"waiting"`
+	Elapsed       int    `json:"elapsed"`
+	EstimatedWait int    `json:"estimatedWait"`
+}
+
+// TypeBotOffer is sent to a client that searched for longer than the
+// configured timeout, it may answer with acceptBot or keep waiting.
+const TypeBotOffer = "botOffer"
+
+type BotOfferPayload struct {
+	Queue string `json:"queue"`
+}
+
+// TypeAcceptBot accepts a bot game offered to the client.
+const TypeAcceptBot = "acceptBot"
+
+type AcceptBotPayload struct{}
+
+// TypeChallenge tells a connected client that another player challenged
+// them. Challenges are answered through the challenges endpoints.
+const TypeChallenge = "challenge"
+
+type ChallengePayload struct {
+	Id      string `json:"id"`
+	From    string `json:"from"`
+	Variant string `json:"variant"`
+	// Color is the color the challenger plays, ColorNone when it is assigned
+	// at random.
+	Color game.Color `json:"color"`
+	// DaysPerMove is set for correspondence games.
+	DaysPerMove int       `json:"daysPerMove,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// TypeChallengeAnswered tells the other side of a challenge that it was
+// accepted, declined or cancelled. LobbyId is set once the game started.
+const TypeChallengeAnswered = "challengeAnswered"
+
+type ChallengeAnsweredPayload struct {
+	Id      string `json:"id"`
+	Status  string `json:"status"`
+	LobbyId string `json:"lobbyId,omitempty"`
+	// GameId is set once a correspondence game started.
+	GameId string `json:"gameId,omitempty"`
+}
+
+// TypePlayCorrespondenceMove plays a move in a correspondence game, which
+// can be done from any connection since those games have no lobby.
+const TypePlayCorrespondenceMove = "playCorrespondenceMove"
+
+type PlayCorrespondenceMovePayload struct {
+	GameId string `json:"gameId"`
+	Column uint8  `json:"column"`
+}
+
+// TypeCorrespondenceMove tells a connected client about a move in one of its
+// correspondence games, or that the game timed out, in which case TimedOut
+// is the color that missed its deadline and no move was played.
+const TypeCorrespondenceMove = "correspondenceMove"
+
+type CorrespondenceMovePayload struct {
+	GameId   string     `json:"gameId"`
+	Color    game.Color `json:"color,omitempty"`
+	Row      int        `json:"row"`
+	Column   uint8      `json:"column"`
+	Over     bool       `json:"over,omitempty"`
+	Winner   game.Color `json:"winner,omitempty"`
+	TimedOut game.Color `json:"timedOut,omitempty"`
+	// Deadline is when the next move is due, nil once the game is over.
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
 const TypeFoundGame = "foundGame"
 
 type FoundGamePayload struct {
 	LobbyId    string               `json:"lobbyId"`
-	State      game.Board           `json:"state"`
+	Variant    string               `json:"variant"`
+	State      [][]game.Color       `json:"state"`
 	LastPlayed game.Color           `json:"lastPlayed"`
+	ToMove     game.Color           `json:"toMove"`
 	Messages   []ChatMessagePayload `json:"messages"`
 	Color      game.Color           `json:"color"`
+	// Bot is set for games against the built-in bot, which leave ratings
+	// untouched.
+	Bot bool `json:"bot,omitempty"`
 }
 
 const TypeChat = "chatMessage"
@@ -109,5 +219,117 @@ type PlayedMovePayload struct {
 const TypeGameOver = "gameOver"
 
 type GameOverPayload struct {
-	Winner game.Color `json:"winner"`
+	Winner  game.Color   `json:"winner"`
+	Ranking []game.Color `json:"ranking,omitempty"`
+}
+
+const TypePlayerFinished = "playerFinished"
+
+type PlayerFinishedPayload struct {
+	Color game.Color `json:"color"`
+	Place int        `json:"place"`
+}
+
+// TypeJoinArena queues the client for its next game in an arena tournament
+// it entered. After every game of the arena it is queued again, until it
+// sends cancelSearch.
+const TypeJoinArena = "joinArena"
+
+type JoinArenaPayload struct {
+	TournamentId string `json:"tournamentId"`
+}
+
+// TypeBerserk is sent by a player of an arena game before their first move,
+// to earn an extra point if they win at the cost of one if they lose.
+const TypeBerserk = "berserk"
+
+type BerserkPayload struct{}
+
+// TypeBerserked tells the players of an arena game that one of them went
+// berserk.
+const TypeBerserked = "berserked"
+
+type BerserkedPayload struct {
+	Color game.Color `json:"color"`
+}
+
+// TypeArenaLeaderboard is pushed to the players of an arena whenever its
+// standings change, and once more when it is over.
+const TypeArenaLeaderboard = "arenaLeaderboard"
+
+type ArenaLeaderboardPayload struct {
+	TournamentId string                 `json:"tournamentId"`
+	EndsAt       time.Time              `json:"endsAt"`
+	Over         bool                   `json:"over,omitempty"`
+	Standings    []ArenaStandingPayload `json:"standings"`
+}
+
+type ArenaStandingPayload struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	Score    int    `json:"score"`
+	Played   int    `json:"played"`
+	// Streak is the number of games the player won in a row, from the
+	// second one on their games score double.
+	Streak int  `json:"streak,omitempty"`
+	OnFire bool `json:"onFire,omitempty"`
+}
+
+// TypeLatency tells the players of a game the round trip time of one of
+// them, in milliseconds, measured by the server's pings.
+const TypeLatency = "latency"
+
+type LatencyPayload struct {
+	Color game.Color `json:"color"`
+	Rtt   int        `json:"rtt"`
+}
+
+// TypeAuth is the first message of a client that connected without
+// credentials, it carries the client's token or a websocket ticket.
+const TypeAuth = "auth"
+
+type AuthPayload struct {
+	Token  string `json:"token,omitempty"`
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// DecodePayload unmarshals a payload into the struct matching its message
+// type. Payloads of other types are returned as raw JSON.
+func DecodePayload(typ string, data json.RawMessage) (any, error) {
+	var err error
+	switch typ {
+	case TypeChat:
+		var payload ChatMessagePayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	case TypePlayedMove:
+		var payload PlayedMovePayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	case TypePlayerFinished:
+		var payload PlayerFinishedPayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	case TypeGameOver:
+		var payload GameOverPayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	case TypeBerserked:
+		var payload BerserkedPayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	case TypeLatency:
+		var payload LatencyPayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	case TypeError:
+		var payload ErrorPayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	case TypeAck:
+		var payload AckPayload
+		err = json.Unmarshal(data, &payload)
+		return payload, err
+	}
+	return data, nil
 }
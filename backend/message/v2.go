@@ -0,0 +1,67 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/coder/websocket"
+)
+
+// Message versions spoken on a connection, depending on the subprotocol it
+// negotiated.
+//
+// v2 adds to v1:
+//   - request ids: a client may set Id on the messages it sends, the server
+//     answers each of them with an ack, or a nack carrying the error, with
+//     the same id. A request forwarded to the node owning its lobby is
+//     answered by that node.
+//   - sequence numbers: messages about a lobby carry a Seq increasing by one
+//     with each of them, so clients notice messages they missed. foundGame
+//     carries the Seq of the last message its snapshot includes, or none if
+//     it is not known yet, in which case the next message sets it. A client
+//     reconnecting with resume=lobbyId:seq,... in the query gets the
+//     messages about those games it missed instead of foundGame, as long as
+//     the lobby still has them.
+const (
+	V1 = v1
+	V2 = "v2"
+)
+
+func NewV2[T any](typ string, payload T, id string, seq uint64) (Message, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Nil, err
+	}
+	return Message{Version: V2, Type: typ, Id: id, Seq: seq, Payload: data}, nil
+}
+
+// TypeAck tells a json.v2 client that the request named by the message's id
+// was handled. json.v1 clients get no acks.
+const TypeAck = "ack"
+
+type AckPayload struct{}
+
+// TypeNack tells a json.v2 client that the request named by the message's id
+// failed. It replaces the error message of json.v1.
+const TypeNack = "nack"
+
+type NackPayload struct {
+	Code websocket.StatusCode `json:"code"`
+	Err  string               `json:"err,omitempty"`
+}
+
+type requestIdKey struct{}
+
+// WithRequestId returns a context carrying the id of the request being
+// handled, so that requests forwarded to other nodes keep it.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId returns the id of the request handled with ctx, if any.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE game_position
+(
+    game_id uuid REFERENCES game (id) NOT NULL,
+    ply     int                       NOT NULL,
+    hash    bigint                    NOT NULL,
+    PRIMARY KEY (game_id, ply)
+);
+
+CREATE INDEX game_position_hash_idx ON game_position (hash);
+
+-- +goose Down
+DROP INDEX IF EXISTS game_position_hash_idx;
+DROP TABLE IF EXISTS game_position;
@@ -0,0 +1,50 @@
+-- +goose Up
+ALTER TABLE game
+    ADD COLUMN moves text NOT NULL DEFAULT '',
+    ADD COLUMN puzzles_scanned bool NOT NULL DEFAULT false;
+
+ALTER TABLE users
+    ADD COLUMN puzzle_rating int NOT NULL DEFAULT 1500;
+
+CREATE TABLE puzzle
+(
+    id             uuid PRIMARY KEY,
+    game_id        uuid REFERENCES game (id) NOT NULL,
+    ply            int                       NOT NULL,
+    state          text                      NOT NULL,
+    to_move        smallint                  NOT NULL,
+    win_in         int                       NOT NULL,
+    solution       text                      NOT NULL,
+    rating         int                       NOT NULL DEFAULT 1500,
+    attempts       int                       NOT NULL DEFAULT 0,
+    created_at_utc timestamptz               NOT NULL,
+    UNIQUE (game_id, ply)
+);
+
+CREATE INDEX puzzle_rating_idx ON puzzle (rating);
+
+CREATE TABLE puzzle_attempt
+(
+    user_id          uuid REFERENCES users (id)  NOT NULL,
+    puzzle_id        uuid REFERENCES puzzle (id) NOT NULL,
+    solved           bool                        NOT NULL,
+    attempted_at_utc timestamptz                 NOT NULL,
+    PRIMARY KEY (user_id, puzzle_id)
+);
+
+CREATE TABLE daily_puzzle
+(
+    day_utc   timestamptz PRIMARY KEY,
+    puzzle_id uuid REFERENCES puzzle (id) NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS daily_puzzle;
+DROP TABLE IF EXISTS puzzle_attempt;
+DROP INDEX IF EXISTS puzzle_rating_idx;
+DROP TABLE IF EXISTS puzzle;
+ALTER TABLE users
+    DROP COLUMN puzzle_rating;
+ALTER TABLE game
+    DROP COLUMN puzzles_scanned,
+    DROP COLUMN moves;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE game
+    ADD COLUMN start_state   text     NOT NULL DEFAULT '000000000000000000000000000000000000000000',
+    ADD COLUMN start_to_move smallint NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE game
+    DROP COLUMN start_to_move,
+    DROP COLUMN start_state;
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE game
+    ADD COLUMN red_player_id uuid REFERENCES users (id);
+
+ALTER TABLE users
+    ADD COLUMN rating int NOT NULL DEFAULT 1500;
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN rating;
+ALTER TABLE game
+    DROP COLUMN red_player_id;
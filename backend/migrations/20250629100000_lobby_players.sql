@@ -0,0 +1,53 @@
+-- +goose Up
+CREATE TABLE lobby_player
+(
+    lobby_id  uuid REFERENCES lobby (id) NOT NULL,
+    player_id uuid REFERENCES users (id) NOT NULL,
+    color     smallint                   NOT NULL,
+    PRIMARY KEY (lobby_id, player_id)
+);
+
+INSERT INTO lobby_player (lobby_id, player_id, color)
+SELECT l.id,
+       l.player_1_id,
+       CASE
+           WHEN (SELECT g.red_player_id FROM game g WHERE g.lobby_id = l.id LIMIT 1) = l.player_2_id THEN 2
+           ELSE 1
+           END
+FROM lobby l
+UNION ALL
+SELECT l.id,
+       l.player_2_id,
+       CASE
+           WHEN (SELECT g.red_player_id FROM game g WHERE g.lobby_id = l.id LIMIT 1) = l.player_2_id THEN 1
+           ELSE 2
+           END
+FROM lobby l;
+
+ALTER TABLE lobby
+    DROP COLUMN player_1_id,
+    DROP COLUMN player_2_id,
+    ADD COLUMN variant text NOT NULL DEFAULT 'standard';
+
+ALTER TABLE game
+    DROP COLUMN red_player_id,
+    ADD COLUMN variant text NOT NULL DEFAULT 'standard';
+
+-- +goose Down
+ALTER TABLE game
+    DROP COLUMN variant,
+    ADD COLUMN red_player_id uuid REFERENCES users (id);
+
+ALTER TABLE lobby
+    DROP COLUMN variant,
+    ADD COLUMN player_1_id uuid REFERENCES users (id),
+    ADD COLUMN player_2_id uuid REFERENCES users (id);
+
+UPDATE lobby l
+SET player_1_id = (SELECT lp.player_id FROM lobby_player lp WHERE lp.lobby_id = l.id AND lp.color = 1),
+    player_2_id = (SELECT lp.player_id FROM lobby_player lp WHERE lp.lobby_id = l.id AND lp.color = 2);
+
+UPDATE game g
+SET red_player_id = (SELECT lp.player_id FROM lobby_player lp WHERE lp.lobby_id = g.lobby_id AND lp.color = 1);
+
+DROP TABLE IF EXISTS lobby_player;
@@ -0,0 +1,8 @@
+-- +goose Up
+CREATE INDEX game_unfinished_idx ON game (lobby_id) WHERE ended_at_utc IS NULL;
+
+CREATE INDEX message_lobby_idx ON message (lobby_id, sent_at_utc);
+
+-- +goose Down
+DROP INDEX IF EXISTS message_lobby_idx;
+DROP INDEX IF EXISTS game_unfinished_idx;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE game
+    ADD COLUMN node text NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE game
+    DROP COLUMN node;
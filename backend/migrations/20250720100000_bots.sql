@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN is_bot boolean NOT NULL DEFAULT false;
+
+INSERT INTO users (id, username, email, password, created_at_utc, is_bot)
+VALUES ('00000000-0000-0000-0000-000000000b07', 'connect4-bot', 'bot@connect4.invalid', '', now(), true);
+
+ALTER TABLE game
+    ADD COLUMN bot_level smallint NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE game
+    DROP COLUMN bot_level;
+
+DELETE
+FROM lobby_player
+WHERE player_id = '00000000-0000-0000-0000-000000000b07';
+
+DELETE
+FROM users
+WHERE id = '00000000-0000-0000-0000-000000000b07';
+
+ALTER TABLE users
+    DROP COLUMN is_bot;
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE challenge
+(
+    id                uuid PRIMARY KEY,
+    challenger_id     uuid REFERENCES users (id) NOT NULL,
+    challenged_id     uuid REFERENCES users (id) NOT NULL,
+    variant           text                       NOT NULL,
+    initial_seconds   int                        NOT NULL DEFAULT 0,
+    increment_seconds int                        NOT NULL DEFAULT 0,
+    color             smallint                   NOT NULL DEFAULT 0,
+    status            text                       NOT NULL DEFAULT 'pending',
+    lobby_id          uuid REFERENCES lobby (id),
+    created_at_utc    timestamptz                NOT NULL,
+    expires_at_utc    timestamptz                NOT NULL
+);
+
+CREATE INDEX challenge_challenger_idx ON challenge (challenger_id, status);
+CREATE INDEX challenge_challenged_idx ON challenge (challenged_id, status);
+
+-- +goose Down
+DROP INDEX IF EXISTS challenge_challenged_idx;
+DROP INDEX IF EXISTS challenge_challenger_idx;
+DROP TABLE IF EXISTS challenge;
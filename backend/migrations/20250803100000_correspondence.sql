@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE game
+    ADD COLUMN days_per_move     smallint NOT NULL DEFAULT 0,
+    ADD COLUMN move_deadline_utc timestamptz,
+    ADD COLUMN winner            smallint NOT NULL DEFAULT 0;
+
+CREATE INDEX game_move_deadline_idx ON game (move_deadline_utc)
+    WHERE ended_at_utc IS NULL AND days_per_move > 0;
+
+ALTER TABLE challenge
+    ADD COLUMN days_per_move smallint NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE challenge
+    DROP COLUMN days_per_move;
+
+DROP INDEX IF EXISTS game_move_deadline_idx;
+
+ALTER TABLE game
+    DROP COLUMN winner,
+    DROP COLUMN move_deadline_utc,
+    DROP COLUMN days_per_move;
@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE tournament
+(
+    id                uuid PRIMARY KEY,
+    name              text                       NOT NULL,
+    format            text                       NOT NULL,
+    variant           text                       NOT NULL,
+    initial_seconds   int                        NOT NULL DEFAULT 0,
+    increment_seconds int                        NOT NULL DEFAULT 0,
+    rounds            int                        NOT NULL DEFAULT 0,
+    round             int                        NOT NULL DEFAULT 0,
+    status            text                       NOT NULL DEFAULT 'pending',
+    created_by        uuid REFERENCES users (id) NOT NULL,
+    created_at_utc    timestamptz                NOT NULL,
+    started_at_utc    timestamptz,
+    ended_at_utc      timestamptz
+);
+
+CREATE INDEX tournament_status_idx ON tournament (status);
+
+CREATE TABLE tournament_player
+(
+    tournament_id uuid REFERENCES tournament (id) NOT NULL,
+    player_id     uuid REFERENCES users (id)      NOT NULL,
+    rating        int                             NOT NULL,
+    joined_at_utc timestamptz                     NOT NULL,
+    PRIMARY KEY (tournament_id, player_id)
+);
+
+CREATE TABLE tournament_game
+(
+    id            uuid PRIMARY KEY,
+    tournament_id uuid REFERENCES tournament (id) NOT NULL,
+    round         int                             NOT NULL,
+    red_id        uuid REFERENCES users (id)      NOT NULL,
+    yellow_id     uuid REFERENCES users (id),
+    lobby_id      uuid REFERENCES lobby (id)
+);
+
+CREATE INDEX tournament_game_tournament_idx ON tournament_game (tournament_id, round);
+
+-- +goose Down
+DROP INDEX IF EXISTS tournament_game_tournament_idx;
+DROP TABLE IF EXISTS tournament_game;
+DROP TABLE IF EXISTS tournament_player;
+DROP INDEX IF EXISTS tournament_status_idx;
+DROP TABLE IF EXISTS tournament;
@@ -0,0 +1,32 @@
+-- +goose Up
+ALTER TABLE tournament
+    ADD COLUMN minutes int NOT NULL DEFAULT 0;
+
+ALTER TABLE tournament_player
+    ADD COLUMN score int NOT NULL DEFAULT 0,
+    ADD COLUMN rank  int NOT NULL DEFAULT 0;
+
+ALTER TABLE game
+    ADD COLUMN tournament_id uuid REFERENCES tournament (id);
+
+CREATE INDEX game_tournament_idx ON game (tournament_id)
+    WHERE tournament_id IS NOT NULL;
+
+ALTER TABLE lobby_player
+    ADD COLUMN berserk boolean NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE lobby_player
+    DROP COLUMN berserk;
+
+DROP INDEX IF EXISTS game_tournament_idx;
+
+ALTER TABLE game
+    DROP COLUMN tournament_id;
+
+ALTER TABLE tournament_player
+    DROP COLUMN rank,
+    DROP COLUMN score;
+
+ALTER TABLE tournament
+    DROP COLUMN minutes;
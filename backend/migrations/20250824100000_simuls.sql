@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE simul
+(
+    id             uuid PRIMARY KEY,
+    name           text                       NOT NULL,
+    host_id        uuid REFERENCES users (id) NOT NULL,
+    variant        text                       NOT NULL,
+    boards         int                        NOT NULL,
+    host_color     smallint                   NOT NULL DEFAULT 0,
+    created_at_utc timestamptz                NOT NULL,
+    started_at_utc timestamptz
+);
+
+CREATE TABLE simul_player
+(
+    simul_id      uuid REFERENCES simul (id) NOT NULL,
+    player_id     uuid REFERENCES users (id) NOT NULL,
+    joined_at_utc timestamptz                NOT NULL,
+    lobby_id      uuid REFERENCES lobby (id),
+    PRIMARY KEY (simul_id, player_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS simul_player;
+DROP TABLE IF EXISTS simul;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE websocket_ticket
+(
+    ticket         text PRIMARY KEY,
+    user_id        uuid REFERENCES users (id) NOT NULL,
+    expires_at_utc timestamptz                NOT NULL
+);
+
+CREATE INDEX websocket_ticket_expires_idx ON websocket_ticket (expires_at_utc);
+
+-- +goose Down
+DROP INDEX IF EXISTS websocket_ticket_expires_idx;
+DROP TABLE IF EXISTS websocket_ticket;
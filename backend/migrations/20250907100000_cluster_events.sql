@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE cluster_event
+(
+    id             uuid PRIMARY KEY,
+    payload        text        NOT NULL,
+    created_at_utc timestamptz NOT NULL
+);
+
+CREATE INDEX cluster_event_created_idx ON cluster_event (created_at_utc);
+
+-- +goose Down
+DROP INDEX IF EXISTS cluster_event_created_idx;
+DROP TABLE IF EXISTS cluster_event;
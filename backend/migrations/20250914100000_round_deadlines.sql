@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE tournament
+    ADD COLUMN round_started_at_utc timestamptz;
+
+UPDATE tournament
+SET round_started_at_utc = started_at_utc
+WHERE status = 'running';
+
+-- the lobby of a game is recorded before it is started, so that a crash in
+-- between cannot leave a second lobby for the same game
+ALTER TABLE tournament_game
+    DROP CONSTRAINT IF EXISTS tournament_game_lobby_id_fkey;
+
+-- +goose Down
+ALTER TABLE tournament_game
+    ADD CONSTRAINT tournament_game_lobby_id_fkey FOREIGN KEY (lobby_id) REFERENCES lobby (id);
+
+ALTER TABLE tournament
+    DROP COLUMN IF EXISTS round_started_at_utc;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- the lobby of a board is recorded before it is started, so that a crash in
+-- between cannot leave a second lobby for the same board
+ALTER TABLE simul_player
+    DROP CONSTRAINT IF EXISTS simul_player_lobby_id_fkey;
+
+-- +goose Down
+ALTER TABLE simul_player
+    ADD CONSTRAINT simul_player_lobby_id_fkey FOREIGN KEY (lobby_id) REFERENCES lobby (id);
@@ -0,0 +1,18 @@
+-- +goose Up
+-- games are untimed, time controls were stored but never played with
+ALTER TABLE challenge
+    DROP COLUMN IF EXISTS initial_seconds,
+    DROP COLUMN IF EXISTS increment_seconds;
+
+ALTER TABLE tournament
+    DROP COLUMN IF EXISTS initial_seconds,
+    DROP COLUMN IF EXISTS increment_seconds;
+
+-- +goose Down
+ALTER TABLE tournament
+    ADD COLUMN initial_seconds   int NOT NULL DEFAULT 0,
+    ADD COLUMN increment_seconds int NOT NULL DEFAULT 0;
+
+ALTER TABLE challenge
+    ADD COLUMN initial_seconds   int NOT NULL DEFAULT 0,
+    ADD COLUMN increment_seconds int NOT NULL DEFAULT 0;
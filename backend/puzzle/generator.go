@@ -0,0 +1,144 @@
+package puzzle
+
+import (
+	"backend/config"
+	"backend/game"
+	"backend/generated/sqlc"
+	"context"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"time"
+)
+
+type Generator struct {
+	db     *sqlc.Queries
+	cfg    config.PuzzlesConfig
+	logger echo.Logger
+}
+
+func NewGenerator(db *sqlc.Queries, cfg config.PuzzlesConfig, logger echo.Logger) *Generator {
+	return &Generator{
+		db:     db,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// Run periodically scans finished games that have not been scanned yet and
+// stores every position with a unique forced win as a puzzle.
+func (g *Generator) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.cfg.ScanInterval)
+	defer ticker.Stop()
+	for {
+		if err := g.scan(ctx); err != nil {
+			g.logger.Errorf("puzzle scan failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *Generator) scan(ctx context.Context) error {
+	games, err := g.db.GetUnscannedGames(ctx, g.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range games {
+		moves, err := game.ParseMoves(row.Moves)
+		if err != nil {
+			g.logger.Warnf("skipping game %v: %v", row.ID, err)
+		} else if start, err := game.ParseBoard(row.StartState); err != nil {
+			g.logger.Warnf("skipping game %v: %v", row.ID, err)
+		} else if err = g.extract(ctx, row.ID, *start, game.Color(row.StartToMove), moves); err != nil {
+			return err
+		}
+		if err = g.db.MarkGamePuzzlesScanned(ctx, row.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// found is a position of a game with a unique forced win.
+type found struct {
+	ply      int
+	board    game.Board
+	toMove   game.Color
+	winIn    int
+	solution []uint8
+}
+
+func (g *Generator) extract(
+	ctx context.Context,
+	gameId uuid.UUID,
+	board game.Board,
+	color game.Color,
+	moves []uint8,
+) error {
+	for _, f := range g.find(board, color, moves) {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		err = g.db.CreatePuzzle(
+			ctx, sqlc.CreatePuzzleParams{
+				ID:           id,
+				GameID:       gameId,
+				Ply:          int32(f.ply),
+				State:        f.board.StrState(),
+				ToMove:       int16(f.toMove),
+				WinIn:        int32(f.winIn),
+				Solution:     game.StrLine(f.solution),
+				Rating:       InitialRating(f.winIn),
+				CreatedAtUtc: time.Now().UTC(),
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// find replays a game from its start and returns the positions worth a
+// puzzle: those where the side to move wins by force in exactly one way.
+func (g *Generator) find(board game.Board, color game.Color, moves []uint8) []found {
+	var puzzles []found
+	skipUntil := 0
+	for ply := 0; ply < len(moves); ply++ {
+		n := 0
+		if ply >= skipUntil {
+			n = game.WinIn(board, color, g.cfg.MaxWinIn)
+		}
+		if n >= g.cfg.MinWinIn && len(game.WinningMoves(board, color, n)) == 1 {
+			puzzles = append(
+				puzzles, found{
+					ply:      ply,
+					board:    board,
+					toMove:   color,
+					winIn:    n,
+					solution: game.SolutionLine(board, color, n),
+				},
+			)
+			// later positions of the same forced win are just shorter
+			// versions of this puzzle
+			skipUntil = ply + 2*n
+		}
+
+		board, _ = game.ApplyLine(board, color, moves[ply:ply+1])
+		color = color.Opponent()
+	}
+
+	return puzzles
+}
+
+// InitialRating seeds a puzzle's rating from the length of its forced win.
+func InitialRating(winIn int) int32 {
+	return int32(1000 + 200*winIn)
+}
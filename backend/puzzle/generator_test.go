@@ -0,0 +1,97 @@
+package puzzle
+
+import (
+	"backend/config"
+	"backend/game"
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name     string
+		moves    string
+		minWinIn int
+		maxWinIn int
+		want     []found
+	}{
+		{
+			// red builds 0, 1, 2 on the bottom row and has a single win in 3
+			name: "win in 1", moves: "0616253", minWinIn: 1, maxWinIn: 1,
+			want: []found{{ply: 6, toMove: game.ColorRed, winIn: 1, solution: []uint8{3}}},
+		},
+		{
+			name: "win in 1 below the minimum", moves: "0616253", minWinIn: 2, maxWinIn: 4,
+		},
+		{
+			// 2 wins in 2 and the game goes on with its solution, the win in
+			// 1 that follows is the same puzzle
+			name: "win in 2", moves: "3311204", minWinIn: 1, maxWinIn: 2,
+			want: []found{{ply: 4, toMove: game.ColorRed, winIn: 2, solution: []uint8{2, 0, 4}}},
+		},
+		{
+			name: "win in 2 beyond the maximum", moves: "3311204", minWinIn: 2, maxWinIn: 1,
+		},
+		{
+			// both 1 and 4 win in 2, and red wins in 1 two moves later
+			name: "several solutions", moves: "2233415", minWinIn: 1, maxWinIn: 2,
+			want: []found{{ply: 6, toMove: game.ColorRed, winIn: 1, solution: []uint8{5}}},
+		},
+		{
+			name: "no forced win", moves: "3232", minWinIn: 1, maxWinIn: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moves, err := game.ParseMoves(tt.moves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := &Generator{cfg: config.PuzzlesConfig{MinWinIn: tt.minWinIn, MaxWinIn: tt.maxWinIn}}
+			for i := range tt.want {
+				tt.want[i].board, _, err = game.PositionFromMoves(moves[:tt.want[i].ply])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := g.find(game.Board{}, game.ColorRed, moves)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("find(%s) = %+v, want %+v", tt.moves, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFindSolutions checks that the puzzles found in random games are solved
+// by their own solution and by nothing shorter.
+func TestFindSolutions(t *testing.T) {
+	g := &Generator{cfg: config.PuzzlesConfig{MinWinIn: 1, MaxWinIn: 3}}
+	games := []string{
+		"3311204", "40122336104", "663515204", "2533104", "33221100445566",
+		"3344225511660", "0123456012345601234560",
+	}
+	for _, line := range games {
+		moves, err := game.ParseMoves(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range g.find(game.Board{}, game.ColorRed, moves) {
+			if len(p.solution) != 2*p.winIn-1 {
+				t.Errorf("%s ply %d: solution %v for a win in %d", line, p.ply, p.solution, p.winIn)
+			}
+			if r := game.CheckLine(p.board, p.toMove, p.winIn, p.solution); r != game.LineSolved {
+				t.Errorf("%s ply %d: solution %v judged %d", line, p.ply, p.solution, r)
+			}
+			if game.WinIn(p.board, p.toMove, p.winIn) != p.winIn {
+				t.Errorf("%s ply %d: a shorter win than %d exists", line, p.ply, p.winIn)
+			}
+		}
+	}
+}
+
+func TestInitialRating(t *testing.T) {
+	if InitialRating(2) >= InitialRating(3) {
+		t.Error("longer wins do not start with a higher rating")
+	}
+}
@@ -0,0 +1,22 @@
+-- name: CreateChallenge :exec
+INSERT INTO challenge (id, challenger_id, challenged_id, variant, color, days_per_move, created_at_utc, expires_at_utc)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: AnswerChallenge :execrows
+UPDATE challenge
+SET status = sqlc.arg(status)
+WHERE id = sqlc.arg(id)
+  AND status = 'pending'
+  AND expires_at_utc > sqlc.arg(now);
+
+-- name: ReopenChallenge :exec
+UPDATE challenge
+SET status = 'pending'
+WHERE id = $1
+  AND status = 'accepted'
+  AND lobby_id IS NULL;
+
+-- name: SetChallengeLobby :exec
+UPDATE challenge
+SET lobby_id = $2
+WHERE id = $1;
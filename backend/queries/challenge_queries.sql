@@ -0,0 +1,17 @@
+-- name: GetChallengeById :one
+SELECT *
+FROM challenge
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetPendingChallenges :many
+SELECT c.*,
+       challenger.username AS challenger_username,
+       challenged.username AS challenged_username
+FROM challenge c
+         JOIN users challenger ON challenger.id = c.challenger_id
+         JOIN users challenged ON challenged.id = c.challenged_id
+WHERE (c.challenger_id = sqlc.arg(player_id) OR c.challenged_id = sqlc.arg(player_id))
+  AND c.status = 'pending'
+  AND c.expires_at_utc > sqlc.arg(now)
+ORDER BY c.created_at_utc;
@@ -0,0 +1,19 @@
+-- name: Notify :exec
+SELECT pg_notify(sqlc.arg(channel)::text, sqlc.arg(payload)::text);
+
+-- name: TryAdvisoryLock :one
+SELECT pg_try_advisory_lock($1);
+
+-- name: CreateClusterEvent :exec
+INSERT INTO cluster_event (id, payload, created_at_utc)
+VALUES ($1, $2, $3);
+
+-- name: GetClusterEvent :one
+SELECT payload
+FROM cluster_event
+WHERE id = $1;
+
+-- name: DeleteClusterEvents :exec
+DELETE
+FROM cluster_event
+WHERE created_at_utc < $1;
@@ -0,0 +1,24 @@
+-- name: CreateCorrespondenceGame :exec
+INSERT INTO game (id, lobby_id, started_at_utc, state, moves, start_state, start_to_move, variant, days_per_move,
+                  move_deadline_utc)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: PlayCorrespondenceMove :execrows
+UPDATE game
+SET state             = sqlc.arg(state),
+    moves             = sqlc.arg(moves),
+    move_deadline_utc = sqlc.arg(move_deadline_utc)
+WHERE id = sqlc.arg(id)
+  AND moves = sqlc.arg(previous_moves)
+  AND ended_at_utc IS NULL;
+
+-- name: FinishCorrespondenceGame :execrows
+UPDATE game
+SET state             = sqlc.arg(state),
+    moves             = sqlc.arg(moves),
+    winner            = sqlc.arg(winner),
+    ended_at_utc      = sqlc.arg(ended_at_utc),
+    move_deadline_utc = NULL
+WHERE id = sqlc.arg(id)
+  AND moves = sqlc.arg(previous_moves)
+  AND ended_at_utc IS NULL;
@@ -0,0 +1,42 @@
+-- name: GetCorrespondenceGame :one
+SELECT id,
+       lobby_id,
+       moves,
+       start_state,
+       start_to_move,
+       variant,
+       days_per_move,
+       move_deadline_utc,
+       ended_at_utc,
+       winner
+FROM game
+WHERE id = $1
+  AND days_per_move > 0
+LIMIT 1;
+
+-- name: GetCorrespondenceGamesByPlayer :many
+SELECT g.id,
+       g.state,
+       g.moves,
+       g.start_to_move,
+       g.variant,
+       g.days_per_move,
+       g.move_deadline_utc,
+       lp.color,
+       opponent.username AS opponent
+FROM game g
+         JOIN lobby_player lp ON lp.lobby_id = g.lobby_id AND lp.player_id = sqlc.arg(player_id)
+         JOIN lobby_player olp ON olp.lobby_id = g.lobby_id AND olp.player_id != sqlc.arg(player_id)
+         JOIN users opponent ON opponent.id = olp.player_id
+WHERE g.days_per_move > 0
+  AND g.ended_at_utc IS NULL
+ORDER BY g.move_deadline_utc;
+
+-- name: GetExpiredCorrespondenceGames :many
+SELECT id
+FROM game
+WHERE days_per_move > 0
+  AND ended_at_utc IS NULL
+  AND move_deadline_utc < $1
+ORDER BY move_deadline_utc
+LIMIT $2;
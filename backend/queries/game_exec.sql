@@ -1,3 +1,27 @@
 -- name: CreateGame :exec
-INSERT INTO game (id, lobby_id, started_at_utc, ended_at_utc, state)
-VALUES ($1, $2, $3, $4, $5);
+INSERT INTO game (id, lobby_id, started_at_utc, ended_at_utc, state, moves, start_state, start_to_move, variant, node,
+                  bot_level, tournament_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);
+
+-- name: CreateGamePositions :copyfrom
+INSERT INTO game_position (game_id, ply, hash)
+VALUES ($1, $2, $3);
+
+-- name: MarkGamePuzzlesScanned :exec
+UPDATE game
+SET puzzles_scanned = true
+WHERE id = $1;
+
+-- name: UpdateGameProgress :exec
+UPDATE game
+SET state = $2,
+    moves = $3
+WHERE id = $1;
+
+-- name: FinishGame :exec
+UPDATE game
+SET state        = $2,
+    moves        = $3,
+    winner       = $4,
+    ended_at_utc = $5
+WHERE id = $1;
@@ -0,0 +1,66 @@
+-- name: GetGamesByPosition :many
+SELECT g.id, g.lobby_id, g.started_at_utc, g.ended_at_utc, g.state, gp.ply
+FROM game_position gp
+         JOIN game g ON g.id = gp.game_id
+WHERE gp.hash = $1
+ORDER BY g.ended_at_utc DESC
+LIMIT $2;
+
+-- name: GetUnscannedGames :many
+SELECT id, moves, start_state, start_to_move
+FROM game
+WHERE puzzles_scanned = false
+  AND ended_at_utc IS NOT NULL
+  AND variant = 'standard'
+  AND moves != ''
+ORDER BY ended_at_utc
+LIMIT $1;
+
+-- name: GetRecentColorHistory :one
+SELECT count(*) FILTER (WHERE recent.color = 1)::int AS red_games,
+       count(*)::int                                 AS games
+FROM (SELECT lp.color
+      FROM lobby_player lp
+               JOIN game g ON g.lobby_id = lp.lobby_id
+      WHERE lp.player_id = $1
+        AND g.variant = 'standard'
+      ORDER BY g.ended_at_utc DESC
+      LIMIT $2) recent;
+
+-- name: GetUnfinishedGames :many
+SELECT g.id,
+       g.lobby_id,
+       g.moves,
+       g.start_state,
+       g.start_to_move,
+       g.variant,
+       g.node,
+       g.bot_level,
+       g.tournament_id,
+       l.is_private,
+       l.created_at_utc AS lobby_created_at_utc
+FROM game g
+         JOIN lobby l ON l.id = g.lobby_id
+WHERE g.ended_at_utc IS NULL
+  AND g.days_per_move = 0
+  AND g.node = $1;
+
+-- name: GetUnfinishedGamesByPlayer :many
+SELECT g.id,
+       g.lobby_id,
+       g.moves,
+       g.start_state,
+       g.start_to_move,
+       g.variant,
+       g.node,
+       g.bot_level,
+       g.tournament_id,
+       l.is_private,
+       l.created_at_utc AS lobby_created_at_utc
+FROM game g
+         JOIN lobby l ON l.id = g.lobby_id
+         JOIN lobby_player lp ON lp.lobby_id = l.id
+WHERE g.ended_at_utc IS NULL
+  AND g.days_per_move = 0
+  AND lp.player_id = $1
+ORDER BY g.started_at_utc DESC;
@@ -1,3 +1,13 @@
 -- name: CreateLobby :exec
-INSERT INTO lobby (id, player_1_id, player_2_id, created_at_utc, is_private)
-VALUES ($1, $2, $3, $4, false);
+INSERT INTO lobby (id, created_at_utc, is_private, variant)
+VALUES ($1, $2, $3, $4);
+
+-- name: CreateLobbyPlayers :copyfrom
+INSERT INTO lobby_player (lobby_id, player_id, color)
+VALUES ($1, $2, $3);
+
+-- name: SetLobbyPlayerBerserk :exec
+UPDATE lobby_player
+SET berserk = true
+WHERE lobby_id = $1
+  AND player_id = $2;
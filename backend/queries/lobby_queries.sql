@@ -1,13 +1,21 @@
 -- name: GetFirstFreeLobby :one
-SELECT id
-FROM lobby
-WHERE player_1_id != $1
-  AND player_2_id IS NULL
-  AND is_private = false
+SELECT l.id
+FROM lobby l
+WHERE l.is_private = false
+  AND NOT EXISTS (SELECT 1
+                  FROM lobby_player lp
+                  WHERE lp.lobby_id = l.id
+                    AND lp.player_id = $1)
+  AND (SELECT count(*) FROM lobby_player lp WHERE lp.lobby_id = l.id) < 2
 LIMIT 1;
 
 -- name: GetLobbyById :one
 SELECT id
 FROM lobby
 WHERE id = $1
-LIMIT 1;
\ No newline at end of file
+LIMIT 1;
+
+-- name: GetLobbyPlayers :many
+SELECT player_id, color, berserk
+FROM lobby_player
+WHERE lobby_id = $1;
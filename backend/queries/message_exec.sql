@@ -0,0 +1,3 @@
+-- name: CreateMessage :exec
+INSERT INTO message (id, lobby_id, sender_id, content, sent_at_utc)
+VALUES ($1, $2, $3, $4, $5);
@@ -0,0 +1,6 @@
+-- name: GetLobbyMessages :many
+SELECT u.username, m.content
+FROM message m
+         JOIN users u ON u.id = m.sender_id
+WHERE m.lobby_id = $1
+ORDER BY m.sent_at_utc;
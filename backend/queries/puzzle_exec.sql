@@ -0,0 +1,23 @@
+-- name: CreatePuzzle :exec
+INSERT INTO puzzle (id, game_id, ply, state, to_move, win_in, solution, rating, created_at_utc)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (game_id, ply) DO NOTHING;
+
+-- name: UpdatePuzzleRating :exec
+UPDATE puzzle
+SET rating   = $2,
+    attempts = attempts + 1
+WHERE id = $1;
+
+-- name: CreatePuzzleAttempt :execrows
+INSERT INTO puzzle_attempt (user_id, puzzle_id, solved, attempted_at_utc)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, puzzle_id) DO NOTHING;
+
+-- name: CreateDailyPuzzle :exec
+INSERT INTO daily_puzzle (day_utc, puzzle_id)
+SELECT $1, id
+FROM puzzle
+ORDER BY random()
+LIMIT 1
+ON CONFLICT (day_utc) DO NOTHING;
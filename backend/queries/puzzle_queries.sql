@@ -0,0 +1,22 @@
+-- name: GetPuzzleById :one
+SELECT *
+FROM puzzle
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetNextPuzzle :one
+SELECT p.*
+FROM puzzle p
+WHERE NOT EXISTS (SELECT 1
+                  FROM puzzle_attempt pa
+                  WHERE pa.puzzle_id = p.id
+                    AND pa.user_id = sqlc.arg(user_id))
+ORDER BY abs(p.rating - sqlc.arg(rating)::int)
+LIMIT 1;
+
+-- name: GetDailyPuzzle :one
+SELECT p.*
+FROM daily_puzzle dp
+         JOIN puzzle p ON p.id = dp.puzzle_id
+WHERE dp.day_utc = $1
+LIMIT 1;
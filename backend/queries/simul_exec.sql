@@ -0,0 +1,34 @@
+-- name: CreateSimul :exec
+INSERT INTO simul (id, name, host_id, variant, boards, host_color, created_at_utc)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: JoinSimul :execrows
+INSERT INTO simul_player (simul_id, player_id, joined_at_utc)
+SELECT s.id, sqlc.arg(player_id), sqlc.arg(joined_at_utc)
+FROM simul s
+WHERE s.id = sqlc.arg(simul_id)
+  AND s.started_at_utc IS NULL
+  AND s.host_id <> sqlc.arg(player_id)
+  AND (SELECT count(*) FROM simul_player sp WHERE sp.simul_id = s.id) < s.boards
+ON CONFLICT DO NOTHING;
+
+-- name: LeaveSimul :execrows
+DELETE
+FROM simul_player sp
+    USING simul s
+WHERE s.id = sp.simul_id
+  AND sp.simul_id = sqlc.arg(simul_id)
+  AND sp.player_id = sqlc.arg(player_id)
+  AND s.started_at_utc IS NULL;
+
+-- name: StartSimul :execrows
+UPDATE simul
+SET started_at_utc = sqlc.arg(started_at_utc)
+WHERE id = sqlc.arg(id)
+  AND started_at_utc IS NULL;
+
+-- name: SetSimulPlayerLobby :exec
+UPDATE simul_player
+SET lobby_id = $3
+WHERE simul_id = $1
+  AND player_id = $2;
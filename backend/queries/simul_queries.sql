@@ -0,0 +1,36 @@
+-- name: GetSimulById :one
+SELECT *
+FROM simul
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetSimuls :many
+SELECT s.*,
+       u.username                                                                 AS host,
+       (SELECT count(*) FROM simul_player sp WHERE sp.simul_id = s.id)            AS players,
+       EXISTS (SELECT 1
+               FROM simul_player sp
+                        LEFT JOIN game g ON g.lobby_id = sp.lobby_id
+               WHERE sp.simul_id = s.id
+                 AND g.ended_at_utc IS NULL)                                      AS playing
+FROM simul s
+         JOIN users u ON u.id = s.host_id
+ORDER BY s.created_at_utc DESC
+LIMIT $1;
+
+-- name: GetSimulPlayers :many
+SELECT sp.player_id,
+       u.username,
+       u.rating,
+       sp.lobby_id,
+       lp.color AS host_color,
+       g.id     AS game_id,
+       g.ended_at_utc,
+       g.winner
+FROM simul_player sp
+         JOIN simul s ON s.id = sp.simul_id
+         JOIN users u ON u.id = sp.player_id
+         LEFT JOIN lobby_player lp ON lp.lobby_id = sp.lobby_id AND lp.player_id = s.host_id
+         LEFT JOIN game g ON g.lobby_id = sp.lobby_id
+WHERE sp.simul_id = $1
+ORDER BY sp.joined_at_utc, sp.player_id;
@@ -0,0 +1,17 @@
+-- name: CreateTicket :exec
+INSERT INTO websocket_ticket (ticket, user_id, expires_at_utc)
+VALUES ($1, $2, $3);
+
+-- name: RedeemTicket :one
+DELETE
+FROM websocket_ticket t
+    USING users u
+WHERE u.id = t.user_id
+  AND t.ticket = sqlc.arg(ticket)
+  AND t.expires_at_utc > sqlc.arg(now)
+RETURNING u.id, u.username;
+
+-- name: DeleteExpiredTickets :exec
+DELETE
+FROM websocket_ticket
+WHERE expires_at_utc <= $1;
@@ -0,0 +1,56 @@
+-- name: CreateTournament :exec
+INSERT INTO tournament (id, name, format, variant, rounds, minutes, created_by, created_at_utc)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: JoinTournament :execrows
+INSERT INTO tournament_player (tournament_id, player_id, rating, joined_at_utc)
+SELECT t.id, sqlc.arg(player_id), sqlc.arg(rating), sqlc.arg(joined_at_utc)
+FROM tournament t
+WHERE t.id = sqlc.arg(tournament_id)
+  AND (t.status = 'pending' OR (t.format = 'arena' AND t.status = 'running'))
+ON CONFLICT DO NOTHING;
+
+-- name: LeaveTournament :execrows
+DELETE
+FROM tournament_player tp
+    USING tournament t
+WHERE t.id = tp.tournament_id
+  AND tp.tournament_id = sqlc.arg(tournament_id)
+  AND tp.player_id = sqlc.arg(player_id)
+  AND t.status = 'pending';
+
+-- name: StartTournament :execrows
+UPDATE tournament
+SET status         = 'running',
+    started_at_utc = sqlc.arg(started_at_utc)
+WHERE id = sqlc.arg(id)
+  AND status = 'pending';
+
+-- name: SetTournamentRound :exec
+UPDATE tournament
+SET round                = sqlc.arg(round),
+    round_started_at_utc = sqlc.arg(round_started_at_utc)
+WHERE id = sqlc.arg(id);
+
+-- name: FinishTournament :execrows
+UPDATE tournament
+SET status       = 'finished',
+    ended_at_utc = sqlc.arg(ended_at_utc)
+WHERE id = sqlc.arg(id)
+  AND status = 'running';
+
+-- name: CreateTournamentGames :copyfrom
+INSERT INTO tournament_game (id, tournament_id, round, red_id, yellow_id)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: SetTournamentGameLobby :exec
+UPDATE tournament_game
+SET lobby_id = $2
+WHERE id = $1;
+
+-- name: SetTournamentPlayerResult :exec
+UPDATE tournament_player
+SET score = sqlc.arg(score),
+    rank  = sqlc.arg(rank)
+WHERE tournament_id = sqlc.arg(tournament_id)
+  AND player_id = sqlc.arg(player_id);
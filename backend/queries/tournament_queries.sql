@@ -0,0 +1,58 @@
+-- name: GetTournamentById :one
+SELECT *
+FROM tournament
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetTournaments :many
+SELECT t.*,
+       (SELECT count(*) FROM tournament_player tp WHERE tp.tournament_id = t.id) AS players
+FROM tournament t
+ORDER BY t.created_at_utc DESC
+LIMIT $1;
+
+-- name: GetRunningTournaments :many
+SELECT *
+FROM tournament
+WHERE status = 'running';
+
+-- name: GetTournamentPlayers :many
+SELECT tp.player_id, tp.rating, u.username
+FROM tournament_player tp
+         JOIN users u ON u.id = tp.player_id
+WHERE tp.tournament_id = $1
+ORDER BY tp.rating DESC, tp.joined_at_utc;
+
+-- name: GetTournamentGames :many
+SELECT tg.id,
+       tg.round,
+       tg.red_id,
+       tg.yellow_id,
+       tg.lobby_id,
+       g.id AS game_id,
+       g.ended_at_utc,
+       g.winner
+FROM tournament_game tg
+         LEFT JOIN game g ON g.lobby_id = tg.lobby_id
+WHERE tg.tournament_id = $1
+ORDER BY tg.round, tg.id;
+
+-- name: GetArenaGames :many
+SELECT g.id,
+       g.winner,
+       red.player_id     AS red_id,
+       red.berserk       AS red_berserk,
+       yellow.player_id  AS yellow_id,
+       yellow.berserk    AS yellow_berserk
+FROM game g
+         JOIN lobby_player red ON red.lobby_id = g.lobby_id AND red.color = 1
+         JOIN lobby_player yellow ON yellow.lobby_id = g.lobby_id AND yellow.color = 2
+WHERE g.tournament_id = $1
+  AND g.ended_at_utc IS NOT NULL
+ORDER BY g.ended_at_utc, g.id;
+
+-- name: CountUnfinishedArenaGames :one
+SELECT count(*)
+FROM game
+WHERE tournament_id = $1
+  AND ended_at_utc IS NULL;
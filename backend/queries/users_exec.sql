@@ -2,3 +2,12 @@
 INSERT INTO users (id, username, email, password, created_at_utc)
 VALUES ($1, $2, $3, $4, $5);
 
+-- name: UpdateUserPuzzleRating :exec
+UPDATE users
+SET puzzle_rating = $2
+WHERE id = $1;
+
+-- name: UpdateUserRating :exec
+UPDATE users
+SET rating = $2
+WHERE id = $1;
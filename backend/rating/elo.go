@@ -0,0 +1,20 @@
+package rating
+
+import "math"
+
+const (
+	DefaultRating = 1500
+	DefaultK      = 32
+)
+
+// Expected returns the expected score of a player rated a against one rated b.
+func Expected(a int32, b int32) float64 {
+	return 1 / (1 + math.Pow(10, float64(b-a)/400))
+}
+
+// Elo returns the new ratings of a and b after a game in which a scored
+// score (1 for a win, 0.5 for a draw, 0 for a loss).
+func Elo(a int32, b int32, score float64, k float64) (int32, int32) {
+	delta := int32(math.Round(k * (score - Expected(a, b))))
+	return a + delta, b - delta
+}
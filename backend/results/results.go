@@ -0,0 +1,64 @@
+// Package results stores what a finished game leaves behind: the hashes of
+// the positions it went through, and the new ratings of its players. Live
+// games and correspondence games are finished by different services and go
+// through it alike.
+package results
+
+import (
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/rating"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+// Record stores the positions of a finished game, its start included, and updates the ratings of
+// its players if the game is rated. Games started from a handicap or a
+// custom position are never rated, their results say little about the
+// players' strength.
+func Record(ctx context.Context, db *sqlc.Queries, g *game.Game, red uuid.UUID, yellow uuid.UUID, winner game.Color, rated bool) error {
+	positions := make([]sqlc.CreateGamePositionsParams, len(g.Positions))
+	for i, hash := range g.Positions {
+		positions[i] = sqlc.CreateGamePositionsParams{
+			GameID: g.Id,
+			Ply:    int32(i),
+			Hash:   int64(hash),
+		}
+	}
+	var errs []error
+	if _, err := db.CreateGamePositions(ctx, positions); err != nil {
+		errs = append(errs, fmt.Errorf("saving the positions: %w", err))
+	}
+	if rated && g.Standard() {
+		if err := updateRatings(ctx, db, red, yellow, winner); err != nil {
+			errs = append(errs, fmt.Errorf("updating the ratings: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func updateRatings(ctx context.Context, db *sqlc.Queries, redId uuid.UUID, yellowId uuid.UUID, winner game.Color) error {
+	red, err := db.GetUserById(ctx, redId)
+	if err != nil {
+		return err
+	}
+	yellow, err := db.GetUserById(ctx, yellowId)
+	if err != nil {
+		return err
+	}
+
+	score := 0.5
+	switch winner {
+	case game.ColorRed:
+		score = 1
+	case game.ColorYellow:
+		score = 0
+	}
+	redRating, yellowRating := rating.Elo(red.Rating, yellow.Rating, score, rating.DefaultK)
+	if err = db.UpdateUserRating(ctx, sqlc.UpdateUserRatingParams{ID: red.ID, Rating: redRating}); err != nil {
+		return err
+	}
+	return db.UpdateUserRating(ctx, sqlc.UpdateUserRatingParams{ID: yellow.ID, Rating: yellowRating})
+}
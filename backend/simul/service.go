@@ -0,0 +1,212 @@
+package simul
+
+import (
+	"backend/cache"
+	"backend/game"
+	"backend/generated/sqlc"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"time"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRunning  = "running"
+	StatusFinished = "finished"
+)
+
+var (
+	ErrSimulNotFound  = errors.New("simul not found")
+	ErrNotHost        = errors.New("not the host of this simul")
+	ErrStarted        = errors.New("simul has started already")
+	ErrNoParticipants = errors.New("a simul needs at least one participant")
+)
+
+// Service runs simultaneous exhibitions, where a host plays every participant
+// at once. Each board is a lobby of its own, started like an accepted
+// challenge, so the host follows all of them from one connection and tells
+// them apart by their lobby ids.
+type Service struct {
+	db     *sqlc.Queries
+	games  *cache.Cache
+	logger echo.Logger
+}
+
+func NewService(db *sqlc.Queries, games *cache.Cache, logger echo.Logger) *Service {
+	return &Service{
+		db:     db,
+		games:  games,
+		logger: logger,
+	}
+}
+
+// Simul is a simul as loaded from the database, with the boards of its
+// participants in the order they joined.
+type Simul struct {
+	sqlc.Simul
+	Participants []Board
+}
+
+// Board is the game of one participant against the host. LobbyId is nil
+// until the simul starts. The lobby is recorded before it is started,
+// Started reports whether its game was stored since.
+type Board struct {
+	PlayerId uuid.UUID
+	Username string
+	Rating   int32
+	LobbyId  *uuid.UUID
+	Started  bool
+	// HostColor is the color the host plays, ColorNone until the game
+	// started.
+	HostColor game.Color
+	Over      bool
+	// Winner is ColorNone for a draw or a game still running.
+	Winner game.Color
+}
+
+// Status tells whether the simul is open for participants, being played, or
+// over, which it is once every board is.
+func (s *Simul) Status() string {
+	if s.StartedAtUtc == nil {
+		return StatusPending
+	}
+	for _, b := range s.Participants {
+		if !b.Over {
+			return StatusRunning
+		}
+	}
+	return StatusFinished
+}
+
+// HostColor returns the color the host plays on a board. Unless the host
+// chose a color, they alternate between the boards, starting with red.
+func HostColor(board int, color game.Color) game.Color {
+	if color != game.ColorNone {
+		return color
+	}
+	if board%2 == 0 {
+		return game.ColorRed
+	}
+	return game.ColorYellow
+}
+
+func (s *Service) Load(ctx context.Context, simulId uuid.UUID) (*Simul, error) {
+	row, err := s.db.GetSimulById(ctx, simulId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSimulNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	players, err := s.db.GetSimulPlayers(ctx, simulId)
+	if err != nil {
+		return nil, err
+	}
+
+	simul := &Simul{Simul: row, Participants: make([]Board, len(players))}
+	for i, p := range players {
+		board := Board{
+			PlayerId: p.PlayerID,
+			Username: p.Username,
+			Rating:   p.Rating,
+			LobbyId:  p.LobbyID,
+			Started:  p.GameID != nil,
+			Over:     p.EndedAtUtc != nil,
+		}
+		if p.HostColor != nil {
+			board.HostColor = game.Color(*p.HostColor)
+		}
+		if p.Winner != nil {
+			board.Winner = game.Color(*p.Winner)
+		}
+		simul.Participants[i] = board
+	}
+	return simul, nil
+}
+
+// Start closes a simul to new participants and starts the host's game on
+// every board at once. Boards left without a game, because the server went
+// down while they were being started, are started when the host tries again.
+func (s *Service) Start(ctx context.Context, simulId uuid.UUID, playerId uuid.UUID) error {
+	simul, err := s.Load(ctx, simulId)
+	if err != nil {
+		return err
+	}
+	if simul.HostID != playerId {
+		return ErrNotHost
+	}
+	if len(simul.Participants) == 0 {
+		return ErrNoParticipants
+	}
+	variant, err := game.ParseVariant(simul.Variant)
+	if err != nil {
+		return err
+	}
+
+	if simul.StartedAtUtc == nil {
+		now := time.Now().UTC()
+		started, err := s.db.StartSimul(ctx, sqlc.StartSimulParams{StartedAtUtc: &now, ID: simul.ID})
+		if err != nil {
+			return err
+		}
+		if started == 0 {
+			return ErrStarted
+		}
+	}
+
+	pending := 0
+	for i, b := range simul.Participants {
+		if b.Started {
+			continue
+		}
+		pending++
+		if err = s.startBoard(ctx, simul, variant, i); err != nil {
+			return err
+		}
+	}
+	if pending == 0 {
+		return ErrStarted
+	}
+	s.logger.Infof("simul %v: %d boards started", simul.ID, pending)
+	return nil
+}
+
+// startBoard starts the game of a board in a new lobby. The lobby is recorded
+// first and started under the recorded id, so that a board whose lobby was
+// started before a crash is not started again in a second one.
+func (s *Service) startBoard(ctx context.Context, simul *Simul, variant game.Variant, board int) error {
+	participant, lobbyId := simul.Participants[board].PlayerId, simul.Participants[board].LobbyId
+	if lobbyId == nil {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		err = s.db.SetSimulPlayerLobby(
+			ctx, sqlc.SetSimulPlayerLobbyParams{
+				SimulID:  simul.ID,
+				PlayerID: participant,
+				LobbyID:  &id,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		lobbyId = &id
+	}
+	_, err := s.games.StartChallenge(
+		ctx, cache.LobbyOptions{
+			Id:         *lobbyId,
+			Variant:    variant,
+			Private:    true,
+			Owner:      simul.HostID,
+			OwnerColor: HostColor(board, game.Color(simul.HostColor)),
+		}, simul.HostID, participant,
+	)
+	if errors.Is(err, cache.ErrLobbyExists) {
+		return nil
+	}
+	return err
+}
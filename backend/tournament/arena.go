@@ -0,0 +1,95 @@
+package tournament
+
+import (
+	"cmp"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// Arena points: a win scores two and a draw one. A player who won their last
+// two games is on fire, and their games score double until they fail to win
+// one. A player who went berserk wagers a point on their game: they score
+// one more for a win and lose one for a loss.
+const (
+	arenaWin     = 2
+	arenaDraw    = 1
+	arenaBerserk = 1
+	// arenaFire is the number of wins in a row setting a player on fire.
+	arenaFire = 2
+)
+
+// ArenaGame is a finished game of an arena.
+type ArenaGame struct {
+	Game
+	RedBerserk    bool
+	YellowBerserk bool
+}
+
+func (g ArenaGame) berserk(playerId uuid.UUID) bool {
+	if g.Red == playerId {
+		return g.RedBerserk
+	}
+	return g.YellowBerserk
+}
+
+// ArenaStandings ranks the players of an arena by their points, given their
+// games in the order they ended. Players with the same points share a rank
+// and are listed in seed order.
+func ArenaStandings(players []Player, games []ArenaGame) []Standing {
+	standings := make([]Standing, len(players))
+	seeds := make(map[uuid.UUID]int, len(players))
+	for i, p := range players {
+		seeds[p.Id] = i
+		standings[i] = Standing{PlayerId: p.Id}
+	}
+
+	for _, g := range games {
+		for _, pId := range []uuid.UUID{g.Red, g.Yellow} {
+			seed, entered := seeds[pId]
+			if !entered {
+				continue
+			}
+			s := &standings[seed]
+			multiplier := 1
+			if s.Streak >= arenaFire {
+				multiplier = 2
+			}
+			s.Played++
+			switch g.score(pId) {
+			case 1:
+				s.Wins++
+				s.Streak++
+				s.Score += float64(arenaWin * multiplier)
+				if g.berserk(pId) {
+					s.Score += arenaBerserk
+				}
+			case 0.5:
+				s.Draws++
+				s.Streak = 0
+				s.Score += float64(arenaDraw * multiplier)
+			default:
+				s.Losses++
+				s.Streak = 0
+				if g.berserk(pId) {
+					s.Score -= arenaBerserk
+				}
+			}
+		}
+	}
+	for i := range standings {
+		standings[i].OnFire = standings[i].Streak >= arenaFire
+	}
+
+	slices.SortStableFunc(
+		standings, func(a, b Standing) int {
+			return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(seeds[a.PlayerId], seeds[b.PlayerId]))
+		},
+	)
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i-1].Score == standings[i].Score {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
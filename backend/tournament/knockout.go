@@ -0,0 +1,90 @@
+package tournament
+
+import (
+	"backend/game"
+	"github.com/google/uuid"
+)
+
+// knockout eliminates players once they lost as many games as they have
+// lives. Every round the players left are paired within their bracket, the
+// players with the same number of losses, the highest seed against the
+// lowest one. With two lives this makes a double elimination: the last
+// player without a loss meets the winner of the losers' bracket in the
+// final, which is replayed if the player without a loss loses it.
+//
+// A drawn game is won by the higher seed, and an odd bracket gives its
+// highest seed a bye.
+type knockout struct {
+	lives int
+}
+
+func (k knockout) Pair(players []Player, games []Game) []Pairing {
+	seeds := make(map[uuid.UUID]int, len(players))
+	for i, p := range players {
+		seeds[p.Id] = i
+	}
+	losses := make(map[uuid.UUID]int, len(players))
+	for _, g := range games {
+		if pId, lost := loser(g, seeds); lost {
+			losses[pId]++
+		}
+	}
+
+	brackets := make([][]uuid.UUID, k.lives)
+	alive := 0
+	for _, p := range players {
+		if l := losses[p.Id]; l < k.lives {
+			brackets[l] = append(brackets[l], p.Id)
+			alive++
+		}
+	}
+	if alive < 2 {
+		return nil
+	}
+
+	if k.lives == 2 && len(brackets[0]) == 1 {
+		if len(brackets[1]) == 1 {
+			// the final
+			return []Pairing{colors(brackets[0][0], brackets[1][0], games)}
+		}
+		// the last player without a loss waits for the losers' bracket
+		brackets[0] = nil
+	}
+	var pairings []Pairing
+	for _, bracket := range brackets {
+		pairings = append(pairings, fold(bracket, games)...)
+	}
+	return pairings
+}
+
+// fold pairs the players of a bracket, given in seed order, the highest seed
+// against the lowest one.
+func fold(bracket []uuid.UUID, games []Game) []Pairing {
+	var pairings []Pairing
+	if len(bracket)%2 == 1 {
+		pairings = append(pairings, Pairing{Red: bracket[0]})
+		bracket = bracket[1:]
+	}
+	for i := 0; i < len(bracket)/2; i++ {
+		pairings = append(pairings, colors(bracket[i], bracket[len(bracket)-1-i], games))
+	}
+	return pairings
+}
+
+// loser returns the player who lost a finished game, a draw counting as a
+// loss for the lower seed.
+func loser(g Game, seeds map[uuid.UUID]int) (uuid.UUID, bool) {
+	if g.Bye() || !g.Over {
+		return uuid.Nil, false
+	}
+	switch g.Winner {
+	case game.ColorRed:
+		return g.Yellow, true
+	case game.ColorYellow:
+		return g.Red, true
+	}
+	if seeds[g.Red] < seeds[g.Yellow] {
+		return g.Yellow, true
+	}
+	return g.Red, true
+}
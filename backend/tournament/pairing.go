@@ -0,0 +1,154 @@
+package tournament
+
+import (
+	"backend/game"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+type Format string
+
+const (
+	// FormatRoundRobin has every player meet every other player once.
+	FormatRoundRobin Format = "round-robin"
+	// FormatSwiss plays a fixed number of rounds, pairing players with equal
+	// scores who have not met yet.
+	FormatSwiss Format = "swiss"
+	// FormatKnockout eliminates players after their first loss.
+	FormatKnockout Format = "knockout"
+	// FormatDoubleKnockout eliminates players after their second loss.
+	FormatDoubleKnockout Format = "double-knockout"
+	// FormatArena runs for a fixed time, in which players are paired again
+	// as soon as their game is over. It has no rounds, so it has no Pairer.
+	FormatArena Format = "arena"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatRoundRobin, FormatSwiss, FormatKnockout, FormatDoubleKnockout, FormatArena:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown tournament format '%s'", s)
+}
+
+// Player is a participant of a tournament. Players are seeded in the order
+// they are passed to a Pairer, the strongest first.
+type Player struct {
+	Id     uuid.UUID
+	Rating int32
+}
+
+// Game is a game played, or being played, in a round of a tournament. A game
+// without a yellow player is a bye, which counts as a win for red.
+type Game struct {
+	Round  int
+	Red    uuid.UUID
+	Yellow uuid.UUID
+	Over   bool
+	// Winner is ColorNone for a draw or a game still running.
+	Winner game.Color
+}
+
+func (g Game) Bye() bool {
+	return g.Yellow == uuid.Nil
+}
+
+func (g Game) plays(playerId uuid.UUID) bool {
+	return g.Red == playerId || g.Yellow == playerId
+}
+
+func (g Game) opponent(playerId uuid.UUID) uuid.UUID {
+	if g.Red == playerId {
+		return g.Yellow
+	}
+	return g.Red
+}
+
+// score returns the points a player of the game scored in it: one for a win
+// or a bye, half for a draw.
+func (g Game) score(playerId uuid.UUID) float64 {
+	switch {
+	case g.Bye():
+		return 1
+	case !g.Over:
+		return 0
+	case g.Winner == game.ColorNone:
+		return 0.5
+	case g.Winner == game.ColorRed && g.Red == playerId,
+		g.Winner == game.ColorYellow && g.Yellow == playerId:
+		return 1
+	}
+	return 0
+}
+
+// Pairing is a game of the next round. A pairing without a yellow player is a
+// bye.
+type Pairing struct {
+	Red    uuid.UUID
+	Yellow uuid.UUID
+}
+
+// Pairer decides who plays whom in each round of a tournament. It only works
+// from the games played so far, so a tournament is resumed from its stored
+// games after a restart.
+type Pairer interface {
+	// Pair returns the pairings of the next round once every game played
+	// so far is over, or none when the tournament is over.
+	Pair(players []Player, games []Game) []Pairing
+}
+
+// NewPairer creates the pairing engine of a format. Rounds only applies to
+// Swiss tournaments, where zero picks enough rounds to find a winner.
+func NewPairer(format Format, rounds int) (Pairer, error) {
+	switch format {
+	case FormatRoundRobin:
+		return roundRobin{}, nil
+	case FormatSwiss:
+		return swiss{rounds: rounds}, nil
+	case FormatKnockout:
+		return knockout{lives: 1}, nil
+	case FormatDoubleKnockout:
+		return knockout{lives: 2}, nil
+	}
+	return nil, fmt.Errorf("unknown tournament format '%s'", format)
+}
+
+// rounds returns the number of rounds the games were played in.
+func rounds(games []Game) int {
+	n := 0
+	for _, g := range games {
+		n = max(n, g.Round)
+	}
+	return n
+}
+
+// colorBalance returns how many more games a player played as red than as
+// yellow, and the color of their last game.
+func colorBalance(playerId uuid.UUID, games []Game) (int, game.Color) {
+	balance, last := 0, game.ColorNone
+	for _, g := range games {
+		switch {
+		case g.Bye():
+		case g.Red == playerId:
+			balance++
+			last = game.ColorRed
+		case g.Yellow == playerId:
+			balance--
+			last = game.ColorYellow
+		}
+	}
+	return balance, last
+}
+
+// colors gives red to the player of a pairing who played red less often, or
+// did not play red last, and otherwise to the first player.
+func colors(a uuid.UUID, b uuid.UUID, games []Game) Pairing {
+	aBalance, aLast := colorBalance(a, games)
+	bBalance, bLast := colorBalance(b, games)
+	switch {
+	case aBalance > bBalance,
+		aBalance == bBalance && aLast == game.ColorRed && bLast != game.ColorRed:
+		return Pairing{Red: b, Yellow: a}
+	}
+	return Pairing{Red: a, Yellow: b}
+}
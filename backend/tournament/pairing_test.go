@@ -0,0 +1,281 @@
+package tournament
+
+import (
+	"backend/game"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func testPlayers(n int) []Player {
+	players := make([]Player, n)
+	for i := range players {
+		players[i] = Player{Id: uuid.UUID{byte(i + 1)}, Rating: int32(2000 - 10*i)}
+	}
+	return players
+}
+
+// higherSeedWins plays a pairing out, the player seeded first wins.
+func higherSeedWins(players []Player, p Pairing) game.Color {
+	for _, player := range players {
+		switch player.Id {
+		case p.Red:
+			return game.ColorRed
+		case p.Yellow:
+			return game.ColorYellow
+		}
+	}
+	return game.ColorNone
+}
+
+// play runs a tournament to its end and returns its games. It fails the test
+// if a round pairs a player twice or runs past maxRounds.
+func play(
+	t *testing.T,
+	pairer Pairer,
+	players []Player,
+	maxRounds int,
+	result func(players []Player, p Pairing) game.Color,
+) []Game {
+	t.Helper()
+	var games []Game
+	for round := 1; ; round++ {
+		pairings := pairer.Pair(players, games)
+		if len(pairings) == 0 {
+			return games
+		}
+		if round > maxRounds {
+			t.Fatalf("round %d paired, at most %d expected", round, maxRounds)
+		}
+		seen := make(map[uuid.UUID]bool)
+		for _, p := range pairings {
+			for _, pId := range []uuid.UUID{p.Red, p.Yellow} {
+				if pId == uuid.Nil {
+					continue
+				}
+				if seen[pId] {
+					t.Fatalf("round %d pairs %v twice", round, pId)
+				}
+				seen[pId] = true
+			}
+			g := Game{Round: round, Red: p.Red, Yellow: p.Yellow, Over: true}
+			if !g.Bye() {
+				g.Winner = result(players, p)
+			}
+			games = append(games, g)
+		}
+	}
+}
+
+func meetings(games []Game) map[[2]uuid.UUID]int {
+	met := make(map[[2]uuid.UUID]int)
+	for _, g := range games {
+		if !g.Bye() {
+			met[[2]uuid.UUID{g.Red, g.Yellow}]++
+			met[[2]uuid.UUID{g.Yellow, g.Red}]++
+		}
+	}
+	return met
+}
+
+func byes(games []Game) map[uuid.UUID]int {
+	byes := make(map[uuid.UUID]int)
+	for _, g := range games {
+		if g.Bye() {
+			byes[g.Red]++
+		}
+	}
+	return byes
+}
+
+func TestRoundRobin(t *testing.T) {
+	for n := 2; n <= 9; n++ {
+		players := testPlayers(n)
+		want := n - 1 + n%2
+		games := play(t, roundRobin{}, players, want, higherSeedWins)
+		if got := rounds(games); got != want {
+			t.Errorf("%d players: %d rounds, want %d", n, got, want)
+		}
+
+		met, had := meetings(games), byes(games)
+		for i, a := range players {
+			for _, b := range players[i+1:] {
+				if met[[2]uuid.UUID{a.Id, b.Id}] != 1 {
+					t.Errorf("%d players: %v and %v met %d times", n, a.Id, b.Id, met[[2]uuid.UUID{a.Id, b.Id}])
+				}
+			}
+			if had[a.Id] != n%2 {
+				t.Errorf("%d players: %v had %d byes", n, a.Id, had[a.Id])
+			}
+			if balance, _ := colorBalance(a.Id, games); balance < -1 || balance > 1 {
+				t.Errorf("%d players: %v played red %d more times than yellow", n, a.Id, balance)
+			}
+		}
+	}
+}
+
+func TestRounds(t *testing.T) {
+	tests := map[int]int{0: 0, 1: 0, 2: 1, 3: 2, 4: 2, 5: 3, 8: 3, 9: 4, 16: 4, 17: 5}
+	for n, want := range tests {
+		if got := Rounds(n); got != want {
+			t.Errorf("Rounds(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestSwiss(t *testing.T) {
+	for n := 2; n <= 12; n++ {
+		players := testPlayers(n)
+		games := play(t, swiss{}, players, Rounds(n), higherSeedWins)
+		if got := rounds(games); got != Rounds(n) {
+			t.Errorf("%d players: %d rounds, want %d", n, got, Rounds(n))
+		}
+		for pair, times := range meetings(games) {
+			if times > 1 {
+				t.Errorf("%d players: %v and %v met %d times", n, pair[0], pair[1], times)
+			}
+		}
+		for pId, had := range byes(games) {
+			if had > 1 {
+				t.Errorf("%d players: %v had %d byes", n, pId, had)
+			}
+		}
+		// the higher seeds winning every game, only the first seed wins
+		// them all
+		if standings := Standings(players, games); standings[0].PlayerId != players[0].Id ||
+			standings[0].Wins+byes(games)[players[0].Id] != Rounds(n) ||
+			standings[1].Score == standings[0].Score {
+			t.Errorf("%d players: standings %+v", n, standings[:2])
+		}
+	}
+}
+
+func TestSwissPairsScoreGroups(t *testing.T) {
+	players := testPlayers(8)
+	games := play(t, swiss{rounds: 1}, players, 1, higherSeedWins)
+	pairings := swiss{rounds: 2}.Pair(players, games)
+	if len(pairings) != 4 {
+		t.Fatalf("%d pairings, want 4", len(pairings))
+	}
+	scores := scores(games)
+	for _, p := range pairings {
+		if scores[p.Red] != scores[p.Yellow] {
+			t.Errorf("%v with %v points paired with %v with %v", p.Red, scores[p.Red], p.Yellow, scores[p.Yellow])
+		}
+	}
+}
+
+func TestSwissByeGoesToLowestRanked(t *testing.T) {
+	players := testPlayers(5)
+	pairings := swiss{rounds: 1}.Pair(players, nil)
+	if pairings[0].Yellow != uuid.Nil || pairings[0].Red != players[4].Id {
+		t.Fatalf("first pairing %+v, want a bye for the last seed", pairings[0])
+	}
+
+	// the last seed won their bye and the other games went to the higher
+	// seeds, so the lowest ranked without a bye is the fourth seed
+	games := []Game{
+		{Round: 1, Red: players[4].Id, Over: true},
+		{Round: 1, Red: players[0].Id, Yellow: players[1].Id, Over: true, Winner: game.ColorRed},
+		{Round: 1, Red: players[2].Id, Yellow: players[3].Id, Over: true, Winner: game.ColorRed},
+	}
+	pairings = swiss{rounds: 2}.Pair(players, games)
+	if pairings[0].Yellow != uuid.Nil || pairings[0].Red != players[3].Id {
+		t.Errorf("first pairing %+v, want a bye for the fourth seed", pairings[0])
+	}
+}
+
+func TestSwissAllowsRematchesWhenNeeded(t *testing.T) {
+	// four players meet everyone in three rounds, a fourth has to repeat
+	players := testPlayers(4)
+	games := play(t, swiss{rounds: 4}, players, 4, higherSeedWins)
+	if got := rounds(games); got != 4 {
+		t.Errorf("%d rounds, want 4", got)
+	}
+}
+
+func TestSwissWaitsForNothing(t *testing.T) {
+	if pairings := (swiss{}).Pair(testPlayers(1), nil); pairings != nil {
+		t.Errorf("a single player was paired: %+v", pairings)
+	}
+}
+
+func TestKnockout(t *testing.T) {
+	players := testPlayers(8)
+	first := knockout{lives: 1}.Pair(players, nil)
+	want := [][2]int{{0, 7}, {1, 6}, {2, 5}, {3, 4}}
+	for i, p := range first {
+		if p.Red != players[want[i][0]].Id || p.Yellow != players[want[i][1]].Id {
+			t.Errorf("pairing %d = %+v, want seeds %v", i, p, want[i])
+		}
+	}
+
+	games := play(t, knockout{lives: 1}, players, 3, higherSeedWins)
+	if len(games) != 7 {
+		t.Errorf("%d games, want 7", len(games))
+	}
+	if champion := games[len(games)-1]; !champion.plays(players[0].Id) || !champion.plays(players[1].Id) {
+		t.Errorf("final %+v, want the two first seeds", champion)
+	}
+}
+
+func TestKnockoutOddBracket(t *testing.T) {
+	players := testPlayers(5)
+	pairings := knockout{lives: 1}.Pair(players, nil)
+	if len(pairings) != 3 || pairings[0].Red != players[0].Id || !(Game{Yellow: pairings[0].Yellow}).Bye() {
+		t.Errorf("pairings %+v, want a bye for the first seed", pairings)
+	}
+	play(t, knockout{lives: 1}, players, 3, higherSeedWins)
+}
+
+func TestKnockoutDraw(t *testing.T) {
+	players := testPlayers(2)
+	// the lower seed plays red and draws, the higher seed goes through
+	games := []Game{{Round: 1, Red: players[1].Id, Yellow: players[0].Id, Over: true}}
+	if pId, lost := loser(games[0], map[uuid.UUID]int{players[0].Id: 0, players[1].Id: 1}); !lost || pId != players[1].Id {
+		t.Errorf("loser = %v, %v, want the lower seed", pId, lost)
+	}
+	if pairings := (knockout{lives: 1}).Pair(players, games); pairings != nil {
+		t.Errorf("pairings %+v after the final", pairings)
+	}
+}
+
+func TestDoubleKnockout(t *testing.T) {
+	for n := 2; n <= 9; n++ {
+		players := testPlayers(n)
+		games := play(t, knockout{lives: 2}, players, 4*n, higherSeedWins)
+
+		seeds := make(map[uuid.UUID]int, n)
+		for i, p := range players {
+			seeds[p.Id] = i
+		}
+		losses := make(map[uuid.UUID]int)
+		for _, g := range games {
+			if pId, lost := loser(g, seeds); lost {
+				losses[pId]++
+			}
+		}
+		for i, p := range players {
+			switch {
+			case i == 0 && losses[p.Id] != 0:
+				t.Errorf("%d players: the first seed lost %d games", n, losses[p.Id])
+			case i > 0 && losses[p.Id] != 2:
+				t.Errorf("%d players: seed %d lost %d games, want 2", n, i, losses[p.Id])
+			}
+		}
+	}
+}
+
+func TestDoubleKnockoutFinalReplay(t *testing.T) {
+	players := testPlayers(2)
+	// the player without a loss loses the final, which is played again
+	lowerSeedWins := func(players []Player, p Pairing) game.Color {
+		if higherSeedWins(players, p) == game.ColorRed {
+			return game.ColorYellow
+		}
+		return game.ColorRed
+	}
+	games := play(t, knockout{lives: 2}, players, 2, lowerSeedWins)
+	if len(games) != 2 {
+		t.Errorf("%d games, want the final and its replay", len(games))
+	}
+}
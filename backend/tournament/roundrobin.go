@@ -0,0 +1,50 @@
+package tournament
+
+import "github.com/google/uuid"
+
+// roundRobin pairs players with the circle method: the first player stays in
+// place while the others rotate by one seat each round, and seats across
+// from each other play. An odd number of players gets an empty seat whose
+// opponent has a bye.
+//
+// The first player alternates colors, at the other tables the player in the
+// first half of the seats plays red. Every other player goes round all the
+// seats once, which keeps their colors within one game of even.
+type roundRobin struct{}
+
+func (roundRobin) Pair(players []Player, games []Game) []Pairing {
+	seats := make([]uuid.UUID, 0, len(players)+1)
+	for _, p := range players {
+		seats = append(seats, p.Id)
+	}
+	if len(seats)%2 == 1 {
+		seats = append(seats, uuid.Nil)
+	}
+	round := rounds(games)
+	if len(seats) < 2 || round >= len(seats)-1 {
+		return nil
+	}
+
+	rotated := make([]uuid.UUID, len(seats))
+	rotated[0] = seats[0]
+	rest := len(seats) - 1
+	for i := 1; i < len(seats); i++ {
+		rotated[1+(i-1+round)%rest] = seats[i]
+	}
+
+	pairings := make([]Pairing, 0, len(seats)/2)
+	for i := 0; i < len(seats)/2; i++ {
+		a, b := rotated[i], rotated[len(seats)-1-i]
+		switch {
+		case a == uuid.Nil:
+			pairings = append(pairings, Pairing{Red: b})
+		case b == uuid.Nil:
+			pairings = append(pairings, Pairing{Red: a})
+		case i == 0 && round%2 == 1:
+			pairings = append(pairings, Pairing{Red: b, Yellow: a})
+		default:
+			pairings = append(pairings, Pairing{Red: a, Yellow: b})
+		}
+	}
+	return pairings
+}
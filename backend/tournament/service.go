@@ -0,0 +1,452 @@
+package tournament
+
+import (
+	"backend/cache"
+	"backend/config"
+	"backend/game"
+	"backend/generated/sqlc"
+	"backend/message"
+	"backend/websockets"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"time"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRunning  = "running"
+	StatusFinished = "finished"
+)
+
+var (
+	ErrTournamentNotFound = errors.New("tournament not found")
+	ErrNotOrganizer       = errors.New("not the organizer of this tournament")
+	ErrStarted            = errors.New("tournament has started already")
+	ErrTooFewPlayers      = errors.New("a tournament needs at least two players")
+	ErrNotArena           = errors.New("not an arena tournament")
+	ErrNotRunning         = errors.New("tournament is not running")
+	ErrNotEntered         = errors.New("not entered in this tournament")
+)
+
+// Service runs tournaments. Every round is paired from the games stored so
+// far and its games are started in the cache like any other game; once they
+// are all over the next round is paired. Since all of it lives in the
+// database, tournaments go on where they stopped after a restart.
+//
+// Arenas have no rounds: the cache pairs their players as they join, and
+// the service opens and closes them and keeps their players posted on the
+// standings.
+type Service struct {
+	db     *sqlc.Queries
+	cfg    config.TournamentsConfig
+	games  *cache.Cache
+	logger echo.Logger
+	// leaderboards are the arena leaderboards last pushed, owned by Run
+	leaderboards map[uuid.UUID]string
+}
+
+func NewService(db *sqlc.Queries, cfg config.TournamentsConfig, games *cache.Cache, logger echo.Logger) *Service {
+	return &Service{
+		db:           db,
+		cfg:          cfg,
+		games:        games,
+		logger:       logger,
+		leaderboards: make(map[uuid.UUID]string),
+	}
+}
+
+// Tournament is a tournament as loaded from the database, with its players
+// in seed order. The games of an arena are in ArenaGames rather than Games.
+type Tournament struct {
+	sqlc.Tournament
+	Players    []Player
+	Usernames  map[uuid.UUID]string
+	Games      []ScheduledGame
+	ArenaGames []ArenaGame
+}
+
+// ScheduledGame is a game of a tournament along with the lobby it is played
+// in, which is nil for byes and games not started yet. The lobby is recorded
+// before it is started, Started reports whether its game was stored since.
+type ScheduledGame struct {
+	Game
+	Id      uuid.UUID
+	LobbyId *uuid.UUID
+	Started bool
+}
+
+func (t *Tournament) games() []Game {
+	games := make([]Game, len(t.Games))
+	for i, g := range t.Games {
+		games[i] = g.Game
+	}
+	return games
+}
+
+func (t *Tournament) Standings() []Standing {
+	if Format(t.Format) == FormatArena {
+		return ArenaStandings(t.Players, t.ArenaGames)
+	}
+	return Standings(t.Players, t.games())
+}
+
+// EndsAt returns when a running arena stops pairing players, or the zero
+// time for other tournaments and arenas not started yet.
+func EndsAt(t sqlc.Tournament) time.Time {
+	if Format(t.Format) != FormatArena || t.StartedAtUtc == nil {
+		return time.Time{}
+	}
+	return t.StartedAtUtc.Add(time.Duration(t.Minutes) * time.Minute)
+}
+
+func (s *Service) Load(ctx context.Context, tournamentId uuid.UUID) (*Tournament, error) {
+	row, err := s.db.GetTournamentById(ctx, tournamentId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTournamentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	players, err := s.db.GetTournamentPlayers(ctx, tournamentId)
+	if err != nil {
+		return nil, err
+	}
+	games, err := s.db.GetTournamentGames(ctx, tournamentId)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tournament{
+		Tournament: row,
+		Players:    make([]Player, len(players)),
+		Usernames:  make(map[uuid.UUID]string, len(players)),
+		Games:      make([]ScheduledGame, len(games)),
+	}
+	for i, p := range players {
+		t.Players[i] = Player{Id: p.PlayerID, Rating: p.Rating}
+		t.Usernames[p.PlayerID] = p.Username
+	}
+	for i, g := range games {
+		scheduled := ScheduledGame{
+			Game: Game{
+				Round: int(g.Round),
+				Red:   g.RedID,
+				Over:  g.EndedAtUtc != nil,
+			},
+			Id:      g.ID,
+			LobbyId: g.LobbyID,
+			Started: g.GameID != nil,
+		}
+		if g.YellowID != nil {
+			scheduled.Yellow = *g.YellowID
+		}
+		if g.Winner != nil {
+			scheduled.Winner = game.Color(*g.Winner)
+		}
+		t.Games[i] = scheduled
+	}
+
+	if Format(t.Format) == FormatArena {
+		arenaGames, err := s.db.GetArenaGames(ctx, &tournamentId)
+		if err != nil {
+			return nil, err
+		}
+		t.ArenaGames = make([]ArenaGame, len(arenaGames))
+		for i, g := range arenaGames {
+			t.ArenaGames[i] = ArenaGame{
+				Game: Game{
+					Red:    g.RedID,
+					Yellow: g.YellowID,
+					Over:   true,
+					Winner: game.Color(g.Winner),
+				},
+				RedBerserk:    g.RedBerserk,
+				YellowBerserk: g.YellowBerserk,
+			}
+		}
+	}
+	return t, nil
+}
+
+// Start closes the registration of a tournament, its first round is paired
+// by Run shortly after. Arenas stay open for players to join while they run.
+func (s *Service) Start(ctx context.Context, tournamentId uuid.UUID, playerId uuid.UUID) error {
+	t, err := s.Load(ctx, tournamentId)
+	if err != nil {
+		return err
+	}
+	if t.CreatedBy != playerId {
+		return ErrNotOrganizer
+	}
+	if len(t.Players) < 2 {
+		return ErrTooFewPlayers
+	}
+	now := time.Now().UTC()
+	started, err := s.db.StartTournament(ctx, sqlc.StartTournamentParams{StartedAtUtc: &now, ID: t.ID})
+	if err != nil {
+		return err
+	}
+	if started == 0 {
+		return ErrStarted
+	}
+	return nil
+}
+
+// Run pairs the next round of every running tournament whose round is over,
+// and opens and closes arenas on time. Only one node of a cluster runs it at
+// a time.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.TickInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.advanceAll(ctx); err != nil {
+			s.logger.Errorf("advancing tournaments failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) advanceAll(ctx context.Context) error {
+	running, err := s.db.GetRunningTournaments(ctx)
+	if err != nil {
+		return err
+	}
+	for _, row := range running {
+		t, err := s.Load(ctx, row.ID)
+		switch {
+		case err != nil:
+		case Format(t.Format) == FormatArena:
+			err = s.runArena(ctx, t)
+		default:
+			err = s.advance(ctx, t)
+		}
+		if err != nil {
+			s.logger.Warnf("advancing tournament %v: %v", row.ID, err)
+		}
+	}
+	return nil
+}
+
+// advance starts the games of the current round whose lobby did not start,
+// forfeits those that outlast the round, and pairs the next round or
+// finishes the tournament once they are all over.
+func (s *Service) advance(ctx context.Context, t *Tournament) error {
+	pairer, err := NewPairer(Format(t.Format), int(t.Rounds))
+	if err != nil {
+		return err
+	}
+	variant, err := game.ParseVariant(t.Variant)
+	if err != nil {
+		return err
+	}
+
+	late := s.cfg.RoundTimeout > 0 && t.RoundStartedAtUtc != nil &&
+		time.Since(*t.RoundStartedAtUtc) > s.cfg.RoundTimeout
+	over := true
+	for _, g := range t.Games {
+		if g.Bye() || g.Over {
+			continue
+		}
+		over = false
+		switch {
+		case !g.Started:
+			// the server went down while the round was being started
+			if err = s.startGame(ctx, variant, g); err != nil {
+				return err
+			}
+		case late:
+			if err = s.games.Forfeit(ctx, *g.LobbyId); err != nil {
+				s.logger.Warnf("tournament %v: forfeiting game %v: %v", t.ID, g.Id, err)
+			}
+		}
+	}
+	if !over {
+		return nil
+	}
+
+	pairings := pairer.Pair(t.Players, t.games())
+	if len(pairings) == 0 {
+		now := time.Now().UTC()
+		_, err = s.db.FinishTournament(ctx, sqlc.FinishTournamentParams{EndedAtUtc: &now, ID: t.ID})
+		return err
+	}
+
+	round := rounds(t.games()) + 1
+	games := make([]ScheduledGame, len(pairings))
+	rows := make([]sqlc.CreateTournamentGamesParams, len(pairings))
+	for i, p := range pairings {
+		gameId, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		games[i] = ScheduledGame{Game: Game{Round: round, Red: p.Red, Yellow: p.Yellow}, Id: gameId}
+		rows[i] = sqlc.CreateTournamentGamesParams{
+			ID:           gameId,
+			TournamentID: t.ID,
+			Round:        int32(round),
+			RedID:        p.Red,
+		}
+		if p.Yellow != uuid.Nil {
+			rows[i].YellowID = &p.Yellow
+		}
+	}
+	if _, err = s.db.CreateTournamentGames(ctx, rows); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	err = s.db.SetTournamentRound(
+		ctx, sqlc.SetTournamentRoundParams{ID: t.ID, Round: int32(round), RoundStartedAtUtc: &now},
+	)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("tournament %v: round %d paired", t.ID, round)
+
+	for _, g := range games {
+		if g.Bye() {
+			continue
+		}
+		if err = s.startGame(ctx, variant, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startGame seats the players of a game in a new lobby, where they find it
+// on every session they are connected with. The lobby is recorded first and
+// started under the recorded id, so that a game whose lobby was started
+// before a crash is not started again in a second one.
+func (s *Service) startGame(ctx context.Context, variant game.Variant, g ScheduledGame) error {
+	if g.LobbyId == nil {
+		lobbyId, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		if err = s.db.SetTournamentGameLobby(ctx, sqlc.SetTournamentGameLobbyParams{ID: g.Id, LobbyID: &lobbyId}); err != nil {
+			return err
+		}
+		g.LobbyId = &lobbyId
+	}
+	_, err := s.games.StartChallenge(
+		ctx, cache.LobbyOptions{
+			Id:         *g.LobbyId,
+			Variant:    variant,
+			Private:    true,
+			Owner:      g.Red,
+			OwnerColor: game.ColorRed,
+		}, g.Red, g.Yellow,
+	)
+	if errors.Is(err, cache.ErrLobbyExists) {
+		return nil
+	}
+	return err
+}
+
+// Enter queues a session of a player for their next game in a running arena
+// they entered.
+func (s *Service) Enter(ctx context.Context, tournamentId uuid.UUID, c *cache.Client) error {
+	t, err := s.Load(ctx, tournamentId)
+	if err != nil {
+		return err
+	}
+	if Format(t.Format) != FormatArena {
+		return ErrNotArena
+	}
+	if t.Status != StatusRunning || !time.Now().Before(EndsAt(t.Tournament)) {
+		return ErrNotRunning
+	}
+	if _, entered := t.Usernames[c.Id]; !entered {
+		return ErrNotEntered
+	}
+	return s.games.JoinArena(ctx, c, t.ID)
+}
+
+// runArena keeps a running arena open in the cache until its time is up. The
+// arena is finished once the games started before then are over, and the
+// final standings are stored with its players.
+func (s *Service) runArena(ctx context.Context, t *Tournament) error {
+	if time.Now().Before(EndsAt(t.Tournament)) {
+		variant, err := game.ParseVariant(t.Variant)
+		if err != nil {
+			return err
+		}
+		if err = s.games.OpenArena(t.ID, variant); err != nil {
+			return err
+		}
+		s.pushLeaderboard(ctx, t, false)
+		return nil
+	}
+
+	s.games.CloseArena(t.ID)
+	playing, err := s.db.CountUnfinishedArenaGames(ctx, &t.ID)
+	if err != nil {
+		return err
+	}
+	if playing > 0 {
+		s.pushLeaderboard(ctx, t, false)
+		return nil
+	}
+
+	for _, standing := range t.Standings() {
+		err = s.db.SetTournamentPlayerResult(
+			ctx, sqlc.SetTournamentPlayerResultParams{
+				Score:        int32(standing.Score),
+				Rank:         int32(standing.Rank),
+				TournamentID: t.ID,
+				PlayerID:     standing.PlayerId,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC()
+	if _, err = s.db.FinishTournament(ctx, sqlc.FinishTournamentParams{EndedAtUtc: &now, ID: t.ID}); err != nil {
+		return err
+	}
+	s.logger.Infof("arena %v finished", t.ID)
+	s.pushLeaderboard(ctx, t, true)
+	delete(s.leaderboards, t.ID)
+	return nil
+}
+
+// pushLeaderboard sends the standings of an arena to its players when they
+// changed since the last push, or when the arena is over.
+func (s *Service) pushLeaderboard(ctx context.Context, t *Tournament, over bool) {
+	payload := message.ArenaLeaderboardPayload{
+		TournamentId: t.ID.String(),
+		EndsAt:       EndsAt(t.Tournament),
+		Over:         over,
+	}
+	for _, standing := range t.Standings() {
+		payload.Standings = append(
+			payload.Standings, message.ArenaStandingPayload{
+				Rank:     standing.Rank,
+				Username: t.Usernames[standing.PlayerId],
+				Score:    int(standing.Score),
+				Played:   standing.Played,
+				Streak:   standing.Streak,
+				OnFire:   standing.OnFire,
+			},
+		)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil || (!over && s.leaderboards[t.ID] == string(data)) {
+		return
+	}
+	s.leaderboards[t.ID] = string(data)
+
+	wr := websockets.WriteRequest{MsgType: message.TypeArenaLeaderboard, Payload: payload}
+	for _, p := range t.Players {
+		s.games.SendTo(ctx, p.Id, wr)
+	}
+}
@@ -0,0 +1,93 @@
+package tournament
+
+import (
+	"cmp"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// Standing is a player's result in a tournament so far.
+type Standing struct {
+	PlayerId uuid.UUID
+	Rank     int
+	Score    float64
+	Played   int
+	Wins     int
+	Draws    int
+	Losses   int
+	// Buchholz sums the scores of the player's opponents, rewarding a
+	// harder field.
+	Buchholz float64
+	// SonnebornBerger sums the scores of the opponents the player beat and
+	// half the scores of those they drew with.
+	SonnebornBerger float64
+	// Streak and OnFire only apply to arenas, see ArenaStandings.
+	Streak int
+	OnFire bool
+}
+
+// Standings ranks the players by score, breaking ties by Buchholz, then by
+// Sonneborn-Berger and finally by seed. Players sharing all of them share a
+// rank. Byes score a point but add nothing to the tiebreaks.
+func Standings(players []Player, games []Game) []Standing {
+	scores := scores(games)
+	standings := make([]Standing, len(players))
+	seeds := make(map[uuid.UUID]int, len(players))
+	for i, p := range players {
+		seeds[p.Id] = i
+		s := Standing{PlayerId: p.Id, Score: scores[p.Id]}
+		for _, g := range games {
+			if !g.plays(p.Id) || g.Bye() || !g.Over {
+				continue
+			}
+			s.Played++
+			opponent := g.opponent(p.Id)
+			score := g.score(p.Id)
+			s.Buchholz += scores[opponent]
+			s.SonnebornBerger += score * scores[opponent]
+			switch score {
+			case 1:
+				s.Wins++
+			case 0.5:
+				s.Draws++
+			default:
+				s.Losses++
+			}
+		}
+		standings[i] = s
+	}
+
+	slices.SortStableFunc(
+		standings, func(a, b Standing) int {
+			return cmp.Or(
+				cmp.Compare(b.Score, a.Score),
+				cmp.Compare(b.Buchholz, a.Buchholz),
+				cmp.Compare(b.SonnebornBerger, a.SonnebornBerger),
+				cmp.Compare(seeds[a.PlayerId], seeds[b.PlayerId]),
+			)
+		},
+	)
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && tied(standings[i-1], standings[i]) {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+func tied(a Standing, b Standing) bool {
+	return a.Score == b.Score && a.Buchholz == b.Buchholz && a.SonnebornBerger == b.SonnebornBerger
+}
+
+// scores sums the points of every player over the finished games and byes.
+func scores(games []Game) map[uuid.UUID]float64 {
+	scores := make(map[uuid.UUID]float64)
+	for _, g := range games {
+		scores[g.Red] += g.score(g.Red)
+		if !g.Bye() {
+			scores[g.Yellow] += g.score(g.Yellow)
+		}
+	}
+	return scores
+}
@@ -0,0 +1,91 @@
+package tournament
+
+import (
+	"backend/game"
+	"testing"
+)
+
+func TestStandings(t *testing.T) {
+	p := testPlayers(5)
+	a, b, c, d, e := p[0].Id, p[1].Id, p[2].Id, p[3].Id, p[4].Id
+
+	tests := []struct {
+		name    string
+		players []Player
+		games   []Game
+		want    []Standing
+	}{
+		{
+			// B and C share score and Buchholz, C drew with the leader
+			name:    "sonneborn-berger",
+			players: p[:4],
+			games: []Game{
+				{Round: 1, Red: a, Yellow: b, Over: true, Winner: game.ColorRed},
+				{Round: 1, Red: c, Yellow: d, Over: true},
+				{Round: 2, Red: c, Yellow: a, Over: true},
+				{Round: 2, Red: d, Yellow: b, Over: true, Winner: game.ColorYellow},
+				// a game still running counts for nothing
+				{Round: 3, Red: a, Yellow: d},
+			},
+			want: []Standing{
+				{PlayerId: a, Rank: 1, Score: 1.5, Played: 2, Wins: 1, Draws: 1, Buchholz: 2, SonnebornBerger: 1.5},
+				{PlayerId: c, Rank: 2, Score: 1, Played: 2, Draws: 2, Buchholz: 2, SonnebornBerger: 1},
+				{PlayerId: b, Rank: 3, Score: 1, Played: 2, Wins: 1, Losses: 1, Buchholz: 2, SonnebornBerger: 0.5},
+				{PlayerId: d, Rank: 4, Score: 0.5, Played: 2, Draws: 1, Losses: 1, Buchholz: 2, SonnebornBerger: 0.5},
+			},
+		},
+		{
+			// byes score a point but add to no tiebreak
+			name:    "buchholz and byes",
+			players: p,
+			games: []Game{
+				{Round: 1, Red: a, Yellow: b, Over: true, Winner: game.ColorRed},
+				{Round: 1, Red: c, Yellow: d, Over: true, Winner: game.ColorRed},
+				{Round: 1, Red: e, Over: true},
+				{Round: 2, Red: c, Yellow: a, Over: true, Winner: game.ColorYellow},
+				{Round: 2, Red: b, Yellow: e, Over: true, Winner: game.ColorYellow},
+				{Round: 2, Red: d, Over: true},
+			},
+			want: []Standing{
+				{PlayerId: a, Rank: 1, Score: 2, Played: 2, Wins: 2, Buchholz: 1, SonnebornBerger: 1},
+				{PlayerId: e, Rank: 2, Score: 2, Played: 1, Wins: 1},
+				{PlayerId: c, Rank: 3, Score: 1, Played: 2, Wins: 1, Losses: 1, Buchholz: 3, SonnebornBerger: 1},
+				{PlayerId: d, Rank: 4, Score: 1, Played: 1, Losses: 1, Buchholz: 1},
+				{PlayerId: b, Rank: 5, Score: 0, Played: 2, Losses: 2, Buchholz: 4},
+			},
+		},
+		{
+			// players tied on everything share a rank and stay in seed order
+			name:    "shared ranks",
+			players: p[:4],
+			games: []Game{
+				{Round: 1, Red: d, Yellow: a, Over: true, Winner: game.ColorRed},
+				{Round: 1, Red: c, Yellow: b, Over: true, Winner: game.ColorRed},
+			},
+			want: []Standing{
+				{PlayerId: c, Rank: 1, Score: 1, Played: 1, Wins: 1},
+				{PlayerId: d, Rank: 1, Score: 1, Played: 1, Wins: 1},
+				{PlayerId: a, Rank: 3, Played: 1, Losses: 1, Buchholz: 1},
+				{PlayerId: b, Rank: 3, Played: 1, Losses: 1, Buchholz: 1},
+			},
+		},
+		{
+			name:    "nothing played",
+			players: p[:2],
+			want:    []Standing{{PlayerId: a, Rank: 1}, {PlayerId: b, Rank: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Standings(tt.players, tt.games)
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d standings, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("standing %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package tournament
+
+import (
+	"cmp"
+	"github.com/google/uuid"
+	"math/bits"
+	"slices"
+)
+
+// pairingBudget bounds the search for a round without rematches, beyond it
+// the round is paired allowing them.
+const pairingBudget = 10000
+
+// swiss pairs players with the same score, or the closest one, who have not
+// played each other yet, for a fixed number of rounds.
+type swiss struct {
+	rounds int
+}
+
+// Rounds returns the number of rounds a Swiss tournament of n players plays
+// when none are configured, enough to find a sole winner.
+func Rounds(n int) int {
+	if n < 2 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
+
+func (s swiss) Pair(players []Player, games []Game) []Pairing {
+	total := s.rounds
+	if total == 0 {
+		total = Rounds(len(players))
+	}
+	if len(players) < 2 || rounds(games) >= total {
+		return nil
+	}
+
+	scores := scores(games)
+	ranked := make([]uuid.UUID, len(players))
+	seeds := make(map[uuid.UUID]int, len(players))
+	for i, p := range players {
+		ranked[i] = p.Id
+		seeds[p.Id] = i
+	}
+	slices.SortStableFunc(
+		ranked, func(a, b uuid.UUID) int {
+			return cmp.Or(cmp.Compare(scores[b], scores[a]), cmp.Compare(seeds[a], seeds[b]))
+		},
+	)
+
+	var pairings []Pairing
+	if len(ranked)%2 == 1 {
+		// the bye goes to the lowest ranked player who had none yet
+		bye := len(ranked) - 1
+		for i := len(ranked) - 1; i >= 0; i-- {
+			if !hadBye(ranked[i], games) {
+				bye = i
+				break
+			}
+		}
+		pairings = append(pairings, Pairing{Red: ranked[bye]})
+		ranked = slices.Delete(ranked, bye, bye+1)
+	}
+
+	met := make(map[[2]uuid.UUID]bool)
+	for _, g := range games {
+		if !g.Bye() {
+			met[[2]uuid.UUID{g.Red, g.Yellow}] = true
+			met[[2]uuid.UUID{g.Yellow, g.Red}] = true
+		}
+	}
+	budget := pairingBudget
+	pairs, ok := pairUp(ranked, met, &budget)
+	if !ok {
+		pairs, _ = pairUp(ranked, nil, nil)
+	}
+	for _, pair := range pairs {
+		pairings = append(pairings, colors(pair[0], pair[1], games))
+	}
+	return pairings
+}
+
+// pairUp pairs the players in rank order, each with the highest ranked
+// player left they have not met, backtracking when the players left cannot
+// be paired. It gives up once the budget of steps is spent.
+func pairUp(ranked []uuid.UUID, met map[[2]uuid.UUID]bool, budget *int) ([][2]uuid.UUID, bool) {
+	if len(ranked) == 0 {
+		return nil, true
+	}
+	first := ranked[0]
+	for i := 1; i < len(ranked); i++ {
+		if met[[2]uuid.UUID{first, ranked[i]}] {
+			continue
+		}
+		if budget != nil {
+			if *budget == 0 {
+				return nil, false
+			}
+			*budget--
+		}
+		rest := make([]uuid.UUID, 0, len(ranked)-2)
+		rest = append(rest, ranked[1:i]...)
+		rest = append(rest, ranked[i+1:]...)
+		if pairs, ok := pairUp(rest, met, budget); ok {
+			return append([][2]uuid.UUID{{first, ranked[i]}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+func hadBye(playerId uuid.UUID, games []Game) bool {
+	for _, g := range games {
+		if g.Bye() && g.Red == playerId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package websockets
+
+import (
+	"backend/config"
+	"backend/message"
+	"expvar"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+// Violations of the rate limits by message type, and the clients muted and
+// disconnected for them, published on /debug/vars.
+var (
+	violations  = expvar.NewMap("websocketRateViolations")
+	escalations = expvar.NewMap("websocketRateEscalations")
+)
+
+// Verdict is what becomes of a message read from a client.
+type Verdict int
+
+const (
+	Allowed Verdict = iota
+	// Limited drops the message, the client is warned.
+	Limited
+	// Muted drops the message, the client's chat is muted for a while.
+	Muted
+	// Disconnect drops the message and the client with it.
+	Disconnect
+)
+
+// Limiter applies the rate limits of one connection, with a token bucket per
+// message type. It is not safe for concurrent use.
+type Limiter struct {
+	cfg         config.WebsocketsConfig
+	buckets     map[string]*rate.Limiter
+	violations  int
+	windowStart time.Time
+	mutedUntil  time.Time
+}
+
+func NewLimiter(cfg config.WebsocketsConfig) *Limiter {
+	return &Limiter{cfg: cfg, buckets: make(map[string]*rate.Limiter)}
+}
+
+// Check takes a token for a message and escalates once the client keeps
+// exceeding its limits. Chat sent while muted is dropped, and still counts
+// against the limits.
+func (l *Limiter) Check(msgType string, now time.Time) Verdict {
+	key, bucket := l.bucket(msgType)
+	if bucket.AllowN(now, 1) {
+		if msgType == message.TypeChat && now.Before(l.mutedUntil) {
+			return Muted
+		}
+		return Allowed
+	}
+
+	violations.Add(key, 1)
+	if now.Sub(l.windowStart) > l.cfg.ViolationWindow {
+		l.violations, l.windowStart = 0, now
+	}
+	l.violations++
+	switch {
+	case l.violations >= l.cfg.DisconnectAfter:
+		escalations.Add("disconnected", 1)
+		return Disconnect
+	case l.violations >= l.cfg.MuteAfter:
+		if !now.Before(l.mutedUntil) {
+			escalations.Add("muted", 1)
+		}
+		l.mutedUntil = now.Add(l.cfg.MuteDuration)
+		return Muted
+	}
+	return Limited
+}
+
+// MutedUntil returns when the client's chat mute ends.
+func (l *Limiter) MutedUntil() time.Time {
+	return l.mutedUntil
+}
+
+// bucket returns the token bucket of a message type and the limit it counts
+// for. Types without a limit of their own share the default one, so that
+// clients cannot make up types to get around it, and are not limited if
+// there is no default.
+func (l *Limiter) bucket(msgType string) (string, *rate.Limiter) {
+	key := msgType
+	limit, limited := l.cfg.RateLimits[key]
+	if !limited {
+		key = "default"
+		limit, limited = l.cfg.RateLimits[key]
+	}
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+		if !limited {
+			bucket = rate.NewLimiter(rate.Inf, 0)
+		}
+		l.buckets[key] = bucket
+	}
+	return key, bucket
+}
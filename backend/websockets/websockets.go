@@ -3,8 +3,8 @@ package websockets
 import (
 	"backend/message"
 	"context"
-	"encoding/json"
 	"github.com/coder/websocket"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"time"
 )
@@ -18,7 +18,8 @@ type ReadResult struct {
 	Err error
 }
 
-func StartReader(c echo.Context, ws *websocket.Conn, readResults chan<- ReadResult) {
+// StartReader reads messages in the encoding of the connection's protocol.
+func StartReader(c echo.Context, ws *websocket.Conn, codec message.Codec, readResults chan<- ReadResult) {
 	ctx := c.Request().Context()
 	defer close(readResults)
 	for {
@@ -27,7 +28,7 @@ func StartReader(c echo.Context, ws *websocket.Conn, readResults chan<- ReadResu
 			readResults <- ReadResult{message.Nil, ctx.Err()}
 			return
 		default:
-			msg, err := read(ctx, ws)
+			msg, err := read(ctx, ws, codec)
 			if err != nil {
 				readResults <- ReadResult{message.Nil, err}
 				return
@@ -37,12 +38,12 @@ func StartReader(c echo.Context, ws *websocket.Conn, readResults chan<- ReadResu
 	}
 }
 
-func read(ctx context.Context, ws *websocket.Conn) (message.Message, error) {
+func read(ctx context.Context, ws *websocket.Conn, codec message.Codec) (message.Message, error) {
 	_, r, err := ws.Reader(ctx)
 	if err != nil {
 		return message.Nil, err
 	}
-	msg, err := message.JsonDecodeBaseMsg(json.NewDecoder(r))
+	msg, err := codec.Decode(r)
 	if err != nil {
 		return message.Nil, err
 	}
@@ -50,47 +51,132 @@ func read(ctx context.Context, ws *websocket.Conn) (message.Message, error) {
 	return msg, nil
 }
 
+// StartHeartbeat pings the client every interval and reports the round trip
+// times. A client that does not answer within timeout is gone, its
+// connection is closed, which stops the reader too.
+func StartHeartbeat(c echo.Context, ws *websocket.Conn, interval time.Duration, timeout time.Duration, rtts chan<- time.Duration) {
+	ctx := c.Request().Context()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rtt, err := ping(ctx, ws, timeout)
+			if err != nil {
+				if ctx.Err() == nil {
+					_ = ws.Close(websocket.StatusGoingAway, "pong timeout")
+				}
+				return
+			}
+			select {
+			case rtts <- rtt:
+			default:
+			}
+		}
+	}
+}
+
+func ping(ctx context.Context, ws *websocket.Conn, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sent := time.Now()
+	if err := ws.Ping(ctx); err != nil {
+		return 0, err
+	}
+	return time.Since(sent), nil
+}
+
 type WriteRequest struct {
 	MsgType string
 	Payload any
+	// LobbyId is set on messages sent by a lobby
+	LobbyId uuid.UUID
+	// RequestId is the id of the request a json.v2 reply is about
+	RequestId string
+	// Seq is the lobby's sequence number of a message sent by a lobby
+	Seq uint64
+	// Written is closed once the message has been written, if set
+	Written chan struct{}
 }
 
-func StartWriter(c echo.Context, ws *websocket.Conn, writeResults chan<- error, writeRequests <-chan WriteRequest) {
+// StartWriter writes messages in the version and encoding of the
+// connection's protocol. Only the first failed write is reported on
+// writeResults, the messages that follow are dropped, so that neither the
+// writer nor whoever hands it messages ever waits on the other.
+func StartWriter(c echo.Context, ws *websocket.Conn, protocol message.Protocol, writeResults chan<- error, writeRequests <-chan WriteRequest) {
 	ctx := c.Request().Context()
 	defer close(writeResults)
+	failed := false
 	for {
 		select {
 		case <-ctx.Done():
-			writeResults <- ctx.Err()
+			if !failed {
+				writeResults <- ctx.Err()
+			}
 			return
 		case wr := <-writeRequests:
-			err := writeTimeout(ctx, ws, wr)
-			writeResults <- err
-			if err != nil {
-				return
+			if failed {
+				continue
+			}
+			if err := writeTimeout(ctx, ws, protocol, wr); err != nil {
+				writeResults <- err
+				failed = true
 			}
 		}
 	}
 }
 
-func writeTimeout(ctx context.Context, ws *websocket.Conn, wr WriteRequest) error {
+func writeTimeout(ctx context.Context, ws *websocket.Conn, protocol message.Protocol, wr WriteRequest) error {
 	timeout := time.Second
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	msg, err := message.NewV1(wr.MsgType, wr.Payload)
+	if protocol.Version != message.V2 && wr.MsgType == message.TypeAck {
+		return nil
+	}
+	msg, err := encode(protocol.Version, wr)
 	if err != nil {
 		return err
 	}
-	data, err := json.Marshal(msg)
+	data, err := protocol.Codec.Encode(msg)
 	if err != nil {
 		return err
 	}
 
-	err = ws.Write(ctx, websocket.MessageText, data)
+	err = ws.Write(ctx, protocol.Codec.FrameType(), data)
 	if err != nil {
 		return err
 	}
+	if wr.Written != nil {
+		close(wr.Written)
+	}
 
 	return nil
 }
+
+// encode turns a write request into a message of the given version. json.v2
+// clients get a nack for the request an error is about, json.v1 clients
+// neither request ids nor sequence numbers.
+func encode(version string, wr WriteRequest) (message.Message, error) {
+	var msg message.Message
+	var err error
+	if version == message.V2 {
+		if errPayload, isErr := wr.Payload.(message.ErrorPayload); isErr {
+			wr.MsgType, wr.RequestId = message.TypeNack, errPayload.ProblematicMsg.Id
+			wr.Payload = message.NackPayload{Code: errPayload.Code, Err: errPayload.Err}
+		}
+		msg, err = message.NewV2(wr.MsgType, wr.Payload, wr.RequestId, wr.Seq)
+	} else {
+		msg, err = message.NewV1(wr.MsgType, wr.Payload)
+	}
+	if err != nil {
+		return message.Nil, err
+	}
+	if wr.LobbyId != uuid.Nil {
+		msg.LobbyId = wr.LobbyId.String()
+	}
+	return msg, nil
+}
@@ -0,0 +1,150 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"github.com/coder/websocket"
+	"github.com/labstack/echo/v4"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// heartbeatServer runs StartHeartbeat on every connection it accepts, and
+// reports the round trip times it measures and when the heartbeat stopped.
+func heartbeatServer(t *testing.T, interval time.Duration, timeout time.Duration) (string, <-chan time.Duration, <-chan struct{}) {
+	t.Helper()
+	rtts := make(chan time.Duration, 16)
+	stopped := make(chan struct{})
+	e := echo.New()
+	e.GET(
+		"/", func(c echo.Context) error {
+			ws, err := websocket.Accept(c.Response(), c.Request(), nil)
+			if err != nil {
+				return err
+			}
+			defer ws.CloseNow()
+			// the handler reads like the real one does, pongs are only
+			// noticed while reading
+			go func() {
+				for {
+					if _, _, err := ws.Read(c.Request().Context()); err != nil {
+						return
+					}
+				}
+			}()
+			StartHeartbeat(c, ws, interval, timeout, rtts)
+			close(stopped)
+			return nil
+		},
+	)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), rtts, stopped
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ws, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.CloseNow() })
+	return ws
+}
+
+func TestHeartbeatMeasuresLatency(t *testing.T) {
+	url, rtts, _ := heartbeatServer(t, 10*time.Millisecond, time.Second)
+	ws := dial(t, url)
+	// reading answers the server's pings
+	ws.CloseRead(context.Background())
+
+	for range 3 {
+		select {
+		case rtt := <-rtts:
+			if rtt <= 0 || rtt >= time.Second {
+				t.Errorf("round trip time %v", rtt)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no round trip time reported")
+		}
+	}
+}
+
+func TestHeartbeatClosesUnansweredConnections(t *testing.T) {
+	url, _, stopped := heartbeatServer(t, 10*time.Millisecond, 50*time.Millisecond)
+	ws := dial(t, url)
+
+	// a client that does not read never answers pings, the server gives up
+	// on it after the first timeout and waits for the close handshake
+	time.Sleep(200 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, _, err := ws.Read(ctx)
+	var closeErr websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.StatusGoingAway || closeErr.Reason != "pong timeout" {
+		t.Errorf("read error %v, want a close for the pong timeout", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat still running")
+	}
+}
+
+func TestHeartbeatStopsWithRequest(t *testing.T) {
+	url, _, stopped := heartbeatServer(t, 10*time.Millisecond, time.Second)
+	ws := dial(t, url)
+	ws.CloseRead(context.Background())
+	if err := ws.Close(websocket.StatusNormalClosure, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat still running after the client left")
+	}
+}
+
+// TestHeartbeatDoesNotWaitForReports checks that round trip times nobody
+// takes are dropped rather than holding up the pings.
+func TestHeartbeatDoesNotWaitForReports(t *testing.T) {
+	rtts := make(chan time.Duration)
+	e := echo.New()
+	done := make(chan struct{})
+	e.GET(
+		"/", func(c echo.Context) error {
+			ws, err := websocket.Accept(c.Response(), c.Request(), nil)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(c.Request().Context(), 100*time.Millisecond)
+			defer cancel()
+			go func() {
+				for {
+					if _, _, err := ws.Read(ctx); err != nil {
+						return
+					}
+				}
+			}()
+			c.SetRequest(c.Request().WithContext(ctx))
+			StartHeartbeat(c, ws, 5*time.Millisecond, time.Second, rtts)
+			close(done)
+			return ws.Close(websocket.StatusNormalClosure, "")
+		},
+	)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	ws := dial(t, "ws"+strings.TrimPrefix(server.URL, "http"))
+	ws.CloseRead(context.Background())
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat blocked on its reports")
+	}
+}
@@ -7,4 +7,8 @@ export default {
   games: {
     play: "/games/play",
   },
+  challenges: "/challenges",
+  correspondence: "/correspondence",
+  tournaments: "/tournaments",
+  simuls: "/simuls",
 };
@@ -1,24 +1,95 @@
-export const Color = ["none", "Red", "Yellow"];
+export const Color = ["none", "Red", "Yellow", "Green", "Blue"];
 
 export const MESSAGE_TYPES = {
   WAITING_FOR_GAME: "waitingForGame",
+  SEARCH_GAME: "searchGame",
+  CANCEL_SEARCH: "cancelSearch",
+  QUEUE_STATUS: "queueStatus",
+  BOT_OFFER: "botOffer",
+  ACCEPT_BOT: "acceptBot",
+  CHALLENGE: "challenge",
+  CHALLENGE_ANSWERED: "challengeAnswered",
+  PLAY_CORRESPONDENCE_MOVE: "playCorrespondenceMove",
+  CORRESPONDENCE_MOVE: "correspondenceMove",
   FOUND_GAME: "foundGame",
   CHAT_MESSAGE: "chatMessage",
   PLAY_MOVE: "playMove",
   PLAYED_MOVE: "playedMove",
   GAME_OVER: "gameOver",
+  JOIN_ARENA: "joinArena",
+  BERSERK: "berserk",
+  BERSERKED: "berserked",
+  ARENA_LEADERBOARD: "arenaLeaderboard",
+  LATENCY: "latency",
+  ACK: "ack",
+  NACK: "nack",
+  AUTH: "auth",
 } as const;
 
 export type MessageType = (typeof MESSAGE_TYPES)[keyof typeof MESSAGE_TYPES];
 
 export interface WaitingForGamePayload {}
 
+export interface SearchGamePayload {
+  queue?: string;
+}
+
+export interface CancelSearchPayload {}
+
+export interface QueueStatusPayload {
+  queue: string;
+  waiting: number;
+  elapsed: number;
+  estimatedWait: number;
+}
+
+export interface BotOfferPayload {
+  queue: string;
+}
+
+export interface AcceptBotPayload {}
+
+export interface ChallengePayload {
+  id: string;
+  from: string;
+  variant: string;
+  color: number;
+  daysPerMove?: number;
+  expiresAt: string;
+}
+
+export interface ChallengeAnsweredPayload {
+  id: string;
+  status: "accepted" | "declined" | "cancelled";
+  lobbyId?: string;
+  gameId?: string;
+}
+
+export interface PlayCorrespondenceMovePayload {
+  gameId: string;
+  column: number;
+}
+
+export interface CorrespondenceMovePayload {
+  gameId: string;
+  color?: number;
+  row: number;
+  column: number;
+  over?: boolean;
+  winner?: number;
+  timedOut?: number;
+  deadline?: string;
+}
+
 export interface FoundGamePayload {
   lobbyId: string;
+  variant: string;
   color: number;
   state: number[][];
   lastPlayed: number;
+  toMove: number;
   messages: ChatMessagePayload[];
+  bot?: boolean;
 }
 
 export interface ChatMessagePayload {
@@ -38,19 +109,88 @@ export interface PlayedMovePayload {
 
 export interface GameOverPayload {
   winner: number;
+  ranking?: number[];
+}
+
+export interface JoinArenaPayload {
+  tournamentId: string;
+}
+
+export interface BerserkPayload {}
+
+export interface BerserkedPayload {
+  color: number;
+}
+
+export interface ArenaStandingPayload {
+  rank: number;
+  username: string;
+  score: number;
+  played: number;
+  streak?: number;
+  onFire?: boolean;
+}
+
+export interface ArenaLeaderboardPayload {
+  tournamentId: string;
+  endsAt: string;
+  over?: boolean;
+  standings: ArenaStandingPayload[];
+}
+
+export interface LatencyPayload {
+  color: number;
+  /** Round trip time in milliseconds. */
+  rtt: number;
+}
+
+export interface AckPayload {}
+
+export interface NackPayload {
+  code: number;
+  err?: string;
+}
+
+/** Sent first on a socket opened without credentials. */
+export interface AuthPayload {
+  token?: string;
+  ticket?: string;
 }
 
 export type Payload =
   | WaitingForGamePayload
+  | SearchGamePayload
+  | CancelSearchPayload
+  | QueueStatusPayload
+  | BotOfferPayload
+  | AcceptBotPayload
+  | ChallengePayload
+  | ChallengeAnsweredPayload
+  | PlayCorrespondenceMovePayload
+  | CorrespondenceMovePayload
   | FoundGamePayload
   | ChatMessagePayload
   | PlayMovePayload
   | PlayedMovePayload
-  | GameOverPayload;
+  | GameOverPayload
+  | JoinArenaPayload
+  | BerserkPayload
+  | BerserkedPayload
+  | ArenaLeaderboardPayload
+  | LatencyPayload
+  | AckPayload
+  | NackPayload
+  | AuthPayload;
 
 export interface Message<T extends Payload = Payload> {
   version: string;
   type: MessageType;
+  /** The game the message is about, needed when playing several games. */
+  lobbyId?: string;
+  /** The request id a json.v2 client sent, echoed by acks and nacks. */
+  id?: string;
+  /** Numbers the messages about a lobby on json.v2 connections. */
+  seq?: number;
   payload: T;
 }
 
@@ -64,6 +204,45 @@ export interface WaitingForGameMessage extends Message<WaitingForGamePayload> {
   type: typeof MESSAGE_TYPES.WAITING_FOR_GAME;
 }
 
+export interface SearchGameMessage extends Message<SearchGamePayload> {
+  type: typeof MESSAGE_TYPES.SEARCH_GAME;
+}
+
+export interface CancelSearchMessage extends Message<CancelSearchPayload> {
+  type: typeof MESSAGE_TYPES.CANCEL_SEARCH;
+}
+
+export interface QueueStatusMessage extends Message<QueueStatusPayload> {
+  type: typeof MESSAGE_TYPES.QUEUE_STATUS;
+}
+
+export interface BotOfferMessage extends Message<BotOfferPayload> {
+  type: typeof MESSAGE_TYPES.BOT_OFFER;
+}
+
+export interface AcceptBotMessage extends Message<AcceptBotPayload> {
+  type: typeof MESSAGE_TYPES.ACCEPT_BOT;
+}
+
+export interface ChallengeMessage extends Message<ChallengePayload> {
+  type: typeof MESSAGE_TYPES.CHALLENGE;
+}
+
+export interface ChallengeAnsweredMessage
+  extends Message<ChallengeAnsweredPayload> {
+  type: typeof MESSAGE_TYPES.CHALLENGE_ANSWERED;
+}
+
+export interface PlayCorrespondenceMoveMessage
+  extends Message<PlayCorrespondenceMovePayload> {
+  type: typeof MESSAGE_TYPES.PLAY_CORRESPONDENCE_MOVE;
+}
+
+export interface CorrespondenceMoveMessage
+  extends Message<CorrespondenceMovePayload> {
+  type: typeof MESSAGE_TYPES.CORRESPONDENCE_MOVE;
+}
+
 export interface FoundGameMessage extends Message<FoundGamePayload> {
   type: typeof MESSAGE_TYPES.FOUND_GAME;
 }
@@ -84,18 +263,90 @@ export interface GameOverMessage extends Message<GameOverPayload> {
   type: typeof MESSAGE_TYPES.GAME_OVER;
 }
 
+export interface JoinArenaMessage extends Message<JoinArenaPayload> {
+  type: typeof MESSAGE_TYPES.JOIN_ARENA;
+}
+
+export interface BerserkMessage extends Message<BerserkPayload> {
+  type: typeof MESSAGE_TYPES.BERSERK;
+}
+
+export interface BerserkedMessage extends Message<BerserkedPayload> {
+  type: typeof MESSAGE_TYPES.BERSERKED;
+}
+
+export interface ArenaLeaderboardMessage
+  extends Message<ArenaLeaderboardPayload> {
+  type: typeof MESSAGE_TYPES.ARENA_LEADERBOARD;
+}
+
+export interface LatencyMessage extends Message<LatencyPayload> {
+  type: typeof MESSAGE_TYPES.LATENCY;
+}
+
+export interface AckMessage extends Message<AckPayload> {
+  type: typeof MESSAGE_TYPES.ACK;
+}
+
+export interface NackMessage extends Message<NackPayload> {
+  type: typeof MESSAGE_TYPES.NACK;
+}
+
+export interface AuthMessage extends Message<AuthPayload> {
+  type: typeof MESSAGE_TYPES.AUTH;
+}
+
 export type WebsocketMessage =
   | WaitingForGameMessage
+  | SearchGameMessage
+  | CancelSearchMessage
+  | QueueStatusMessage
+  | BotOfferMessage
+  | AcceptBotMessage
+  | ChallengeMessage
+  | ChallengeAnsweredMessage
+  | PlayCorrespondenceMoveMessage
+  | CorrespondenceMoveMessage
   | FoundGameMessage
   | ChatMessage
   | PlayMoveMessage
   | PlayedMoveMessage
-  | GameOverMessage;
+  | GameOverMessage
+  | JoinArenaMessage
+  | BerserkMessage
+  | BerserkedMessage
+  | ArenaLeaderboardMessage
+  | LatencyMessage
+  | AckMessage
+  | NackMessage
+  | AuthMessage;
 
 export const isWaitingForGameMessage = (
   msg: WebsocketMessage
 ): msg is WaitingForGameMessage => msg.type === MESSAGE_TYPES.WAITING_FOR_GAME;
 
+export const isQueueStatusMessage = (
+  msg: WebsocketMessage
+): msg is QueueStatusMessage => msg.type === MESSAGE_TYPES.QUEUE_STATUS;
+
+export const isBotOfferMessage = (
+  msg: WebsocketMessage
+): msg is BotOfferMessage => msg.type === MESSAGE_TYPES.BOT_OFFER;
+
+export const isChallengeMessage = (
+  msg: WebsocketMessage
+): msg is ChallengeMessage => msg.type === MESSAGE_TYPES.CHALLENGE;
+
+export const isChallengeAnsweredMessage = (
+  msg: WebsocketMessage
+): msg is ChallengeAnsweredMessage =>
+  msg.type === MESSAGE_TYPES.CHALLENGE_ANSWERED;
+
+export const isCorrespondenceMoveMessage = (
+  msg: WebsocketMessage
+): msg is CorrespondenceMoveMessage =>
+  msg.type === MESSAGE_TYPES.CORRESPONDENCE_MOVE;
+
 export const isFoundGameMessage = (
   msg: WebsocketMessage
 ): msg is FoundGameMessage => msg.type === MESSAGE_TYPES.FOUND_GAME;
@@ -115,22 +366,93 @@ export const isGameOverMessage = (
   msg: WebsocketMessage
 ): msg is GameOverMessage => msg.type === MESSAGE_TYPES.GAME_OVER;
 
+export const isBerserkedMessage = (
+  msg: WebsocketMessage
+): msg is BerserkedMessage => msg.type === MESSAGE_TYPES.BERSERKED;
+
+export const isArenaLeaderboardMessage = (
+  msg: WebsocketMessage
+): msg is ArenaLeaderboardMessage =>
+  msg.type === MESSAGE_TYPES.ARENA_LEADERBOARD;
+
+export const isLatencyMessage = (
+  msg: WebsocketMessage
+): msg is LatencyMessage => msg.type === MESSAGE_TYPES.LATENCY;
+
+export const isAckMessage = (msg: WebsocketMessage): msg is AckMessage =>
+  msg.type === MESSAGE_TYPES.ACK;
+
+export const isNackMessage = (msg: WebsocketMessage): msg is NackMessage =>
+  msg.type === MESSAGE_TYPES.NACK;
+
 export const createMessage = {
+  auth: (token: string): AuthMessage => ({
+    version: "v1",
+    type: MESSAGE_TYPES.AUTH,
+    payload: { token },
+  }),
+
   waitingForGame: (): WaitingForGameMessage => ({
     version: "v1",
     type: MESSAGE_TYPES.WAITING_FOR_GAME,
     payload: {},
   }),
 
-  chatMessage: (from: string, text: string): ChatMessage => ({
+  searchGame: (queue?: string): SearchGameMessage => ({
+    version: "v1",
+    type: MESSAGE_TYPES.SEARCH_GAME,
+    payload: { queue },
+  }),
+
+  cancelSearch: (): CancelSearchMessage => ({
+    version: "v1",
+    type: MESSAGE_TYPES.CANCEL_SEARCH,
+    payload: {},
+  }),
+
+  acceptBot: (): AcceptBotMessage => ({
+    version: "v1",
+    type: MESSAGE_TYPES.ACCEPT_BOT,
+    payload: {},
+  }),
+
+  joinArena: (tournamentId: string): JoinArenaMessage => ({
+    version: "v1",
+    type: MESSAGE_TYPES.JOIN_ARENA,
+    payload: { tournamentId },
+  }),
+
+  berserk: (lobbyId?: string): BerserkMessage => ({
+    version: "v1",
+    type: MESSAGE_TYPES.BERSERK,
+    lobbyId,
+    payload: {},
+  }),
+
+  playCorrespondenceMove: (
+    gameId: string,
+    column: number
+  ): PlayCorrespondenceMoveMessage => ({
+    version: "v1",
+    type: MESSAGE_TYPES.PLAY_CORRESPONDENCE_MOVE,
+    payload: { gameId, column },
+  }),
+
+  chatMessage: (
+    from: string,
+    text: string,
+    lobbyId?: string
+  ): ChatMessage => ({
     version: "v1",
     type: MESSAGE_TYPES.CHAT_MESSAGE,
+    lobbyId,
     payload: { from, text },
   }),
 
-  playMove: (column: number): PlayMoveMessage => ({
+  playMove: (column: number, lobbyId?: string): PlayMoveMessage => ({
     version: "v1",
     type: MESSAGE_TYPES.PLAY_MOVE,
+    lobbyId,
     payload: { column },
   }),
 
@@ -14,14 +14,32 @@ import {
   ChatMessagePayload,
   WaitingForGamePayload,
   isWaitingForGameMessage,
+  QueueStatusPayload,
+  isQueueStatusMessage,
+  BotOfferPayload,
+  isBotOfferMessage,
+  ChallengePayload,
+  isChallengeMessage,
+  ChallengeAnsweredPayload,
+  isChallengeAnsweredMessage,
+  CorrespondenceMovePayload,
+  isCorrespondenceMoveMessage,
+  LatencyPayload,
+  isLatencyMessage,
 } from "@/api/types";
 
 export type GameSocketHandlers = {
   onFoundGame: (payload: FoundGamePayload) => void;
-  onPlayedMove: (payload: PlayedMovePayload) => void;
-  onGameOver: (payload: GameOverPayload) => void;
-  onChatMessage: (payload: ChatMessagePayload) => void;
+  onPlayedMove: (payload: PlayedMovePayload, lobbyId?: string) => void;
+  onGameOver: (payload: GameOverPayload, lobbyId?: string) => void;
+  onChatMessage: (payload: ChatMessagePayload, lobbyId?: string) => void;
   onWaitingForGame?: (payload: WaitingForGamePayload) => void;
+  onQueueStatus?: (payload: QueueStatusPayload) => void;
+  onBotOffer?: (payload: BotOfferPayload) => void;
+  onChallenge?: (payload: ChallengePayload) => void;
+  onChallengeAnswered?: (payload: ChallengeAnsweredPayload) => void;
+  onCorrespondenceMove?: (payload: CorrespondenceMovePayload) => void;
+  onLatency?: (payload: LatencyPayload, lobbyId?: string) => void;
   onOpen?: (event: Event) => void;
   onClose?: (event: CloseEvent) => void;
   onError?: (event: Event) => void;
@@ -32,6 +50,7 @@ export const useGameSocket = (handlers: GameSocketHandlers) => {
   const socketRef = useRef<WebSocket | null>(null);
   const handlersRef = useRef(handlers);
   const [look, setLook] = useState(false);
+  const queueRef = useRef<string | undefined>(undefined);
   const [connected, setConnected] = useState(false);
 
   useEffect(() => {
@@ -44,13 +63,15 @@ export const useGameSocket = (handlers: GameSocketHandlers) => {
 
     const protocol = window.location.protocol === "https:" ? "wss" : "ws";
     const host = "localhost:8080";
-    const wsUrl = `${protocol}://${host}${paths.base}${paths.games.play}?token=${token}`;
+    const wsUrl = `${protocol}://${host}${paths.base}${paths.games.play}`;
 
     const ws = new WebSocket(wsUrl);
     socketRef.current = ws;
 
     ws.onopen = (event) => {
       console.log("✅ WebSocket connection established.");
+      // authenticate with the first message, tokens in the URL end up in logs
+      ws.send(JSON.stringify(createMessage.auth(token)));
       setConnected(true);
       handlersRef.current.onOpen?.(event);
     };
@@ -61,15 +82,28 @@ export const useGameSocket = (handlers: GameSocketHandlers) => {
         console.log(message);
 
         if (isWaitingForGameMessage(message)) {
+          ws.send(JSON.stringify(createMessage.searchGame(queueRef.current)));
           handlersRef.current.onWaitingForGame?.(message.payload);
+        } else if (isQueueStatusMessage(message)) {
+          handlersRef.current.onQueueStatus?.(message.payload);
+        } else if (isBotOfferMessage(message)) {
+          handlersRef.current.onBotOffer?.(message.payload);
+        } else if (isChallengeMessage(message)) {
+          handlersRef.current.onChallenge?.(message.payload);
+        } else if (isChallengeAnsweredMessage(message)) {
+          handlersRef.current.onChallengeAnswered?.(message.payload);
+        } else if (isCorrespondenceMoveMessage(message)) {
+          handlersRef.current.onCorrespondenceMove?.(message.payload);
         } else if (isFoundGameMessage(message)) {
           handlersRef.current.onFoundGame(message.payload);
         } else if (isPlayedMoveMessage(message)) {
-          handlersRef.current.onPlayedMove(message.payload);
+          handlersRef.current.onPlayedMove(message.payload, message.lobbyId);
         } else if (isGameOverMessage(message)) {
-          handlersRef.current.onGameOver(message.payload);
+          handlersRef.current.onGameOver(message.payload, message.lobbyId);
         } else if (isChatMessage(message)) {
-          handlersRef.current.onChatMessage(message.payload);
+          handlersRef.current.onChatMessage(message.payload, message.lobbyId);
+        } else if (isLatencyMessage(message)) {
+          handlersRef.current.onLatency?.(message.payload, message.lobbyId);
         }
       } catch (error) {
         console.error("❌ Error parsing WebSocket message:", error);
@@ -106,20 +140,29 @@ export const useGameSocket = (handlers: GameSocketHandlers) => {
     }
   }, []);
 
-  const sendWaitingForGame = useCallback(() => {
+  const sendWaitingForGame = useCallback((queue?: string) => {
+    queueRef.current = queue;
     setLook(true);
+  }, []);
+
+  const sendCancelSearch = useCallback(() => {
+    sendMessage(createMessage.cancelSearch());
+  }, [sendMessage]);
+
+  const sendAcceptBot = useCallback(() => {
+    sendMessage(createMessage.acceptBot());
   }, [sendMessage]);
 
   const sendPlayMove = useCallback(
-    (column: number) => {
-      sendMessage(createMessage.playMove(column));
+    (column: number, lobbyId?: string) => {
+      sendMessage(createMessage.playMove(column, lobbyId));
     },
     [sendMessage]
   );
 
   const sendChatMessage = useCallback(
-    (from: string, text: string) => {
-      sendMessage(createMessage.chatMessage(from, text));
+    (from: string, text: string, lobbyId?: string) => {
+      sendMessage(createMessage.chatMessage(from, text, lobbyId));
     },
     [sendMessage]
   );
@@ -128,6 +171,8 @@ export const useGameSocket = (handlers: GameSocketHandlers) => {
     readyState: socketRef.current?.readyState ?? WebSocket.CLOSED,
     connected,
     sendWaitingForGame,
+    sendCancelSearch,
+    sendAcceptBot,
     sendPlayMove,
     sendChatMessage,
   };
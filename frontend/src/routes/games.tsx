@@ -34,12 +34,15 @@ function GamePageComponent() {
   const [winner, setWinner] = useState<GameOverPayload | null>(null);
   const [chatMessages, setChatMessages] = useState<ChatMessagePayload[]>([]);
   const [chatInput, setChatInput] = useState("");
+  const [opponentRtt, setOpponentRtt] = useState<number | null>(null);
+  const colorRef = useRef<number | null>(null);
   const chatScrollAreaRef = useRef<HTMLDivElement>(null);
 
   const handlers = useCallback(
     (): GameSocketHandlers => ({
       onWaitingForGame: () => setStatus("waiting"),
       onFoundGame: (payload) => {
+        colorRef.current = payload.color;
         setGameInfo(payload);
         setStatus("playing");
         setWinner(null);
@@ -52,10 +55,9 @@ function GamePageComponent() {
           }
           return b;
         });
-        setActivePlayer(
-          payload.lastPlayed !== 0 ? (payload.lastPlayed === 1 ? 2 : 1) : 1
-        );
+        setActivePlayer(payload.toMove);
         setChatMessages(payload.messages);
+        setOpponentRtt(null);
       },
 
       onPlayedMove: (payload) => {
@@ -76,6 +78,11 @@ function GamePageComponent() {
       onChatMessage: (payload) => {
         setChatMessages((prev) => [...prev, payload]);
       },
+      onLatency: (payload) => {
+        if (payload.color !== colorRef.current) {
+          setOpponentRtt(payload.rtt);
+        }
+      },
     }),
     []
   );
@@ -200,6 +207,11 @@ function GamePageComponent() {
                 </span>
               </p>
             )}
+            {gameInfo && opponentRtt !== null && (
+              <p>
+                <strong>Opponent Latency:</strong> {opponentRtt} ms
+              </p>
+            )}
             {status === "over" && (
               <Button onClick={handleFindGame} className="w-full mt-4">
                 Play Again